@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// runInit acts as a minimal init when the daemon is PID 1 in a container:
+// the daemon runs as a child, signals are forwarded to it and orphaned
+// processes, e.g. OCR helpers of a killed ocrmypdf, are reaped. It only
+// returns if the process isn't PID 1.
+func runInit() {
+	if os.Getpid() != 1 {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		slog.Warn("Running as PID 1 without reaping orphans", "error", err)
+		return
+	}
+
+	// subscribe before the start, so no exit is missed
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		slog.Error("Unable to start the daemon", "error", err)
+		os.Exit(1)
+	}
+
+	for {
+		select {
+		case sig := <-sigs:
+			cmd.Process.Signal(sig)
+		case <-chld:
+			// the process is never waited for through cmd, all
+			// children are reaped here
+			for {
+				var ws syscall.WaitStatus
+				pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+				if err != nil || pid <= 0 {
+					break
+				}
+				if pid != cmd.Process.Pid {
+					continue
+				}
+				if ws.Signaled() {
+					os.Exit(128 + int(ws.Signal()))
+				}
+				os.Exit(ws.ExitStatus())
+			}
+		}
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// runInit is only needed in Linux containers.
+func runInit() {}
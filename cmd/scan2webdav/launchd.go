@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var plist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Program}}</string>
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>CONFIG_FILE</key>
+		<string>{{xml .Config}}</string>
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ProcessType</key>
+	<string>Background</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+// launchdCommand prints a launchd property list that runs the daemon with
+// a config file, for ~/Library/LaunchAgents or /Library/LaunchDaemons.
+func launchdCommand(args []string) int {
+	flags := flag.NewFlagSet("launchd", flag.ContinueOnError)
+	label := flags.String("label", "com.github.chbmuc.scan2webdav", "job `label`")
+	config := flags.String("config", "", "config `file` of the daemon")
+	log := flags.String("log", "/tmp/scan2webdav.log", "log `file`")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *config == "" {
+		fmt.Fprintln(os.Stderr, "-config is required, launchd jobs don't see the environment")
+		return 2
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err == nil {
+		*config, err = filepath.Abs(*config)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = plist.Execute(os.Stdout, map[string]string{"Label": *label, "Program": exe, "Config": *config, "Log": *log})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,58 @@
+// Command scan2webdav watches a directory for scans, runs them through OCR
+// and uploads the results.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/chbmuc/scan2webdav/pipeline"
+)
+
+func main() {
+	runInit()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "service":
+			os.Exit(serviceCommand(os.Args[2:]))
+		case "launchd":
+			os.Exit(launchdCommand(os.Args[2:]))
+		}
+	}
+
+	cfg, err := pipeline.LoadConfig()
+	if err != nil {
+		slog.Error("Unable to load config", "error", err)
+		os.Exit(1)
+	}
+	if err := pipeline.SetupLogging(cfg); err != nil {
+		slog.Error("Unable to set up logging", "error", err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 {
+		os.Exit(pipeline.RunCommand(cfg, os.Args[1:]))
+	}
+
+	// the first signal shuts down gracefully, a second one aborts the
+	// running jobs
+	ctx, stop := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sigs
+		stop()
+		<-sigs
+		slog.Warn("Signal received again")
+		pipeline.Abort()
+	}()
+
+	handlePauseSignals()
+
+	if err := pipeline.Run(ctx, cfg); err != nil {
+		slog.Error("Exiting", "error", err)
+		os.Exit(1)
+	}
+}
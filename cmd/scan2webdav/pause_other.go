@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/chbmuc/scan2webdav/pipeline"
+)
+
+// handlePauseSignals pauses the pipeline on SIGUSR1 and resumes it on
+// SIGUSR2.
+func handlePauseSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGUSR1 {
+				pipeline.Pause()
+			} else {
+				pipeline.Resume()
+			}
+		}
+	}()
+}
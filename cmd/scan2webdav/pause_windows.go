@@ -0,0 +1,5 @@
+package main
+
+// handlePauseSignals does nothing, Windows has no user signals. The pause
+// and resume commands work everywhere.
+func handlePauseSignals() {}
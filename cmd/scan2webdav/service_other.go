@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func serviceCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "services are only managed on Windows, use the init system elsewhere")
+	return 2
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/chbmuc/scan2webdav/pipeline"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceCommand installs, removes or runs the Windows service. A service
+// gets no environment of its own, so it reads its settings from the config
+// file given at install time.
+func serviceCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: scan2webdav service install|uninstall|run [flags]")
+		return 2
+	}
+	flags := flag.NewFlagSet("service "+args[0], flag.ContinueOnError)
+	name := flags.String("name", "scan2webdav", "service `name`")
+	config := flags.String("config", "", "config `file` of the service")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(*name, *config)
+	case "uninstall":
+		err = uninstallService(*name)
+	case "run":
+		err = runService(*name, *config)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service command %q\n", args[0])
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func installService(name string, config string) error {
+	if config == "" {
+		return fmt.Errorf("-config is required, services don't see the environment")
+	}
+	config, err := filepath.Abs(config)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "Runs OCR on scans and uploads them",
+		StartType:   mgr.StartAutomatic,
+	}, "service", "run", "-name", name, "-config", config)
+	if err != nil {
+		return err
+	}
+	s.Close()
+	fmt.Printf("service %s installed\n", name)
+	return nil
+}
+
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("service %s removed\n", name)
+	return nil
+}
+
+// runService is started by the service manager.
+func runService(name string, config string) error {
+	if config != "" {
+		os.Setenv("CONFIG_FILE", config)
+	}
+	cfg, err := pipeline.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := pipeline.SetupLogging(cfg); err != nil {
+		return err
+	}
+	return svc.Run(name, &service{cfg: cfg})
+}
+
+type service struct {
+	cfg pipeline.Config
+}
+
+// Execute maps the requests of the service manager onto the daemon: the
+// first stop shuts down gracefully, a second one aborts the running jobs.
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- pipeline.Run(ctx, s.cfg) }()
+
+	accepts := svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case err := <-done:
+			stop()
+			if err != nil {
+				slog.Error("Exiting", "error", err)
+				return false, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				if ctx.Err() != nil {
+					pipeline.Abort()
+				}
+				stop()
+				status <- svc.Status{State: svc.StopPending, Accepts: accepts}
+			}
+		}
+	}
+}
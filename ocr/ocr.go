@@ -0,0 +1,178 @@
+// Package ocr runs ocrmypdf, either installed locally or in a container,
+// with the resource limits of the options.
+package ocr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Options select the OCR engine and limit its resources.
+type Options struct {
+	Exec     string // ocrmypdf binary of the local engine
+	Engine   string // local or docker
+	Image    string // image used by the docker engine
+	Nice     int
+	IoClass  int    // 1 realtime, 2 best-effort, 3 idle
+	IoPrio   int    // level within the class
+	CpuQuota string // e.g. 50%, needs systemd for the local engine
+	// user the engine runs as, name or uid[:gid], with a restricted
+	// environment; the daemon's if empty
+	User string
+	Env  []string // variables passed on to User besides the defaults
+}
+
+var errNotRoot = errors.New("running OCR as another user needs root")
+
+// Usage is what a command used. The docker engine only tells that of the
+// docker client.
+type Usage struct {
+	Wall   time.Duration
+	CPU    time.Duration // user and system time
+	MaxRSS int64         // peak resident memory in bytes, 0 if unknown
+}
+
+// Command returns the command line that runs ocrmypdf with args. The
+// docker engine bind mounts the mounts at the same path, so the arguments
+// don't need rewriting.
+func Command(opts Options, mounts []string, args []string) (string, []string) {
+	if opts.Engine == "docker" {
+		return dockerCommand(opts, "", mounts, args)
+	}
+	if runtime.GOOS == "windows" {
+		// neither nice nor ionice nor systemd
+		return opts.Exec, args
+	}
+	return niceCommand(opts, opts.Exec, args)
+}
+
+// Tesseract returns the command line that runs tesseract, the OCR engine
+// of ocrmypdf, with args. The docker engine takes it from the same image.
+func Tesseract(opts Options, mounts []string, args []string) (string, []string) {
+	if opts.Engine == "docker" {
+		return dockerCommand(opts, "tesseract", mounts, args)
+	}
+	if runtime.GOOS == "windows" {
+		return "tesseract", args
+	}
+	return niceCommand(opts, "tesseract", args)
+}
+
+// Run executes the command and returns its combined output. On
+// cancellation ocrmypdf gets a SIGTERM and the chance to stop its
+// children. Windows has no SIGTERM, there it is killed. The local engine
+// runs as the user of the options, the docker engine passes it to the
+// container.
+func Run(ctx context.Context, opts Options, name string, args []string) ([]byte, Usage, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if opts.User != "" && opts.Engine != "docker" {
+		acc, err := lookupUser(opts.User)
+		if err != nil {
+			return nil, Usage{}, err
+		}
+		runAs(cmd, acc)
+		cmd.Env = restrictedEnv(acc, opts.Env)
+	}
+	cmd.Cancel = func() error {
+		if runtime.GOOS == "windows" {
+			return cmd.Process.Kill()
+		}
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = 10 * time.Second
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	u := Usage{Wall: time.Since(start)}
+	if ps := cmd.ProcessState; ps != nil {
+		u.CPU = ps.UserTime() + ps.SystemTime()
+		u.MaxRSS = maxRSS(ps)
+	}
+	return out, u, err
+}
+
+// Version returns the version ocrmypdf reports, e.g. 16.4.2.
+func Version(ctx context.Context, opts Options) (string, error) {
+	name, args := Command(opts, nil, []string{"--version"})
+	out, _, err := Run(ctx, opts, name, args)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	// docker may log the pull of the image first
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
+
+var outputTypes = regexp.MustCompile(`--output-type \{([^}]*)\}`)
+
+// OutputTypes returns the values ocrmypdf accepts for --output-type, as
+// listed in its help.
+func OutputTypes(ctx context.Context, opts Options) ([]string, error) {
+	name, args := Command(opts, nil, []string{"--help"})
+	out, _, err := Run(ctx, opts, name, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	m := outputTypes.FindSubmatch(out)
+	if m == nil {
+		return nil, fmt.Errorf("no --output-type in the help of %s", name)
+	}
+	return strings.Split(string(m[1]), ","), nil
+}
+
+// niceCommand wraps the OCR command with nice, ionice and a systemd scope
+// with a CPU quota as configured. The latter two only exist on Linux.
+func niceCommand(opts Options, name string, args []string) (string, []string) {
+	cmd := append([]string{name}, args...)
+	if opts.Nice != 0 {
+		cmd = append([]string{"nice", "-n", strconv.Itoa(opts.Nice)}, cmd...)
+	}
+	switch {
+	case runtime.GOOS != "linux":
+	case opts.IoClass == 0:
+	case opts.IoClass == 3:
+		// the idle class has no levels
+		cmd = append([]string{"ionice", "-c", "3"}, cmd...)
+	default:
+		cmd = append([]string{"ionice", "-c", strconv.Itoa(opts.IoClass), "-n", strconv.Itoa(opts.IoPrio)}, cmd...)
+	}
+	if opts.CpuQuota != "" && runtime.GOOS == "linux" {
+		cmd = append([]string{"systemd-run", "--scope", "--quiet", "-p", "CPUQuota=" + opts.CpuQuota}, cmd...)
+	}
+	return cmd[0], cmd[1:]
+}
+
+// dockerCommand runs the OCR inside a container of the image, with another
+// entrypoint if it isn't empty.
+func dockerCommand(opts Options, entrypoint string, mounts []string, args []string) (string, []string) {
+	cmd := []string{"run", "--rm"}
+	// Docker Desktop maps the file owners itself
+	if runtime.GOOS != "windows" {
+		uid, gid := os.Getuid(), os.Getgid()
+		if acc, err := lookupUser(opts.User); opts.User != "" && err == nil {
+			uid, gid = acc.uid, acc.gid
+		}
+		cmd = append(cmd, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	for _, m := range mounts {
+		cmd = append(cmd, "-v", m+":"+m)
+	}
+	if quota := strings.TrimSuffix(opts.CpuQuota, "%"); quota != "" {
+		if pct, err := strconv.ParseFloat(quota, 64); err == nil {
+			cmd = append(cmd, "--cpus", strconv.FormatFloat(pct/100, 'f', 2, 64))
+		}
+	}
+	if entrypoint != "" {
+		cmd = append(cmd, "--entrypoint", entrypoint)
+	}
+	cmd = append(cmd, opts.Image)
+	return "docker", append(cmd, args...)
+}
@@ -0,0 +1,8 @@
+package ocr
+
+import "os"
+
+// maxRSS isn't known on Windows.
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
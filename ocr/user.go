@@ -0,0 +1,95 @@
+package ocr
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// passEnv are the variables of the daemon the OCR user gets, besides
+// those of Options.Env.
+var passEnv = []string{"PATH", "LANG", "LC_ALL", "LC_CTYPE", "TZ", "TMPDIR", "OMP_THREAD_LIMIT", "TESSDATA_PREFIX"}
+
+// account is the user the local engine runs as.
+type account struct {
+	uid, gid int
+	home     string
+}
+
+// lookupUser resolves a user name or uid[:gid]. A uid without a gid takes
+// the primary group of the user if known, otherwise the uid.
+func lookupUser(spec string) (account, error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	acc := account{home: "/"}
+	if uid, err := strconv.Atoi(name); err == nil {
+		acc.uid, acc.gid = uid, uid
+		if u, err := user.LookupId(name); err == nil {
+			acc.gid, _ = strconv.Atoi(u.Gid)
+			acc.home = u.HomeDir
+		}
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return acc, err
+		}
+		acc.uid, _ = strconv.Atoi(u.Uid)
+		acc.gid, _ = strconv.Atoi(u.Gid)
+		acc.home = u.HomeDir
+	}
+	if hasGroup {
+		gid, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return acc, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+		acc.gid = gid
+	}
+	if acc.uid == 0 {
+		return acc, fmt.Errorf("%s is root", spec)
+	}
+	return acc, nil
+}
+
+// CheckUser makes sure the user of the options exists and the engine can
+// run as it.
+func CheckUser(opts Options) error {
+	if opts.User == "" {
+		return nil
+	}
+	if err := canSwitchUser(); err != nil {
+		return err
+	}
+	_, err := lookupUser(opts.User)
+	return err
+}
+
+// Own gives the user of the options the files below dir, so the engine
+// can read its input and write its output there. It does nothing without
+// a user.
+func Own(opts Options, dir string) error {
+	if opts.User == "" {
+		return nil
+	}
+	acc, err := lookupUser(opts.User)
+	if err != nil {
+		return err
+	}
+	return chownAll(dir, acc)
+}
+
+// restrictedEnv returns the environment of the OCR user: the variables of
+// passEnv and extra that are set, and HOME.
+func restrictedEnv(acc account, extra []string) []string {
+	env := []string{"HOME=" + acc.home}
+	for _, name := range append(passEnv, extra...) {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
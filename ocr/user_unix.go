@@ -0,0 +1,34 @@
+//go:build !windows
+
+package ocr
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+func canSwitchUser() error {
+	if os.Geteuid() != 0 {
+		return errNotRoot
+	}
+	return nil
+}
+
+// runAs makes cmd run as acc.
+func runAs(cmd *exec.Cmd, acc account) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(acc.uid), Gid: uint32(acc.gid), Groups: []uint32{}},
+	}
+}
+
+func chownAll(dir string, acc account) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, acc.uid, acc.gid)
+	})
+}
@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// alert is sent when failures cross ALERT_CONSECUTIVE or ALERT_RATE.
+type alert struct {
+	Type        string    `json:"type"` // always alert
+	Time        time.Time `json:"time"`
+	Reason      string    `json:"reason"`
+	Consecutive int       `json:"consecutive"`
+	Rate        float64   `json:"rate"` // failures in the window
+	Window      int       `json:"window"`
+	LastError   string    `json:"last_error,omitempty"`
+	Paused      bool      `json:"paused"`
+}
+
+// alerts tracks the outcome of the last jobs. An alert fires once when a
+// threshold is crossed and is armed again by the next success.
+var alerts struct {
+	sync.Mutex
+	consecutive int
+	window      []bool // true for failures, oldest first
+	fired       bool
+}
+
+// observeFailure records the outcome of a job or an upload attempt.
+func observeFailure(cfg Config, failed bool, err error) {
+	a := cfg.Alert
+	if a.Consecutive <= 0 && a.Rate <= 0 {
+		return
+	}
+	alerts.Lock()
+	alerts.window = append(alerts.window, failed)
+	if len(alerts.window) > a.Window {
+		alerts.window = alerts.window[len(alerts.window)-a.Window:]
+	}
+	if !failed {
+		alerts.consecutive = 0
+		alerts.fired = false
+		alerts.Unlock()
+		return
+	}
+	alerts.consecutive++
+	failures := 0
+	for _, f := range alerts.window {
+		if f {
+			failures++
+		}
+	}
+	rate := 0.0
+	if len(alerts.window) > 0 {
+		rate = float64(failures) / float64(len(alerts.window))
+	}
+
+	var reason string
+	switch {
+	case a.Consecutive > 0 && alerts.consecutive >= a.Consecutive:
+		reason = fmt.Sprintf("%d failures in a row", alerts.consecutive)
+	case a.Rate > 0 && len(alerts.window) >= a.Window && rate >= a.Rate:
+		reason = fmt.Sprintf("%d of the last %d jobs failed", failures, len(alerts.window))
+	}
+	if reason == "" || alerts.fired {
+		alerts.Unlock()
+		return
+	}
+	alerts.fired = true
+	al := alert{
+		Type:        "alert",
+		Time:        time.Now(),
+		Reason:      reason,
+		Consecutive: alerts.consecutive,
+		Rate:        rate,
+		Window:      len(alerts.window),
+		Paused:      a.Pause,
+	}
+	alerts.Unlock()
+	if err != nil {
+		al.LastError = err.Error()
+	}
+
+	slog.Error("Failure threshold crossed", "reason", reason, "pause", a.Pause)
+	if a.Pause {
+		Pause()
+	}
+	text := reason
+	if al.LastError != "" {
+		text += "\nLast error: " + al.LastError
+	}
+	if a.Pause {
+		text += "\nThe pipeline is paused until it is resumed."
+	}
+	go broadcast(cfg, "alert", pushNote{Title: "scan2webdav alert: " + reason, Text: text, Urgent: true}, al)
+}
@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setupApi serves the JSON API for job control below /api/. With
+// HTTP_TOKEN set requests need it as bearer token.
+func setupApi(cfg Config) {
+	handle := func(pattern string, fn http.HandlerFunc) {
+		mux.Handle(pattern, apiAuth(cfg, fn))
+	}
+	handle("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		active, history := listJobs()
+		writeJSONResponse(w, http.StatusOK, map[string]any{
+			"active":  active,
+			"queued":  listQueued(),
+			"history": history,
+		})
+	})
+	handle("POST /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		path, err := submitPath(cfg, req.Path)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		// submitting a file explicitly gives it another chance
+		failures.forget(path)
+		go enqueue(jobCtx, cfg, path, false)
+		writeJSONResponse(w, http.StatusAccepted, map[string]string{"path": path})
+	})
+	handle("GET /api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		info := findJob(r.PathValue("id"))
+		if info == nil {
+			apiError(w, http.StatusNotFound, errJobNotFound)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, info)
+	})
+	handle("GET /api/jobs/{id}/log", jobLogHandler)
+	handle("POST /api/jobs/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		apiResult(w, retryJob(cfg, r.PathValue("id")))
+	})
+	handle("POST /api/jobs/{id}/discard", func(w http.ResponseWriter, r *http.Request) {
+		apiResult(w, discardJob(r.PathValue("id")))
+	})
+	handle("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, http.StatusOK, currentStatus())
+	})
+	handle("GET /api/history", historyHandler)
+	handle("GET /api/search", searchHandler)
+	handle("GET /api/usage", usageHandler)
+	handle("GET /api/deadletters", deadLettersHandler(cfg))
+	handle("POST /api/deadletters/retry", retryDeadLettersHandler(cfg))
+	if cfg.Scan.Command != "" {
+		handle("POST /api/scan", scanHandler(cfg))
+	}
+	handle("GET /api/watches", watchesHandler(cfg))
+	handle("POST /api/watches", watchesHandler(cfg))
+	handle("DELETE /api/watches", watchesHandler(cfg))
+	handle("GET /api/pipeline", pipelineHandler)
+	handle("POST /api/pipeline/pause", func(w http.ResponseWriter, r *http.Request) {
+		Pause()
+		pipelineHandler(w, r)
+	})
+	handle("POST /api/pipeline/resume", func(w http.ResponseWriter, r *http.Request) {
+		Resume()
+		pipelineHandler(w, r)
+	})
+}
+
+func apiAuth(cfg Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Http.Token != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Http.Token)) != 1 {
+				apiError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// submitPath checks that path is a file in the watcher path or an added
+// watch. Relative paths are taken relative to the watcher path.
+func submitPath(cfg Config, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path is missing")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.Watcher.Path, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(cfg.Watcher.Path, path)
+	if (err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))) && !inAddedWatch(path) {
+		return "", errors.New("path is outside of the watcher path")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", errors.New("path is not a file")
+	}
+	return path, nil
+}
+
+func forQueues(fn func(*jobQueue)) {
+	for _, q := range []*jobQueue{queue, uploads} {
+		if q != nil {
+			fn(q)
+		}
+	}
+}
+
+// historyHandler queries the job history database with the status, hash,
+// since (RFC 3339) and limit parameters.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		apiError(w, http.StatusNotFound, errors.New("job history is disabled"))
+		return
+	}
+	q := historyQuery{Status: r.FormValue("status"), Hash: r.FormValue("hash")}
+	var err error
+	if v := r.FormValue("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	entries, err := history.query(q)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, entries)
+}
+
+// daemonStatus is the overview shown by the status command.
+type daemonStatus struct {
+	Started    time.Time      `json:"started"`
+	Uptime     string         `json:"uptime"`
+	Watching   bool           `json:"watching"`
+	Paused     bool           `json:"paused"`
+	Held       int            `json:"held,omitempty"` // files detected while paused
+	Queue      map[string]int `json:"queue"`
+	Active     []jobStatus    `json:"active"`
+	Errors     []jobStatus    `json:"errors"` // last failed jobs, newest first
+	LastUpload *time.Time     `json:"last_upload,omitempty"`
+}
+
+// startTime is when the daemon was started.
+var startTime = time.Now()
+
+func currentStatus() daemonStatus {
+	s := daemonStatus{
+		Started: startTime,
+		Uptime:  time.Since(startTime).Round(time.Second).String(),
+		Queue:   map[string]int{},
+		Active:  []jobStatus{},
+		Errors:  []jobStatus{},
+	}
+	health.Lock()
+	s.Watching = health.watching
+	if !health.lastUpload.IsZero() {
+		last := health.lastUpload
+		s.LastUpload = &last
+	}
+	health.Unlock()
+	p := currentPipeline()
+	s.Paused, s.Held, s.Queue = p.Paused, p.Held, p.Queue
+
+	active, history := listJobs()
+	s.Active = append(s.Active, active...)
+	for _, info := range history {
+		if info.Status == "failed" && len(s.Errors) < 5 {
+			s.Errors = append(s.Errors, info)
+		}
+	}
+	return s
+}
+
+func apiResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		writeJSONResponse(w, http.StatusOK, map[string]string{})
+	case errors.Is(err, errJobNotFound), errors.Is(err, os.ErrNotExist):
+		apiError(w, http.StatusNotFound, err)
+	case errors.Is(err, errJobNotFailed):
+		apiError(w, http.StatusConflict, err)
+	default:
+		apiError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	writeJSONResponse(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSONResponse(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
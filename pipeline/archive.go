@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archivePurge is sent when archived copies were purged.
+type archivePurge struct {
+	Type      string    `json:"type"` // always archive_purge
+	Time      time.Time `json:"time"`
+	Files     []string  `json:"files"`
+	Bytes     int64     `json:"bytes"`     // freed
+	Remaining int64     `json:"remaining"` // bytes left in the archive
+}
+
+// startArchivePurge removes archived copies older than ARCHIVE_RETENTION
+// and, oldest first, those above ARCHIVE_MAX_SIZE, once at the start and
+// then every hour. What was removed is reported to the notification
+// targets.
+func startArchivePurge(ctx context.Context, cfg Config) {
+	a := cfg.Archive
+	if a.Path == "" || (a.Retention <= 0 && a.MaxSize <= 0) {
+		return
+	}
+	go func() {
+		for {
+			if p, err := purgeArchive(a.Path, a.Retention, a.MaxSize, time.Now()); err != nil {
+				slog.Warn("Unable to purge archive", "path", a.Path, "error", err)
+			} else if len(p.Files) > 0 {
+				reportArchivePurge(cfg, p)
+			}
+			if !sleep(ctx, time.Hour) {
+				return
+			}
+		}
+	}()
+}
+
+// purgeArchive removes the files below dir modified before now minus
+// retention, then the oldest ones until the rest fits maxSize. Folders
+// left empty are removed as well.
+func purgeArchive(dir string, retention time.Duration, maxSize int64, now time.Time) (archivePurge, error) {
+	p := archivePurge{Type: "archive_purge", Time: now}
+	type file struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []file
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path, fi.Size(), fi.ModTime()})
+		p.Remaining += fi.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+
+	for _, f := range files {
+		expired := retention > 0 && now.Sub(f.mod) > retention
+		if !expired && (maxSize <= 0 || p.Remaining <= maxSize) {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			slog.Warn("Unable to purge archived copy", "path", f.path, "error", err)
+			continue
+		}
+		slog.Info("Purged archived copy", "path", f.path, "archived", f.mod, "expired", expired)
+		p.Files = append(p.Files, f.path)
+		p.Bytes += f.size
+		p.Remaining -= f.size
+	}
+	if len(p.Files) == 0 {
+		return p, nil
+	}
+	// deepest first, so parents are empty when their turn comes
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return p, nil
+}
+
+// maxPurgeLines is the number of purged files listed in the text of a
+// notification.
+const maxPurgeLines = 20
+
+func reportArchivePurge(cfg Config, p archivePurge) {
+	title := fmt.Sprintf("scan2webdav archive: %d copies purged, %d MiB freed", len(p.Files), p.Bytes>>20)
+	lines := p.Files
+	if len(lines) > maxPurgeLines {
+		lines = append(lines[:maxPurgeLines:maxPurgeLines], fmt.Sprintf("and %d more", len(p.Files)-maxPurgeLines))
+	}
+	text := strings.Join(lines, "\n") + fmt.Sprintf("\n%d MiB left in %s", p.Remaining>>20, cfg.Archive.Path)
+	broadcast(cfg, "archive", pushNote{Title: title, Text: text}, p)
+}
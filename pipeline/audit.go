@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditColumns head the audit export, a row per finished job.
+var auditColumns = []string{"id", "status", "source", "hash", "destination", "uploads", "started", "finished", "pages", "bytes", "error"}
+
+// auditName returns the name of the export of the month starting at month.
+func auditName(cfg Config, month time.Time) string {
+	return fmt.Sprintf("audit-%s.%s", month.Format("2006-01"), cfg.Audit.Format)
+}
+
+// auditRows returns the rows of the audit export of the jobs in entries.
+func auditRows(entries []historyEntry) [][]string {
+	rows := [][]string{auditColumns}
+	for _, e := range entries {
+		rows = append(rows, []string{
+			e.ID,
+			e.Status,
+			e.Input,
+			e.Hash,
+			e.URL,
+			strings.Join(e.Uploads, " "),
+			inZone(e.Started).Format(time.RFC3339),
+			inZone(e.Finished).Format(time.RFC3339),
+			strconv.Itoa(e.Pages),
+			strconv.FormatInt(e.Bytes, 10),
+			e.Error,
+		})
+	}
+	return rows
+}
+
+// writeAudit encodes rows in AUDIT_FORMAT.
+func writeAudit(format string, rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	if format == "xlsx" {
+		err := writeXLSX(&buf, rows)
+		return buf.Bytes(), err
+	}
+	w := csv.NewWriter(&buf)
+	w.WriteAll(rows)
+	return buf.Bytes(), w.Error()
+}
+
+// writeXLSX writes rows as the only sheet of a minimal workbook. Cells
+// that are integers become numbers, everything else inline strings.
+func writeXLSX(buf *bytes.Buffer, rows [][]string) error {
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Audit" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+		{"xl/worksheets/sheet1.xml", sheetXML(rows)},
+	}
+	z := zip.NewWriter(buf)
+	for _, f := range files {
+		w, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func sheetXML(rows [][]string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for _, row := range rows {
+		b.WriteString("<row>")
+		for _, v := range row {
+			if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+				fmt.Fprintf(&b, `<c t="n"><v>%s</v></c>`, v)
+				continue
+			}
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(&b, []byte(v))
+			b.WriteString(`</t></is></c>`)
+		}
+		b.WriteString("</row>")
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// uploadAudit exports the jobs of the month starting at month to
+// AUDIT_PATH.
+func uploadAudit(ctx context.Context, cfg Config, month time.Time) error {
+	entries, err := history.between(month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+	data, err := writeAudit(cfg.Audit.Format, auditRows(entries))
+	if err != nil {
+		return err
+	}
+	store, err := newStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+	if err := store.Mkdir(ctx, cfg.Audit.Path); err != nil {
+		return err
+	}
+	name := path.Join(cfg.Audit.Path, auditName(cfg, month))
+	if err := store.Put(ctx, name, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+	slog.Info("Uploaded audit export", "path", name, "jobs", len(entries))
+	return nil
+}
+
+// monthStart returns the start of the month of t.
+func monthStart(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// startAudits uploads the export of the past month on the first of every
+// month at REPORT_AT. An export missing at the start, the daemon wasn't
+// running then, is made up for.
+func startAudits(ctx context.Context, cfg Config) {
+	if cfg.Audit.Path == "" {
+		return
+	}
+	if history == nil {
+		slog.Warn("Audit exports need the job history")
+		return
+	}
+	at, _ := parseReportTime(cfg.Report.At)
+	go func() {
+		now := inZone(time.Now())
+		last := monthStart(now).AddDate(0, -1, 0)
+		if store, err := newStorage(cfg, nil); err == nil {
+			name := path.Join(cfg.Audit.Path, auditName(cfg, last))
+			if ok, err := store.Exists(ctx, name); err == nil && !ok && now.After(monthStart(now).Add(at)) {
+				if err := uploadAudit(ctx, cfg, last); err != nil {
+					slog.Warn("Unable to upload audit export", "error", err)
+				}
+			}
+		}
+		for {
+			now := inZone(time.Now())
+			next := monthStart(now).Add(at)
+			if !next.After(now) {
+				next = monthStart(now).AddDate(0, 1, 0).Add(at)
+			}
+			if !sleep(ctx, time.Until(next)) {
+				return
+			}
+			if err := uploadAudit(ctx, cfg, monthStart(next).AddDate(0, -1, 0)); err != nil {
+				slog.Warn("Unable to upload audit export", "error", err)
+			}
+		}
+	}()
+}
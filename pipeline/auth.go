@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// tokens supplies OAuth2 access tokens if a token endpoint is configured.
+// The token source caches the token and refreshes it when it expires.
+var tokens oauth2.TokenSource
+
+// setupOAuth creates the token source: with a refresh token the refresh
+// token flow is used, then the token stored by the login command and
+// otherwise the client credentials flow.
+func setupOAuth(cfg Config) {
+	if cfg.OAuth.TokenUrl == "" {
+		return
+	}
+	// fetch tokens with the upload client so the TLS options apply
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	if cfg.OAuth.RefreshToken != "" {
+		tokens = oauthConfig(cfg).TokenSource(ctx, &oauth2.Token{RefreshToken: cfg.OAuth.RefreshToken})
+		return
+	}
+	file := tokenFile(cfg.State.Path)
+	if t, err := loadToken(file); err == nil {
+		tokens = &storedTokens{src: oauthConfig(cfg).TokenSource(ctx, t), file: file, last: t.AccessToken}
+		return
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Unable to read stored OAuth token", "path", file, "error", err)
+	}
+	conf := &clientcredentials.Config{
+		ClientID:     cfg.OAuth.ClientId,
+		ClientSecret: cfg.OAuth.ClientSecret,
+		TokenURL:     cfg.OAuth.TokenUrl,
+		Scopes:       cfg.OAuth.Scopes,
+	}
+	tokens = conf.TokenSource(ctx)
+}
+
+func oauthConfig(cfg Config) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.OAuth.ClientId,
+		ClientSecret: cfg.OAuth.ClientSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: cfg.OAuth.TokenUrl, DeviceAuthURL: cfg.OAuth.DeviceUrl},
+		Scopes:       cfg.OAuth.Scopes,
+	}
+}
+
+// tokenFile is where the login command stores the token.
+func tokenFile(stateDir string) string {
+	return filepath.Join(stateDir, "oauth-token.json")
+}
+
+func loadToken(file string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var t oauth2.Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func saveToken(file string, t *oauth2.Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// storedTokens writes refreshed tokens back to the token file, providers
+// like Microsoft rotate the refresh token with every refresh.
+type storedTokens struct {
+	src  oauth2.TokenSource
+	file string
+	mu   sync.Mutex
+	last string // access token last written
+}
+
+func (s *storedTokens) Token() (*oauth2.Token, error) {
+	t, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.AccessToken != s.last {
+		if err := saveToken(s.file, t); err != nil {
+			slog.Warn("Unable to store refreshed OAuth token", "path", s.file, "error", err)
+		}
+		s.last = t.AccessToken
+	}
+	return t, nil
+}
+
+// loginCommand runs the OAuth device authorization flow, so a headless
+// box gets a token without copying it from another machine. The token is
+// stored in the state directory and refreshed by the daemon.
+func loginCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.OAuth.TokenUrl == "" || cfg.OAuth.DeviceUrl == "" {
+		fmt.Fprintln(os.Stderr, "login needs OAUTH_TOKEN_URL and OAUTH_DEVICE_URL")
+		return 1
+	}
+	if err := setupClient(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	conf := oauthConfig(cfg)
+	da, err := conf.DeviceAuth(ctx, oauth2.AccessTypeOffline)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if da.VerificationURIComplete != "" {
+		fmt.Printf("Open %s to authorize scan2webdav, the code is %s\n", da.VerificationURIComplete, da.UserCode)
+	} else {
+		fmt.Printf("Open %s and enter the code %s\n", da.VerificationURI, da.UserCode)
+	}
+	t, err := conf.DeviceAccessToken(ctx, da)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if t.RefreshToken == "" {
+		fmt.Fprintln(os.Stderr, "warning: no refresh token was issued, the login has to be repeated when the token expires")
+	}
+	file := tokenFile(cfg.State.Path)
+	if err := saveToken(file, t); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("Token stored in", file)
+	return 0
+}
+
+// authorize adds the configured credentials to req: an OAuth2 access
+// token, a static bearer token or basic auth, in that order. Profiles
+// with their own credentials clear OAUTH_TOKEN_URL to skip the first.
+func authorize(cfg Config, req *http.Request) error {
+	switch {
+	case tokens != nil && cfg.OAuth.TokenUrl != "":
+		token, err := tokens.Token()
+		if err != nil {
+			return err
+		}
+		token.SetAuthHeader(req)
+	case cfg.Server.Token != "":
+		req.Header.Set("Authorization", "Bearer "+cfg.Server.Token)
+	default:
+		req.SetBasicAuth(cfg.Server.User, cfg.Server.Pass)
+	}
+	return nil
+}
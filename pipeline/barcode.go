@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"image"
+	"regexp"
+	"slices"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/multi/qrcode"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+// barcodeStep reads the barcodes on the first page into the document, where
+// the naming and routing templates find them as .Barcode and .Barcodes.
+func barcodeStep(job *Job) error {
+	cfg := job.Cfg.Barcode
+	if !cfg.Enabled || len(job.Files) == 0 {
+		return nil
+	}
+	img, err := firstPageImage(job.Files[0])
+	if err != nil {
+		job.Warn("Unable to read first page for barcodes", "error", err)
+		return nil
+	}
+	var pattern *regexp.Regexp
+	if cfg.Pattern != "" {
+		pattern = regexp.MustCompile(cfg.Pattern)
+	}
+	for _, v := range readBarcodes(img) {
+		if pattern != nil {
+			m := pattern.FindStringSubmatch(v)
+			if m == nil {
+				continue
+			}
+			// the first group selects a part of the value
+			if len(m) > 1 {
+				v = m[1]
+			}
+		}
+		job.Doc.Barcodes = append(job.Doc.Barcodes, v)
+	}
+	if len(job.Doc.Barcodes) > 0 {
+		job.Doc.Barcode = job.Doc.Barcodes[0]
+		job.Info("Found barcodes", "values", job.Doc.Barcodes)
+	}
+	return nil
+}
+
+// readBarcodes returns the values of the QR codes and of a linear barcode
+// in img.
+func readBarcodes(img image.Image) []string {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	add := func(r *gozxing.Result) {
+		if v := r.GetText(); v != "" && !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+
+	if results, err := qrcode.NewQRCodeMultiReader().DecodeMultipleWithoutHint(bmp); err == nil {
+		for _, r := range results {
+			add(r)
+		}
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	for _, reader := range []gozxing.Reader{
+		oned.NewCode128Reader(),
+		oned.NewCode39Reader(),
+		oned.NewCode93Reader(),
+		oned.NewMultiFormatUPCEANReader(nil),
+		oned.NewITFReader(),
+	} {
+		if r, err := reader.Decode(bmp, hints); err == nil {
+			add(r)
+		}
+	}
+	return values
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// batch is a document of page images being collected.
+type batch struct {
+	files       []string
+	pages       map[string]int // page numbers from the names
+	first, last time.Time      // modification times of the pages
+	timer       *time.Timer
+}
+
+// batcher groups the page images of scanners that write one file per page,
+// e.g. IMG_0001.jpg to IMG_0023.jpg, into one document. Images matching
+// BATCH_PATTERN with the same name apart from the page number belong to a
+// batch as long as they were written within BATCH_GAP of each other. A
+// batch is processed once no page came for BATCH_GAP.
+type batcher struct {
+	ctx     context.Context
+	cfg     Config
+	pattern *regexp.Regexp
+	mu      sync.Mutex
+	batches map[string]*batch // by directory and name without page number
+}
+
+func newBatcher(ctx context.Context, cfg Config) *batcher {
+	return &batcher{ctx: ctx, cfg: cfg, pattern: regexp.MustCompile(cfg.Batch.Pattern), batches: map[string]*batch{}}
+}
+
+func (b *batcher) add(path string) {
+	if ignoredPath(b.cfg, path) {
+		return
+	}
+	name := filepath.Base(path)
+	m := b.pattern.FindStringSubmatchIndex(name)
+	if m == nil || m[2] < 0 || !imageExts[strings.ToLower(filepath.Ext(name))] {
+		go enqueue(b.ctx, b.cfg, path, true)
+		return
+	}
+	page, _ := strconv.Atoi(name[m[2]:m[3]])
+	key := filepath.Join(filepath.Dir(path), name[:m[2]]+"*"+name[m[3]:])
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	mod := fi.ModTime()
+	gap := b.cfg.Batch.Gap
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bt := b.batches[key]
+	if bt != nil && slices.Contains(bt.files, path) {
+		return
+	}
+	if bt != nil && (mod.Before(bt.first.Add(-gap)) || mod.After(bt.last.Add(gap))) {
+		slog.Info("Page too far apart from the batch, starting a new one", "file", path, "batch", key)
+		delete(b.batches, key)
+		bt.timer.Stop()
+		go b.queue(bt)
+		bt = nil
+	}
+	if bt == nil {
+		slog.Info("Collecting batch", "batch", key)
+		bt = &batch{pages: map[string]int{}, first: mod, last: mod}
+		b.batches[key] = bt
+	}
+	bt.files = append(bt.files, path)
+	bt.pages[path] = page
+	if mod.Before(bt.first) {
+		bt.first = mod
+	}
+	if mod.After(bt.last) {
+		bt.last = mod
+	}
+	if bt.timer != nil {
+		bt.timer.Stop()
+	}
+	bt.timer = time.AfterFunc(gap, func() { b.flush(key, bt) })
+}
+
+// flush processes the batch key once no page came for BATCH_GAP.
+func (b *batcher) flush(key string, bt *batch) {
+	b.mu.Lock()
+	if b.batches[key] != bt {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, key)
+	b.mu.Unlock()
+	b.queue(bt)
+}
+
+// queue puts the pages of bt in order and queues them as one job, a single
+// page like any other file.
+func (b *batcher) queue(bt *batch) {
+	files := bt.files
+	if len(files) == 1 {
+		enqueue(b.ctx, b.cfg, files[0], false)
+		return
+	}
+	slices.SortFunc(files, func(x, y string) int {
+		if bt.pages[x] != bt.pages[y] {
+			return bt.pages[x] - bt.pages[y]
+		}
+		return strings.Compare(x, y)
+	})
+	enqueueGroup(b.ctx, b.cfg, files, func(id string, tempDir string) (string, error) {
+		return assembleBatch(id, tempDir, files)
+	})
+}
+
+// assembleBatch turns the page images files into one PDF in tempDir.
+func assembleBatch(id string, tempDir string, files []string) (string, error) {
+	slog.Info("Assembling batch", "job_id", id, "count", len(files), "files", files)
+	name := filepath.Base(files[0])
+	pdf := filepath.Join(tempDir, strings.TrimSuffix(name, filepath.Ext(name))+".pdf")
+	return pdf, api.ImportImagesFile(files, pdf, nil, pdfConf())
+}
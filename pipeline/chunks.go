@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// checkpointAge is the age after which the OCR checkpoints of documents
+// that never finished are removed.
+const checkpointAge = 7 * 24 * time.Hour
+
+// chunkSize returns the pages of the ranges a PDF of pages is OCRed in,
+// pages itself for one run over the whole document.
+func chunkSize(cfg Config, pages int) int {
+	size := pages
+	if cfg.Ocr.Parallel > 1 {
+		size = (pages + cfg.Ocr.Parallel - 1) / cfg.Ocr.Parallel
+	}
+	if cfg.Ocr.ChunkPages > 0 {
+		size = min(size, cfg.Ocr.ChunkPages)
+	}
+	return max(size, 1)
+}
+
+// ocrChunks OCRs in, a PDF of more pages than chunkSize, in ranges and
+// merges the results into out and textFile. OCR_PARALLEL ranges run at
+// once. Finished ranges are kept in the state directory, so a run after a
+// crash or timeout continues with the missing ones instead of the first
+// page.
+func ocrChunks(job *Job, args []string, in, out, textFile string, pages int) error {
+	cfg := job.Cfg
+	textOnly := cfg.Ocr.Output == "text"
+	size := chunkSize(cfg, pages)
+	dir, err := checkpointDir(job, in, args, size)
+	if err != nil {
+		return err
+	}
+	// the OCR only sees the temp directory, the checkpoints are moved
+	work := filepath.Join(job.TempDir, "ocr-chunks")
+	if err := os.MkdirAll(work, 0700); err != nil {
+		return err
+	}
+	if cfg.Ocr.Parallel > 1 && !slices.ContainsFunc(args, func(a string) bool {
+		return a == "-j" || a == "--jobs" || strings.HasPrefix(a, "--jobs=")
+	}) {
+		// the ranges are the parallelism, not the pages within them
+		args = append(slices.Clip(args), "--jobs", "1")
+	}
+
+	var (
+		pdfs, texts []string
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		errs        []error
+	)
+	running := make(chan struct{}, cfg.Ocr.Parallel)
+	for first := 1; first <= pages; first += size {
+		last := min(first+size-1, pages)
+		name := fmt.Sprintf("%05d-%05d", first, last)
+		pdf, text := filepath.Join(dir, name+".pdf"), filepath.Join(dir, name+".txt")
+		pdfs = append(pdfs, pdf)
+		texts = append(texts, text)
+		done := pdf
+		if textOnly {
+			done = text
+		}
+		if exists(done) {
+			job.Debug("Using OCR checkpoint", "pages", name)
+			continue
+		}
+
+		running <- struct{}{}
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed || job.ctx.Err() != nil {
+			<-running
+			break
+		}
+		wg.Go(func() {
+			defer func() { <-running }()
+			if err := ocrRange(job, args, in, filepath.Join(work, name), pdf, text, first, last); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("pages %d-%d: %w", first, last, err))
+				mu.Unlock()
+				return
+			}
+			job.Info("OCR checkpoint", "pages", fmt.Sprintf("%d-%d", first, last), "of", pages)
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if err := job.ctx.Err(); err != nil {
+		return err
+	}
+
+	if !textOnly {
+		if err := api.MergeCreateFile(pdfs, out, false, pdfConf()); err != nil {
+			return fmt.Errorf("merging the page ranges: %w", err)
+		}
+	}
+	if textOnly || needsText(cfg) {
+		if err := concatFiles(texts, textFile); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// ocrRange OCRs the pages first to last of in with files named base in
+// the temp directory and moves the results to the checkpoints pdf and
+// text.
+func ocrRange(job *Job, args []string, in, base, pdf, text string, first, last int) error {
+	cfg := job.Cfg
+	textOnly := cfg.Ocr.Output == "text"
+	chunkIn, chunkOut, chunkText := base+".pdf", base+".ocr.pdf", base+".ocr.txt"
+	if err := api.TrimFile(in, chunkIn, []string{fmt.Sprintf("%d-%d", first, last)}, pdfConf()); err != nil {
+		return fmt.Errorf("extracting the pages: %w", err)
+	}
+	defer os.Remove(chunkIn)
+	if err := runOcr(job, ocrFiles(cfg, args, chunkIn, chunkOut, chunkText)); err != nil {
+		return err
+	}
+	// the PDF comes last, it marks the range as done
+	if textOnly || needsText(cfg) {
+		if err := moveFile(chunkText, text); err != nil {
+			return err
+		}
+	}
+	if textOnly {
+		return nil
+	}
+	return moveFile(chunkOut, pdf)
+}
+
+// checkpointDir returns the directory of the OCR checkpoints of in, named
+// after the original input, the file, the range size and the OCR
+// arguments, as the outputs of the steps before aren't byte for byte the
+// same on every run. Stale ones of other documents are removed.
+func checkpointDir(job *Job, in string, args []string, size int) (string, error) {
+	root := filepath.Join(job.Cfg.State.Path, "ocr-checkpoints")
+	dirs, _ := filepath.Glob(filepath.Join(root, "*"))
+	for _, d := range dirs {
+		if fi, err := os.Stat(d); err == nil && time.Since(fi.ModTime()) > checkpointAge {
+			os.RemoveAll(d)
+		}
+	}
+
+	sum := job.Doc.Hash
+	if sum == "" {
+		var err error
+		if sum, err = fileHash(job.Input); err != nil {
+			if sum, err = fileHash(in); err != nil {
+				return "", err
+			}
+		}
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s", sum, filepath.Base(in), size, strings.Join(args, "\x00"))
+	dir := filepath.Join(root, hex.EncodeToString(h.Sum(nil))[:16])
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// concatFiles writes the contents of files one after the other to dst.
+func concatFiles(files []string, dst string) error {
+	var data []byte
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		data = append(data, b...)
+	}
+	return os.WriteFile(dst, data, 0600)
+}
@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// circuit stops the uploads while the upload target is unreachable. It
+// opens after CIRCUIT_FAILURES connection errors in a row, then a single
+// prober asks the server every CIRCUIT_BACKOFF and closes it again once it
+// answers. Uploads wait for that instead of piling up errors.
+var circuit = struct {
+	sync.Mutex
+	failures int
+	open     bool
+	since    time.Time
+	closed   chan struct{} // closed with the circuit
+	paused   bool          // the pipeline was paused with CIRCUIT_PAUSE
+}{}
+
+// connectionError tells if err means the server couldn't be reached, as
+// opposed to an answer that rejected the upload.
+func connectionError(err error) bool {
+	var se *storage.StatusError
+	return !errors.As(err, &se) && classify(err) == classTransient
+}
+
+// waitCircuit blocks while the circuit is open.
+func waitCircuit(ctx context.Context) error {
+	circuit.Lock()
+	open, closed := circuit.open, circuit.closed
+	circuit.Unlock()
+	if !open {
+		return nil
+	}
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observeUpload counts the outcome of an upload attempt towards opening
+// the circuit.
+func observeUpload(cfg Config, err error) {
+	if cfg.Circuit.Failures <= 0 {
+		return
+	}
+	circuit.Lock()
+	defer circuit.Unlock()
+	if err == nil || !connectionError(err) {
+		circuit.failures = 0
+		return
+	}
+	circuit.failures++
+	if circuit.open || circuit.failures < cfg.Circuit.Failures {
+		return
+	}
+	circuit.open, circuit.since = true, time.Now()
+	circuit.closed = make(chan struct{})
+	circuit.paused = cfg.Circuit.Pause
+
+	reason := fmt.Sprintf("upload target unreachable, %d connection errors in a row", circuit.failures)
+	slog.Error("Circuit open, holding uploads", "server", cfg.Server.Url, "error", err, "probe_every", cfg.Circuit.Backoff)
+	if cfg.Circuit.Pause {
+		go Pause()
+	}
+	text := fmt.Sprintf("%s\nLast error: %s\nUploads wait until %s answers again.", reason, err, cfg.Server.Url)
+	al := alert{Type: "alert", Time: circuit.since, Reason: reason, Consecutive: circuit.failures, LastError: err.Error(), Paused: cfg.Circuit.Pause}
+	go broadcast(cfg, "alert", pushNote{Title: "scan2webdav alert: " + reason, Text: text, Urgent: true}, al)
+	go probeCircuit(cfg)
+}
+
+// probeCircuit asks the server every CIRCUIT_BACKOFF until it answers,
+// then closes the circuit.
+func probeCircuit(cfg Config) {
+	for {
+		if !sleep(jobCtx, cfg.Circuit.Backoff) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(jobCtx, 30*time.Second)
+		err := checkServer(ctx, cfg)
+		cancel()
+		if err != nil {
+			slog.Debug("Upload target still unreachable", "server", cfg.Server.Url, "error", err)
+			continue
+		}
+		circuit.Lock()
+		circuit.open, circuit.failures = false, 0
+		close(circuit.closed)
+		paused, down := circuit.paused, time.Since(circuit.since)
+		circuit.paused = false
+		circuit.Unlock()
+
+		slog.Info("Circuit closed, upload target reachable again", "server", cfg.Server.Url, "down", down.Round(time.Second))
+		if paused {
+			Resume()
+		}
+		return
+	}
+}
+
+// circuitOpen reports whether uploads are held.
+func circuitOpen() bool {
+	circuit.Lock()
+	defer circuit.Unlock()
+	return circuit.open
+}
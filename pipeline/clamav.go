@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// infection is broadcast when an input contains a virus.
+type infection struct {
+	Type  string    `json:"type"` // always virus
+	Time  time.Time `json:"time"`
+	Input string    `json:"input"`
+	Virus string    `json:"virus"`
+}
+
+// errInfected ends a job whose input contains a virus, its inputs are
+// quarantined.
+var errInfected = errors.New("virus found")
+
+// clamavStep has clamd scan the inputs before anything else reads them.
+// Infected inputs are reported and end the job. A failing clamd fails the
+// job, nothing unscanned gets through.
+func clamavStep(job *Job) error {
+	addr := job.Cfg.Clamav.Address
+	if addr == "" {
+		return nil
+	}
+	for _, f := range job.Files {
+		virus, err := clamScan(job.ctx, addr, f)
+		if err != nil {
+			return fmt.Errorf("virus scan failed: %w", err)
+		}
+		if virus == "" {
+			continue
+		}
+		job.Error("Virus found", "path", f, "virus", virus)
+		e := infection{Type: "virus", Time: time.Now(), Input: job.Input, Virus: virus}
+		go broadcast(job.Cfg, "virus", pushNote{
+			Title:  "scan2webdav: virus found in " + filepath.Base(job.Input),
+			Text:   fmt.Sprintf("%s contains %s and was quarantined.", job.Input, virus),
+			Urgent: true,
+		}, e)
+		return fmt.Errorf("%w: %s", errInfected, virus)
+	}
+	return nil
+}
+
+// clamScan streams file to clamd at addr, host:port or the path of its
+// socket, and returns the name of the virus found, if any.
+func clamScan(ctx context.Context, addr string, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return parseClamReply(reply)
+}
+
+// parseClamReply reads "stream: OK", "stream: <virus> FOUND" or
+// "<message> ERROR".
+func parseClamReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	case reply == "":
+		return "", errors.New("no reply from clamd")
+	default:
+		return "", errors.New(reply)
+	}
+}
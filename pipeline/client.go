@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// client is shared by all requests to the upload target.
+var client = &http.Client{}
+
+// setupClient applies the TLS, proxy and timeout options to the upload
+// client. Without SERVER_PROXY the usual HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY variables are honored.
+func setupClient(cfg Config) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.Tls.Insecure}
+
+	if cfg.Tls.Ca != "" {
+		pem, err := os.ReadFile(cfg.Tls.Ca)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", cfg.Tls.Ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.Tls.Cert != "" || cfg.Tls.Key != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Tls.Cert, cfg.Tls.Key)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.Server.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = cfg.Server.TlsTimeout
+	transport.ResponseHeaderTimeout = cfg.Server.ResponseTimeout
+	transport.IdleConnTimeout = cfg.Server.IdleTimeout
+	// keep a connection for every worker that may upload
+	transport.MaxIdleConnsPerHost = max(cfg.Queue.UploadWorkers, cfg.Queue.Workers, http.DefaultMaxIdleConnsPerHost)
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.Server.Http2)
+	transport.Protocols = protocols
+	// a dead HTTP/2 connection would hold up every upload multiplexed on it
+	transport.HTTP2 = &http.HTTP2Config{SendPingTimeout: 30 * time.Second}
+	if cfg.Server.Proxy != "" {
+		proxy, err := url.Parse(cfg.Server.Proxy)
+		if err != nil {
+			return err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	var rt http.RoundTripper = transport
+	if cfg.Server.MaxRequests > 0 {
+		rt = &hostLimit{next: transport, max: cfg.Server.MaxRequests, hosts: map[string]chan struct{}{}}
+	}
+	client = &http.Client{Transport: drainer{rt}, Timeout: cfg.Server.Timeout}
+	return nil
+}
+
+// maxDrain is the most of an unread response body that is read to keep
+// its connection.
+const maxDrain = 64 << 10
+
+// drainer reads what is left of a response body, up to maxDrain, when it
+// is closed. HTTP/1.1 connections with unread bodies are closed rather
+// than reused, and most WebDAV answers are closed unread, so every upload
+// would need a new connection and TLS handshake.
+type drainer struct {
+	next http.RoundTripper
+}
+
+func (d drainer) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := d.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = &drainingBody{res.Body}
+	return res, nil
+}
+
+type drainingBody struct {
+	io.ReadCloser
+}
+
+func (b *drainingBody) Close() error {
+	io.CopyN(io.Discard, b.ReadCloser, maxDrain)
+	return b.ReadCloser.Close()
+}
+
+// hostLimit caps the concurrent requests per host, so that all workers and
+// profiles finishing at once don't trip connection limits or fail2ban on
+// the server. A request holds its slot until the response body is closed.
+type hostLimit struct {
+	next  http.RoundTripper
+	max   int
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func (l *hostLimit) RoundTrip(req *http.Request) (*http.Response, error) {
+	l.mu.Lock()
+	slots, ok := l.hosts[req.URL.Host]
+	if !ok {
+		slots = make(chan struct{}, l.max)
+		l.hosts[req.URL.Host] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-req.Context().Done():
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, req.Context().Err()
+	}
+	res, err := l.next.RoundTrip(req)
+	if err != nil {
+		<-slots
+		return nil, err
+	}
+	res.Body = &releasingBody{ReadCloser: res.Body, release: sync.OnceFunc(func() { <-slots })}
+	return res, nil
+}
+
+// releasingBody frees the slot of its request when it is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
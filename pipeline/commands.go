@@ -0,0 +1,296 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// RunCommand runs a subcommand instead of the daemon and returns the exit
+// code.
+func RunCommand(cfg Config, args []string) int {
+	switch args[0] {
+	case "history":
+		return historyCommand(cfg, args[1:])
+	case "deadletters":
+		return deadLettersCommand(cfg, args[1:])
+	case "usage":
+		return usageCommand(cfg, args[1:])
+	case "status":
+		return statusCommand(cfg, args[1:])
+	case "tui":
+		return tuiCommand(cfg, args[1:])
+	case "search":
+		return searchCommand(cfg, args[1:])
+	case "process":
+		return processCommand(cfg, args[1:])
+	case "reocr":
+		return reocrCommand(cfg, args[1:])
+	case "replay":
+		return replayCommand(cfg, args[1:])
+	case "login":
+		return loginCommand(cfg, args[1:])
+	case "pause", "resume":
+		return pauseCommand(cfg, args[0], args[1:])
+	case "watches":
+		return watchesCommand(cfg, args[1:])
+	case "scan":
+		return scanCommand(cfg, args[1:])
+	case "healthcheck":
+		return healthcheckCommand(cfg, args[1:])
+	case "selftest", "--selftest", "-selftest":
+		return selftestCommand(cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+// historyCommand lists finished jobs from the history database.
+func historyCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	status := flags.String("status", "", "only jobs with `status` (done or failed)")
+	hash := flags.String("hash", "", "only jobs with the input `sha256`")
+	since := flags.Duration("since", 0, "only jobs finished within `duration`")
+	limit := flags.Int("limit", 20, "show at most `n` jobs")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	h, err := openHistory(cfg.State.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	q := historyQuery{Status: *status, Hash: *hash, Limit: *limit}
+	if *since > 0 {
+		q.Since = time.Now().Add(-*since)
+	}
+	entries, err := h.query(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(entries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FINISHED\tSTATUS\tDURATION\tOCR CPU\tOCR MEMORY\tINPUT\tTARGET")
+	for _, e := range entries {
+		target := e.URL
+		if len(e.Uploads) > 0 {
+			target = e.Uploads[0]
+		}
+		if e.Status == "failed" {
+			target = e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1fs\t%d MiB\t%s\t%s\n", e.Finished.Format(time.DateTime), e.Status,
+			e.Finished.Sub(e.Started).Round(time.Second), e.OcrCPU, e.OcrMaxRSS>>20, e.Input, target)
+	}
+	w.Flush()
+	return 0
+}
+
+// searchCommand looks up documents by their text. The daemon holds the
+// index open, so it is asked first; the index is only opened directly if
+// the daemon isn't running.
+func searchCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	limit := flags.Int("limit", 20, "show at most `n` documents")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	q := strings.Join(flags.Args(), " ")
+	if q == "" {
+		fmt.Fprintln(os.Stderr, "usage: search [flags] query")
+		return 2
+	}
+
+	var hits []searchHit
+	err := apiGet(cfg, fmt.Sprintf("%s/api/search?q=%s&limit=%d", *addr, url.QueryEscape(q), *limit), &hits)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		var index bleve.Index
+		if index, err = bleve.Open(searchIndexPath(cfg.State.Path)); err == nil {
+			hits, err = search(index, q, *limit)
+			index.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(hits)
+	}
+	for _, h := range hits {
+		target := h.Filename
+		if len(h.Uploads) > 0 {
+			target = h.Uploads[0]
+		}
+		fmt.Printf("%s  %s\n", h.Time.Format(time.DateTime), target)
+		for _, f := range h.Fragments {
+			fmt.Printf("    %s\n", strings.Join(strings.Fields(f), " "))
+		}
+	}
+	return 0
+}
+
+// scanCommand asks the daemon to scan a document, e.g. from a scanbd
+// button script.
+func scanCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	var res struct {
+		Path string `json:"path"`
+	}
+	if err := apiDo(cfg, http.MethodPost, *addr+"/api/scan", &res); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(res.Path)
+	return 0
+}
+
+// healthcheckCommand exits with 0 if the daemon reports itself healthy,
+// for the HEALTHCHECK of the container.
+func healthcheckCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	var report healthReport
+	if err := apiGet(cfg, *addr+"/healthz", &report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// statusCommand asks the running daemon for its status over the HTTP API.
+func statusCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var s daemonStatus
+	if err := apiGet(cfg, *addr+"/api/status", &s); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(s)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Uptime:\t%s\n", s.Uptime)
+	fmt.Fprintf(w, "Watching:\t%t\n", s.Watching)
+	if s.Paused {
+		fmt.Fprintf(w, "Paused:\ttrue, %d files held\n", s.Held)
+	} else {
+		fmt.Fprintf(w, "Paused:\tfalse\n")
+	}
+	fmt.Fprintf(w, "Queued:\t%d jobs, %d uploads\n", s.Queue["jobs"], s.Queue["uploads"])
+	if s.LastUpload != nil {
+		fmt.Fprintf(w, "Last upload:\t%s\n", s.LastUpload.Format(time.DateTime))
+	}
+	w.Flush()
+
+	if len(s.Active) > 0 {
+		fmt.Println("\nIn flight:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATE\tSTEP\tSTARTED\tINPUT")
+		for _, j := range s.Active {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", j.ID, j.State, j.Step, j.Started.Format(time.DateTime), j.Input)
+		}
+		w.Flush()
+	}
+	if len(s.Errors) > 0 {
+		fmt.Println("\nLast errors:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tFINISHED\tINPUT\tERROR")
+		for _, j := range s.Errors {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", j.ID, j.Finished.Format(time.DateTime), j.Input, j.Error)
+		}
+		w.Flush()
+	}
+	return 0
+}
+
+// apiURL derives the base URL of the API from HTTP_LISTEN.
+func apiURL(cfg Config) string {
+	host, port, err := net.SplitHostPort(cfg.Http.Listen)
+	if err != nil {
+		return "http://localhost:8080"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// apiGet fetches and decodes a JSON document from the daemon.
+func apiGet(cfg Config, endpoint string, v any) error {
+	return apiDo(cfg, http.MethodGet, endpoint, v)
+}
+
+func apiDo(cfg Config, method string, endpoint string, v any) error {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if cfg.Http.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Http.Token)
+	}
+	c := &http.Client{Timeout: 10 * time.Second}
+	if method != http.MethodGet {
+		// a scan takes a while
+		c.Timeout = 10 * time.Minute
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		if e.Error == "" {
+			e.Error = res.Status
+		}
+		return fmt.Errorf("%s: %s", endpoint, e.Error)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chbmuc/scan2webdav/ocr"
+)
+
+// checkConfidence measures the OCR confidence of the pages of in and flags
+// the document for review if the mean falls below OCR_MIN_CONFIDENCE.
+// Measuring failures are only logged, the text layer exists anyway.
+func checkConfidence(job *Job, in string, lang string) {
+	threshold := job.Cfg.Ocr.MinConfidence
+	if threshold <= 0 {
+		return
+	}
+	pages, err := pageConfidences(job, in, lang)
+	if err != nil {
+		job.Warn("Unable to measure OCR confidence", "error", err)
+		return
+	}
+	if len(pages) == 0 {
+		return
+	}
+	sum := 0.0
+	for _, c := range pages {
+		sum += c
+	}
+	mean := sum / float64(len(pages))
+	job.Doc.Confidence = mean
+	job.Debug("OCR confidence", "mean", mean, "pages", pages)
+	if mean < threshold {
+		job.Warn("Low OCR confidence, flagging for review", "confidence", mean, "min", threshold)
+		job.flagForReview()
+	}
+}
+
+// flagForReview marks the document with REVIEW_TAG, for Nextcloud and
+// paperless, and REVIEW_SUFFIX in the rename step.
+func (job *Job) flagForReview() {
+	if job.Doc.Review {
+		return
+	}
+	job.Doc.Review = true
+	if tag := job.Cfg.Review.Tag; tag != "" {
+		job.Doc.Tags = append(job.Doc.Tags, tag)
+	}
+}
+
+// pageConfidences runs tesseract on the page images of file and returns
+// the mean word confidence, 0 to 100, of every page with an image.
+func pageConfidences(job *Job, file string, lang string) ([]float64, error) {
+	images, err := pageImages(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(job.TempDir, "confidence")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var pages []float64
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		name := filepath.Join(dir, fmt.Sprintf("page%d.png", i+1))
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		err = png.Encode(f, img)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		args := []string{name, "stdout"}
+		if lang != "" {
+			args = append(args, "-l", lang)
+		}
+		opts := ocrOptions(job.Cfg)
+		if err := ocr.Own(opts, job.TempDir); err != nil {
+			return nil, err
+		}
+		cmd, args := ocr.Tesseract(opts, []string{job.TempDir}, append(args, "tsv"))
+		out, usage, err := ocr.Run(job.ctx, opts, cmd, args)
+		job.addUsage(usage)
+		if err != nil {
+			job.output(cmd, out)
+			return nil, err
+		}
+		if c, ok := meanConfidence(out); ok {
+			pages = append(pages, c)
+		}
+	}
+	return pages, nil
+}
+
+// meanConfidence averages the conf column of the words in tesseract TSV
+// output. Pages without words have no confidence.
+func meanConfidence(tsv []byte) (float64, bool) {
+	sum, n := 0.0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(tsv))
+	for scanner.Scan() {
+		// level page block par line word left top width height conf text
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 || fields[0] != "5" || strings.TrimSpace(fields[11]) == "" {
+			continue
+		}
+		conf, err := strconv.ParseFloat(fields[10], 64)
+		if err != nil || conf < 0 {
+			continue
+		}
+		sum += conf
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// ocrLanguage returns the -l argument of ocrmypdf args.
+func ocrLanguage(args []string) string {
+	for i, a := range args {
+		switch {
+		case (a == "-l" || a == "--language") && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(a, "--language="):
+			return strings.TrimPrefix(a, "--language=")
+		case strings.HasPrefix(a, "-l") && len(a) > 2:
+			return a[2:]
+		}
+	}
+	return ""
+}
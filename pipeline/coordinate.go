@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// errLockedElsewhere means another instance processes the input.
+var errLockedElsewhere = errors.New("locked by another instance")
+
+// inputLocks are the locks this instance holds on the server for the
+// inputs in flight, with COORDINATION_PATH set. Instances watching the
+// same share take the lock of a file before processing it, so exactly one
+// of them does.
+var inputLocks = struct {
+	sync.Mutex
+	locks   map[string]*inputLock
+	created bool // the collection exists
+}{locks: map[string]*inputLock{}}
+
+type inputLock struct {
+	s     *storage.WebDAV
+	url   string
+	token string
+	done  chan struct{}
+}
+
+// lockInput takes the lock of inFile and refreshes it until unlockInput.
+// The locks of an instance that died expire after COORDINATION_TIMEOUT.
+func lockInput(ctx context.Context, cfg Config, inFile string) error {
+	s := newWebDAV(cfg)
+	remotePath := inputLockPath(cfg, inFile)
+	url := s.URL(remotePath)
+	timeout := cfg.Coordination.Timeout
+
+	inputLocks.Lock()
+	created := inputLocks.created
+	inputLocks.Unlock()
+	if !created {
+		if err := s.Mkdir(ctx, cfg.Coordination.Path); err != nil {
+			return err
+		}
+		inputLocks.Lock()
+		inputLocks.created = true
+		inputLocks.Unlock()
+	}
+
+	res, err := s.SendLock(ctx, url, timeout, "")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusLocked:
+		return errLockedElsewhere
+	case res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented:
+		return errors.New("the server doesn't support locking")
+	case res.StatusCode < 200 || res.StatusCode >= 300:
+		return &storage.StatusError{Op: "locking", Path: url, Status: res.Status, Code: res.StatusCode}
+	}
+	token := res.Header.Get("Lock-Token")
+	if token == "" {
+		return errors.New("the server sent no lock token")
+	}
+
+	l := &inputLock{s: s, url: url, token: token, done: make(chan struct{})}
+	inputLocks.Lock()
+	inputLocks.locks[inFile] = l
+	inputLocks.Unlock()
+	go l.refresh(timeout / 2)
+	return nil
+}
+
+func (l *inputLock) refresh(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-l.done:
+			return
+		}
+		res, err := l.s.SendLock(context.Background(), l.url, 2*interval, l.token)
+		if err != nil {
+			slog.Warn("Unable to refresh lock", "url", l.url, "error", err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			slog.Warn("Unable to refresh lock", "url", l.url, "status", res.StatusCode)
+		}
+	}
+}
+
+// unlockInput releases the lock of inFile, if this instance holds one, and
+// removes the empty resource the lock created.
+func unlockInput(inFile string) {
+	inputLocks.Lock()
+	l, ok := inputLocks.locks[inFile]
+	delete(inputLocks.locks, inFile)
+	inputLocks.Unlock()
+	if !ok {
+		return
+	}
+	close(l.done)
+
+	ctx := context.Background()
+	l.s.Unlock(ctx, l.url, l.token)
+	// fails if another instance locked it in the meantime
+	if res, err := l.s.Do(ctx, http.MethodDelete, l.url, nil, 0, nil); err == nil {
+		res.Body.Close()
+	}
+}
+
+// inputLockPath names the lock of inFile after its path below the watcher
+// path, which is the same for every instance watching the share.
+func inputLockPath(cfg Config, inFile string) string {
+	rel, err := filepath.Rel(cfg.Watcher.Path, inFile)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = inFile
+	}
+	sum := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+	return path.Join(cfg.Coordination.Path, hex.EncodeToString(sum[:16])+".lock")
+}
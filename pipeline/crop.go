@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"image"
+	"os"
+)
+
+// cropDark is the gray level below which a pixel belongs to a border.
+const cropDark = 64
+
+// cropStep cuts the dark borders of flatbed and ADF scans off the pages
+// with CROP_ENABLED, they confuse OCR and take space. Like in the resample
+// step, only image files and pure scans are changed.
+func cropStep(job *Job) error {
+	if !job.Cfg.Crop.Enabled {
+		return nil
+	}
+	return job.each("crop", func(in, out string) error {
+		switch ext := fileType(in); {
+		case imageExts[ext]:
+			return cropImage(job, in, out)
+		case ext == ".pdf":
+			return cropPDF(job, in, out)
+		}
+		return copyFile(in, out)
+	})
+}
+
+func cropImage(job *Job, in, out string) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	r := cropBorders(img)
+	if r == img.Bounds() {
+		return copyFile(in, out)
+	}
+	job.Info("Cropping image", "file", in, "from", img.Bounds().Size(), "to", r.Size())
+	return writeImage(out, format, subImage(img, r))
+}
+
+func cropPDF(job *Job, in, out string) error {
+	changed, err := rebuildPDF(job, in, out, func(p page) []page {
+		b := p.img.Bounds()
+		r := cropBorders(p.img)
+		if r == b {
+			return nil
+		}
+		return []page{cropPage(p, r)}
+	})
+	if changed {
+		job.Info("Cropped PDF", "file", in)
+	}
+	return err
+}
+
+// cropPage cuts the part r out of the image of p, the page shrinks with
+// it.
+func cropPage(p page, r image.Rectangle) page {
+	b := p.img.Bounds()
+	dim := p.dim
+	dim.Width *= float64(r.Dx()) / float64(b.Dx())
+	dim.Height *= float64(r.Dy()) / float64(b.Dy())
+	return page{subImage(p.img, r), dim}
+}
+
+// cropBorders returns the bounds of img without the dark rows and columns
+// at its edges, a border is mostly dark. No more than a quarter is taken
+// from each side, documents can have dark bars of their own.
+func cropBorders(img image.Image) image.Rectangle {
+	g := toGray(img)
+	b := g.Bounds()
+	dark := func(x0, y0, dx, dy, n int) bool {
+		count := 0
+		for i := 0; i < n; i++ {
+			if g.Pix[g.PixOffset(x0+i*dx, y0+i*dy)] < cropDark {
+				count++
+			}
+		}
+		return count*2 > n
+	}
+
+	r := b
+	for r.Min.Y < b.Min.Y+b.Dy()/4 && dark(b.Min.X, r.Min.Y, 1, 0, b.Dx()) {
+		r.Min.Y++
+	}
+	for r.Max.Y > b.Max.Y-b.Dy()/4 && dark(b.Min.X, r.Max.Y-1, 1, 0, b.Dx()) {
+		r.Max.Y--
+	}
+	for r.Min.X < b.Min.X+b.Dx()/4 && dark(r.Min.X, r.Min.Y, 0, 1, r.Dy()) {
+		r.Min.X++
+	}
+	for r.Max.X > b.Max.X-b.Dx()/4 && dark(r.Max.X-1, r.Min.Y, 0, 1, r.Dy()) {
+		r.Max.X--
+	}
+	return r
+}
+
+// subImage returns the part r of img, sharing its pixels.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	g := toGray(img)
+	return g.SubImage(r)
+}
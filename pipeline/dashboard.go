@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"errors"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"since": func(t time.Time) time.Duration {
+		return time.Since(t).Round(time.Second)
+	},
+	"took": func(j jobStatus) time.Duration {
+		return j.Finished.Sub(j.Started).Round(time.Millisecond)
+	},
+}).Parse(dashboardHTML))
+
+// setupDashboard serves the web UI with the running, queued and recent
+// jobs on the root of HTTP_LISTEN, protected by HTTP_TOKEN like the API.
+func setupDashboard(cfg Config) {
+	handle := func(pattern string, fn http.HandlerFunc) {
+		mux.Handle(pattern, dashboardAuth(cfg, fn))
+	}
+	handle("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		dashboardHandler(w, r, cfg.Scan.Command != "")
+	})
+	if cfg.Scan.Command != "" {
+		handle("POST /scan", func(w http.ResponseWriter, r *http.Request) {
+			_, err := scan(cfg)
+			if errors.Is(err, errScanning) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			jobAction(w, r, err)
+		})
+	}
+	handle("GET /jobs/{id}/log", jobLogHandler)
+	handle("POST /jobs/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		jobAction(w, r, retryJob(cfg, r.PathValue("id")))
+	})
+	handle("POST /jobs/{id}/discard", func(w http.ResponseWriter, r *http.Request) {
+		jobAction(w, r, discardJob(r.PathValue("id")))
+	})
+}
+
+// dashboardAuth checks HTTP_TOKEN like apiAuth. Browsers can't send a
+// bearer token, so the token is also taken as basic auth password. The
+// forms are refused cross-origin, other pages open in the browser could
+// post them otherwise.
+func dashboardAuth(cfg Config, next http.HandlerFunc) http.Handler {
+	return http.NewCrossOriginProtection().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Http.Token != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if _, pass, ok := r.BasicAuth(); ok {
+				token = pass
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Http.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="scan2webdav"`)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}))
+}
+
+// queuedItem is a waiting queue item for the dashboard.
+type queuedItem struct {
+	Name     string    `json:"name"`
+	Priority int       `json:"priority"`
+	Added    time.Time `json:"added"`
+}
+
+// listQueued returns the items waiting in the queues, oldest first.
+func listQueued() []queuedItem {
+	var queued []queuedItem
+	for _, q := range []*jobQueue{queue, uploads} {
+		if q == nil {
+			continue
+		}
+		for _, item := range q.list() {
+			queued = append(queued, queuedItem{item.name, item.priority, item.added})
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].Added.Before(queued[j].Added) })
+	return queued
+}
+
+func dashboardHandler(w http.ResponseWriter, r *http.Request, scan bool) {
+	active, history := listJobs()
+	data := struct {
+		Active  []jobStatus
+		Queued  []queuedItem
+		History []jobStatus
+		Scan    bool
+	}{active, listQueued(), history, scan}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Warn("Unable to render dashboard", "error", err)
+	}
+}
+
+func jobLogHandler(w http.ResponseWriter, r *http.Request) {
+	info := findJob(r.PathValue("id"))
+	if info == nil || info.job.logs == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, info.job.logs.String())
+}
+
+// jobAction answers a retry or discard button and returns to the dashboard.
+func jobAction(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errJobNotFound), errors.Is(err, os.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errJobNotFailed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
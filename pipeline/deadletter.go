@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// retryDeadLetters gives the dead letters in paths, all of them if paths
+// is empty, another set of attempts once the cause is fixed. It returns the
+// queued inputs; inputs that are gone are just forgotten.
+func retryDeadLetters(cfg Config, paths []string) []string {
+	queued := []string{}
+	for _, e := range failures.deadLetters(cfg.Retry.Attempts) {
+		if len(paths) > 0 && !slices.Contains(paths, e.Path) {
+			continue
+		}
+		failures.forget(e.Path)
+		if !exists(e.Path) {
+			continue
+		}
+		go enqueue(jobCtx, cfg, e.Path, false)
+		queued = append(queued, e.Path)
+	}
+	return queued
+}
+
+func deadLettersHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list := failures.deadLetters(cfg.Retry.Attempts)
+		if list == nil {
+			list = []failureEntry{}
+		}
+		writeJSONResponse(w, http.StatusOK, list)
+	}
+}
+
+// retryDeadLettersHandler retries the inputs given as path parameters,
+// all dead letters without.
+func retryDeadLettersHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		writeJSONResponse(w, http.StatusOK, map[string][]string{"queued": retryDeadLetters(cfg, r.Form["path"])})
+	}
+}
+
+// deadLettersCommand lists the inputs the daemon gave up on or, with
+// -retry, queues them again.
+func deadLettersCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("deadletters", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	retry := flags.Bool("retry", false, "retry the given inputs, all without")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *retry {
+		q := url.Values{"path": flags.Args()}
+		var res struct {
+			Queued []string `json:"queued"`
+		}
+		if err := apiDo(cfg, http.MethodPost, *addr+"/api/deadletters/retry?"+q.Encode(), &res); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, p := range res.Queued {
+			fmt.Println(p)
+		}
+		return 0
+	}
+
+	var list []failureEntry
+	if err := apiGet(cfg, *addr+"/api/deadletters", &list); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(list)
+	}
+	for _, e := range list {
+		fmt.Printf("%s (%d attempts)\n", e.Path, e.Attempts)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, a := range e.Errors {
+			fmt.Fprintf(w, "  %s\t%s\n", a.Time.Format(time.DateTime), a.Reason)
+		}
+		w.Flush()
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// errEncrypted ends a job whose input can't be opened with any of the
+// DECRYPT_PASSWORDS, its inputs are quarantined.
+var errEncrypted = errors.New("encrypted PDF")
+
+// decryptStep replaces encrypted PDFs with decrypted copies, so OCR can
+// work on them. The empty password is tried first, it opens PDFs that only
+// restrict their permissions, then the DECRYPT_PASSWORDS in order.
+func decryptStep(job *Job) error {
+	passwords := job.Cfg.Decrypt.Passwords
+	if len(passwords) == 0 {
+		return nil
+	}
+	dir := filepath.Join(job.TempDir, "decrypt")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, in := range job.Files {
+		if fileType(in) != ".pdf" {
+			continue
+		}
+		out, err := decryptFile(in, filepath.Join(dir, filepath.Base(in)), passwords)
+		if err != nil {
+			return err
+		}
+		if out != in {
+			job.Info("Decrypted PDF", "file", in)
+			job.Files[i] = out
+		}
+	}
+	return nil
+}
+
+// decryptFile writes the decrypted in to out and returns out, or in if it
+// isn't encrypted.
+func decryptFile(in string, out string, passwords []string) (string, error) {
+	for _, pw := range append([]string{""}, passwords...) {
+		conf := pdfConf()
+		conf.UserPW, conf.OwnerPW = pw, pw
+		err := api.DecryptFile(in, out, conf)
+		switch {
+		case err == nil:
+			return out, nil
+		case errors.Is(err, pdfcpu.ErrNotEncrypted):
+			return in, nil
+		case errors.Is(err, pdfcpu.ErrWrongPassword), errors.Is(err, pdfcpu.ErrOwnerPasswordRequired):
+		default:
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: none of the passwords opens %s", errEncrypted, filepath.Base(in))
+}
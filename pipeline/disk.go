@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// checkSpace returns an error if one of dirs has less than need bytes free.
+func checkSpace(need uint64, dirs ...string) error {
+	for _, dir := range dirs {
+		free, err := freeSpace(dir)
+		if err != nil {
+			return err
+		}
+		if free < need {
+			return fmt.Errorf("%s has %d MiB free, %d MiB needed", dir, free>>20, need>>20)
+		}
+	}
+	return nil
+}
+
+// waitForSpace holds the job for inFile until the temp directory and the
+// watcher path have room for DISK_FACTOR times its size plus DISK_RESERVE.
+// OCR running out of space halfway through would fail the job. It returns
+// false if the jobs are cancelled while waiting.
+func waitForSpace(ctx context.Context, cfg Config, id string, inFile string) bool {
+	if cfg.Disk.Factor <= 0 && cfg.Disk.Reserve <= 0 {
+		return true
+	}
+	fi, err := os.Stat(inFile)
+	if err != nil {
+		// the job reports the missing file
+		return true
+	}
+	need := uint64(float64(fi.Size())*cfg.Disk.Factor) + uint64(cfg.Disk.Reserve)
+
+	warned := false
+	for {
+		err := checkSpace(need, tempRoot, cfg.Watcher.Path)
+		if err == nil {
+			if warned {
+				slog.Info("Disk space available again", "job_id", id, "file", inFile)
+			}
+			return true
+		}
+		if !warned {
+			slog.Warn("Not enough disk space, holding job", "job_id", id, "file", inFile, "error", err, "retry_in", cfg.Disk.Wait)
+			warned = true
+		}
+		if !sleep(ctx, cfg.Disk.Wait) {
+			return false
+		}
+	}
+}
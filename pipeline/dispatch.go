@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// dispatched is a file handed from a watcher instance to the workers over
+// the Redis list DISPATCH_QUEUE.
+type dispatched struct {
+	ID      string `json:"id"`   // job ID on the watcher
+	Host    string `json:"host"` // of the watcher
+	Path    string `json:"path"` // below the watcher path, slash separated
+	Content []byte `json:"content"`
+}
+
+// dispatchStep is the whole pipeline of DISPATCH_ROLE watcher: the input
+// is pushed to the queue, then disposed of like after an upload. Path is
+// kept, so the profiles of the worker apply.
+func dispatchStep(job *Job) error {
+	file := job.Files[0]
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	rel := filepath.Base(file)
+	if r, err := filepath.Rel(job.Cfg.Watcher.Path, job.Input); err == nil && within(job.Cfg.Watcher.Path, job.Input) {
+		rel = filepath.Join(filepath.Dir(r), rel)
+	}
+	host, _ := os.Hostname()
+	msg, err := json.Marshal(dispatched{ID: job.ID, Host: host, Path: filepath.ToSlash(rel), Content: content})
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialRedis(job.ctx, job.Cfg.Dispatch.Redis)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.do("LPUSH", job.Cfg.Dispatch.Queue, string(msg)); err != nil {
+		return err
+	}
+	job.Info("Dispatched to the workers", "queue", job.Cfg.Dispatch.Queue, "path", rel, "bytes", len(content))
+	return job.disposeSources()
+}
+
+// startDispatchWorker takes the files of the watcher instances off the
+// queue with DISPATCH_ROLE worker and puts them into the watcher path,
+// where they are processed like local scans. A file stays in a list of
+// this host until it is written, so a crash doesn't lose it. Files that
+// can't be written go to the list DISPATCH_QUEUE:failed, where they wait
+// to be inspected instead of coming back with every start.
+func startDispatchWorker(ctx context.Context, cfg Config) {
+	if cfg.Dispatch.Role != "worker" {
+		return
+	}
+	host, _ := os.Hostname()
+	taken := cfg.Dispatch.Queue + ":" + host
+	go func() {
+		for ctx.Err() == nil {
+			if err := workQueue(ctx, cfg, taken); err != nil && ctx.Err() == nil {
+				slog.Warn("Dispatch queue failed, reconnecting", "error", err)
+				sleep(ctx, 5*time.Second)
+			}
+		}
+	}()
+}
+
+func workQueue(ctx context.Context, cfg Config, taken string) error {
+	conn, err := dialRedis(ctx, cfg.Dispatch.Redis)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	slog.Info("Taking jobs from the dispatch queue", "queue", cfg.Dispatch.Queue)
+	dead := cfg.Dispatch.Queue + ":failed"
+
+	// files taken before a crash go first
+	for {
+		res, err := conn.do("RPOPLPUSH", taken, cfg.Dispatch.Queue)
+		if err != nil {
+			return err
+		}
+		if res == nil {
+			break
+		}
+	}
+	for ctx.Err() == nil {
+		res, err := conn.do("BRPOPLPUSH", cfg.Dispatch.Queue, taken, "5")
+		if err != nil {
+			return err
+		}
+		msg, ok := res.(string)
+		if !ok {
+			continue
+		}
+		if err := receive(cfg, msg); err != nil {
+			slog.Error("Unable to take dispatched file, moving it to the dead-letter list", "list", dead, "error", err)
+			// pushed first, a crash in between leaves a copy in both
+			// lists rather than none
+			if _, err := conn.do("LPUSH", dead, msg); err != nil {
+				return err
+			}
+		}
+		if _, err := conn.do("LREM", taken, "1", msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive writes a dispatched file to its place below the watcher path.
+func receive(cfg Config, msg string) error {
+	var d dispatched
+	if err := json.Unmarshal([]byte(msg), &d); err != nil {
+		return err
+	}
+	rel := path.Clean("/" + d.Path)[1:]
+	if rel == "" {
+		return errors.New("dispatched file has no name")
+	}
+	dst := freeName(filepath.Join(cfg.Watcher.Path, filepath.FromSlash(rel)))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "scan2webdav-dispatch")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, filepath.Base(dst))
+	if err := os.WriteFile(tmp, d.Content, 0600); err != nil {
+		return err
+	}
+	if err := moveFile(tmp, dst); err != nil {
+		return err
+	}
+	slog.Info("Received dispatched file", "file", dst, "from", d.Host, "watcher_job", d.ID)
+	return nil
+}
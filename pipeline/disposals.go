@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// disposalEntry is an original that is stored already but couldn't be
+// disposed of.
+type disposalEntry struct {
+	Path     string    `json:"path"`
+	Sha256   string    `json:"sha256"`
+	Mode     string    `json:"mode"`
+	Dir      string    `json:"dir,omitempty"` // target of move
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"` // of the last attempt
+}
+
+// disposalStore remembers the originals waiting to be disposed of in the
+// state dir, so the watcher doesn't process them again as new scans.
+type disposalStore struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*disposalEntry // by path
+}
+
+var disposals *disposalStore
+
+func loadDisposalStore(dir string) (*disposalStore, error) {
+	s := &disposalStore{path: filepath.Join(dir, "disposals.json"), entries: map[string]*disposalEntry{}}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*disposalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		s.entries[e.Path] = e
+	}
+	return s, nil
+}
+
+func (s *disposalStore) save() error {
+	entries := make([]*disposalEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	return writeJSON(s.path, entries)
+}
+
+// add remembers that path couldn't be disposed of. It returns false if
+// there is no state dir to remember it in.
+func (s *disposalStore) add(path string, mode string, dir string, reason error) bool {
+	if s == nil {
+		return false
+	}
+	sum, err := fileHash(path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[path]
+	if e == nil || e.Sha256 != sum {
+		e = &disposalEntry{Path: path, Sha256: sum}
+		s.entries[path] = e
+	}
+	e.Mode, e.Dir, e.Reason, e.Time = mode, dir, reason.Error(), time.Now()
+	e.Attempts++
+	if err := s.save(); err != nil {
+		slog.Warn("Unable to remember input to dispose of", "path", path, "error", err)
+	}
+	return true
+}
+
+// forget drops path.
+func (s *disposalStore) forget(path string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[path] == nil {
+		return
+	}
+	delete(s.entries, path)
+	if err := s.save(); err != nil {
+		slog.Warn("Unable to save inputs to dispose of", "error", err)
+	}
+}
+
+// pending reports whether path is an original waiting to be disposed of.
+// A file that changed since is a new scan.
+func (s *disposalStore) pending(path string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	e := s.entries[path]
+	s.mu.Unlock()
+	if e == nil {
+		return false
+	}
+	sum, err := fileHash(path)
+	if err != nil || sum != e.Sha256 {
+		s.forget(path)
+		return false
+	}
+	return true
+}
+
+// list returns a copy of the entries.
+func (s *disposalStore) list() []disposalEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]disposalEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		list = append(list, *e)
+	}
+	return list
+}
+
+// startDisposalRetry tries the pending disposals again every
+// ORIGINALS_RETRY.
+func startDisposalRetry(ctx context.Context, cfg Config) {
+	if disposals == nil {
+		return
+	}
+	go func() {
+		for sleep(ctx, cfg.Originals.Retry) {
+			retryDisposals(cfg)
+		}
+	}()
+}
+
+func retryDisposals(cfg Config) {
+	for _, e := range disposals.list() {
+		if !disposals.pending(e.Path) {
+			slog.Info("Input to dispose of is gone or changed", "path", e.Path)
+			continue
+		}
+		if err := disposeFile(cfg, e.Mode, e.Path, e.Dir); err != nil {
+			disposals.add(e.Path, e.Mode, e.Dir, err)
+			slog.Warn("Still unable to dispose of input", "path", e.Path, "attempts", e.Attempts+1, "error", err)
+			continue
+		}
+		disposals.forget(e.Path)
+		slog.Info("Disposed of input", "path", e.Path, "mode", e.Mode)
+	}
+}
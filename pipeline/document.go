@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Document holds everything known about a processed file. It is the data
+// passed to the naming and routing templates.
+type Document struct {
+	Filename      string    `json:"filename"` // base name of the input file
+	Time          time.Time `json:"time"`
+	Text          string    `json:"text,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	Correspondent string    `json:"correspondent,omitempty"`
+	Date          string    `json:"date,omitempty"`
+	Category      string    `json:"category,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	Barcode       string    `json:"barcode,omitempty"`
+	Barcodes      []string  `json:"barcodes,omitempty"`
+	Hash          string    `json:"hash,omitempty"` // sha256 of the input
+	Duplicate     bool      `json:"duplicate,omitempty"`
+	Favorite      bool      `json:"favorite,omitempty"`
+	Confidence    float64   `json:"confidence,omitempty"` // mean OCR confidence, if measured
+	Review        bool      `json:"review,omitempty"`     // flagged for manual review
+	Language      string    `json:"language,omitempty"`   // OCR language, e.g. deu+eng
+	Receipt       *Receipt  `json:"receipt,omitempty"`    // with RECEIPTS_ENABLED
+	// named groups of the profile pattern, e.g. {{.Match.device}}
+	Match map[string]string `json:"match,omitempty"`
+}
+
+func expand(text string, data interface{}) (string, error) {
+	t, err := template.New("").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var tpl bytes.Buffer
+	if err := t.Execute(&tpl, data); err != nil {
+		return "", err
+	}
+	return tpl.String(), nil
+}
+
+// destination returns the collection URL and the filename doc is uploaded
+// to, applying the matched rule and the configured templates.
+func destination(ctx context.Context, cfg Config, rule *Rule, doc *Document) (string, string) {
+	path, name, prefix := cfg.Server.Path, cfg.Server.Name, ""
+	if rule != nil {
+		if rule.Target != "" {
+			path = rule.Target
+		}
+		prefix = rule.Prefix
+	}
+
+	var err error
+	if path, err = expand(path, doc); err != nil {
+		slog.WarnContext(ctx, "Unable to expand path template", "error", err)
+		path = ""
+	}
+	if name != "" {
+		if name, err = expand(name, doc); err != nil {
+			slog.WarnContext(ctx, "Unable to expand name template", "error", err)
+			name = ""
+		}
+	}
+	if strings.TrimSpace(name) == "" {
+		name = doc.Filename
+	}
+	name = strings.ReplaceAll(prefix+name, "/", "-")
+
+	return sanitizePath(cfg, path), sanitize(cfg, name)
+}
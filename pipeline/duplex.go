@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// duplexer pairs the two passes of a single-sided ADF scanner: a PDF of
+// the fronts followed within DUPLEX_WINDOW by a PDF of the backs, scanned
+// after turning the stack over and thus in reverse order. A PDF without a
+// partner is processed on its own once the window is over.
+type duplexer struct {
+	ctx    context.Context
+	cfg    Config
+	mu     sync.Mutex
+	fronts string
+	timer  *time.Timer
+}
+
+func newDuplexer(ctx context.Context, cfg Config) *duplexer {
+	return &duplexer{ctx: ctx, cfg: cfg}
+}
+
+func (d *duplexer) add(path string) {
+	if ignoredPath(d.cfg, path) {
+		return
+	}
+	if strings.ToLower(filepath.Ext(path)) != ".pdf" {
+		go enqueue(d.ctx, d.cfg, path, true)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fronts == path {
+		return
+	}
+	if d.fronts == "" {
+		slog.Info("Waiting for the backs", "fronts", path, "window", d.cfg.Duplex.Window)
+		d.fronts = path
+		d.timer = time.AfterFunc(d.cfg.Duplex.Window, func() { d.expire(path) })
+		return
+	}
+	fronts := d.fronts
+	d.fronts = ""
+	d.timer.Stop()
+	go d.pair(fronts, path)
+}
+
+// expire processes the fronts alone if no backs came.
+func (d *duplexer) expire(fronts string) {
+	d.mu.Lock()
+	waiting := d.fronts == fronts
+	if waiting {
+		d.fronts = ""
+	}
+	d.mu.Unlock()
+	if waiting {
+		slog.Info("No backs scanned, processing single-sided", "file", fronts)
+		enqueue(d.ctx, d.cfg, fronts, false)
+	}
+}
+
+// pair interleaves fronts and backs if they have the same number of pages,
+// and processes them separately otherwise.
+func (d *duplexer) pair(fronts, backs string) {
+	// Wait 5 seconds to make sure the backs are complete
+	if !sleep(d.ctx, 5*time.Second) {
+		return
+	}
+	nf, errf := api.PageCountFile(fronts)
+	nb, errb := api.PageCountFile(backs)
+	if errf != nil || errb != nil || nf != nb {
+		slog.Info("Page counts differ, processing separately", "fronts", fronts, "backs", backs)
+		go enqueue(d.ctx, d.cfg, fronts, false)
+		go enqueue(d.ctx, d.cfg, backs, false)
+		return
+	}
+	enqueueGroup(d.ctx, d.cfg, []string{fronts, backs}, func(id string, tempDir string) (string, error) {
+		return interleave(id, tempDir, fronts, backs, nf)
+	})
+}
+
+// interleave merges the pages of fronts with the reversed pages of backs
+// into one PDF in tempDir.
+func interleave(id string, tempDir string, fronts, backs string, pages int) (string, error) {
+	slog.Info("Interleaving duplex passes", "job_id", id, "fronts", fronts, "backs", backs, "pages", pages)
+	merged := filepath.Join(tempDir, "duplex.pdf")
+	out := filepath.Join(tempDir, filepath.Base(fronts))
+	err := api.MergeCreateFile([]string{fronts, backs}, merged, false, pdfConf())
+	if err == nil {
+		err = api.CollectFile(merged, out, duplexOrder(pages), pdfConf())
+	}
+	os.Remove(merged)
+	return out, err
+}
+
+// duplexOrder selects the pages of the merged passes in reading order: the
+// first front, the last back, the second front and so on.
+func duplexOrder(pages int) []string {
+	order := make([]string, 0, 2*pages)
+	for i := 1; i <= pages; i++ {
+		order = append(order, strconv.Itoa(i), strconv.Itoa(2*pages+1-i))
+	}
+	return order
+}
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type hashEntry struct {
+	Sha256 string    `json:"sha256"`
+	Image  uint64    `json:"image,omitempty"`
+	Name   string    `json:"name"`
+	Time   time.Time `json:"time"`
+}
+
+// hashStore remembers the hashes of processed documents in the state dir.
+type hashStore struct {
+	mu      sync.Mutex
+	path    string
+	entries []hashEntry
+}
+
+var hashes *hashStore
+
+func loadHashStore(dir string) (*hashStore, error) {
+	s := &hashStore{path: filepath.Join(dir, "hashes.json")}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(data, &s.entries)
+}
+
+// find returns the first entry with the same hash or a first page image
+// within distance.
+func (s *hashStore) find(sum string, img uint64, distance int) *hashEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return findHash(s.entries, sum, img, distance)
+}
+
+func findHash(entries []hashEntry, sum string, img uint64, distance int) *hashEntry {
+	for i, e := range entries {
+		if e.Sha256 == sum {
+			return &entries[i]
+		}
+		if distance > 0 && img != 0 && e.Image != 0 && hammingDistance(img, e.Image) <= distance {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+func (s *hashStore) add(e hashEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return writeJSON(s.path, s.entries)
+}
+
+// writeJSON atomically replaces filename with the JSON encoding of v.
+func writeJSON(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func fileHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dedupeStep skips or flags documents that were processed before.
+func dedupeStep(job *Job) error {
+	mode := job.Cfg.Duplicates.Mode
+	if mode == "" || hashes == nil {
+		return nil
+	}
+
+	sum, err := fileHash(job.Files[0])
+	if err != nil {
+		return err
+	}
+	job.Doc.Hash = sum
+	if job.Cfg.Duplicates.Distance > 0 {
+		img, err := firstPageImage(job.Files[0])
+		if err != nil {
+			job.Warn("Unable to compute image hash", "error", err)
+		} else {
+			job.imageHash = diffHash(img)
+		}
+	}
+
+	dup := hashes.find(sum, job.imageHash, job.Cfg.Duplicates.Distance)
+	if dup == nil && job.Cfg.Duplicates.Shared != "" {
+		entry := hashEntry{Sha256: sum, Image: job.imageHash, Name: job.Doc.Filename, Time: job.Doc.Time}
+		if dup, err = claimShared(job.ctx, job.Cfg, entry, job.Cfg.Duplicates.Distance); err != nil {
+			return fmt.Errorf("shared registry: %w", err)
+		}
+		job.claimed = dup == nil
+	}
+	if dup == nil {
+		return nil
+	}
+	job.Info("Duplicate detected", "original", dup.Name, "processed", dup.Time.Format(time.RFC3339))
+	job.Doc.Duplicate = true
+	if mode == "flag" {
+		job.Doc.Tags = append(job.Doc.Tags, "duplicate")
+		return nil
+	}
+
+	if err := job.disposeSources(); err != nil {
+		return err
+	}
+	return errSkip
+}
+
+// rememberHash records a successfully processed document.
+func rememberHash(job *Job) {
+	if hashes == nil || job.Doc.Hash == "" || job.Doc.Duplicate {
+		return
+	}
+	err := hashes.add(hashEntry{
+		Sha256: job.Doc.Hash,
+		Image:  job.imageHash,
+		Name:   job.Doc.Filename,
+		Time:   job.Doc.Time,
+	})
+	if err != nil {
+		job.Warn("Unable to store document hash", "error", err)
+	}
+}
@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// shipToElastic stores the document of a finished job in ELASTIC_INDEX.
+// Elasticsearch and OpenSearch share the document API, so both work.
+func shipToElastic(job *Job) {
+	cfg := job.Cfg
+	if cfg.Elastic.Url == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(job.ctx), 30*time.Second)
+	defer cancel()
+
+	id, doc := jobDocument(job)
+	if err := putElastic(ctx, cfg, id, doc); err != nil {
+		job.Warn("Unable to index document in Elasticsearch", "url", cfg.Elastic.Url, "error", err)
+	}
+}
+
+func putElastic(ctx context.Context, cfg Config, id string, doc indexedDoc) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(cfg.Elastic.Url, "/") + "/" + url.PathEscape(cfg.Elastic.Index) + "/_doc/" + url.PathEscape(id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case cfg.Elastic.ApiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+cfg.Elastic.ApiKey)
+	case cfg.Elastic.User != "":
+		req.SetBasicAuth(cfg.Elastic.User, cfg.Elastic.Pass)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &storage.StatusError{Op: "PUT", Path: u, Status: res.Status, Code: res.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// esclStatus is the part of /eSCL/ScannerStatus that matters here.
+type esclStatus struct {
+	State    string `xml:"State"`
+	AdfState string `xml:"AdfState"`
+}
+
+const esclSettings = `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScanSettings xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03" xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm">
+	<pwg:Version>2.0</pwg:Version>
+	<pwg:InputSource>Feeder</pwg:InputSource>
+	<scan:ColorMode>%s</scan:ColorMode>
+	<scan:XResolution>%d</scan:XResolution>
+	<scan:YResolution>%d</scan:YResolution>
+	<pwg:DocumentFormat>application/pdf</pwg:DocumentFormat>
+	<scan:DocumentFormatExt>application/pdf</scan:DocumentFormatExt>
+</scan:ScanSettings>`
+
+// startEscl polls the eSCL (AirScan) scanner at ESCL_URL and scans the
+// pages once they are put into its document feeder. The scanner's own
+// scan-to-folder isn't needed then.
+func startEscl(ctx context.Context, cfg Config) {
+	if cfg.Escl.Url == "" {
+		return
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// scanners come with self-signed certificates
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.Escl.Insecure}
+	c := &http.Client{Transport: transport, Timeout: time.Minute}
+	base := strings.TrimSuffix(cfg.Escl.Url, "/") + "/eSCL"
+
+	go func() {
+		slog.Info("Polling scanner", "url", cfg.Escl.Url)
+		// only a newly loaded feeder starts a scan, not one that is
+		// still loaded after a failure
+		armed := true
+		for sleep(ctx, cfg.Escl.Interval) {
+			st, err := esclGetStatus(ctx, c, base)
+			if err != nil {
+				slog.Debug("Unable to get scanner status", "error", err)
+				continue
+			}
+			if st.AdfState != "ScannerAdfLoaded" {
+				armed = true
+				continue
+			}
+			if !armed || st.State != "Idle" {
+				continue
+			}
+			armed = false
+			if _, err := esclScan(ctx, cfg, c, base); err != nil {
+				slog.Error("Scan failed", "scanner", cfg.Escl.Url, "error", err)
+			}
+		}
+	}()
+}
+
+func esclGetStatus(ctx context.Context, c *http.Client, base string) (esclStatus, error) {
+	var st esclStatus
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/ScannerStatus", nil)
+	if err != nil {
+		return st, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return st, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("scanner status: %s", res.Status)
+	}
+	return st, xml.NewDecoder(res.Body).Decode(&st)
+}
+
+// esclScan creates a scan job for the feeder and fetches its documents
+// until the scanner has no more.
+func esclScan(ctx context.Context, cfg Config, c *http.Client, base string) (string, error) {
+	body := fmt.Sprintf(esclSettings, cfg.Escl.ColorMode, cfg.Escl.Resolution, cfg.Escl.Resolution)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/ScanJobs", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	res, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("creating scan job: %s", res.Status)
+	}
+	job, err := res.Location()
+	if err != nil {
+		return "", fmt.Errorf("creating scan job: %w", err)
+	}
+	slog.Info("Scanning", "scanner", cfg.Escl.Url, "job", job)
+
+	dir, err := os.MkdirTemp(tempRoot, "scan-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	next := strings.TrimSuffix(job.String(), "/") + "/NextDocument"
+	deadline := time.Now().Add(10 * time.Minute)
+	for n := 1; ; {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("scan job %s took too long", job)
+		}
+		data, code, err := esclGet(ctx, c, next)
+		if err != nil {
+			return "", err
+		}
+		switch code {
+		case http.StatusOK:
+			f := filepath.Join(dir, fmt.Sprintf("page-%03d.pdf", n))
+			if err := os.WriteFile(f, data, 0600); err != nil {
+				return "", err
+			}
+			n++
+			continue
+		case http.StatusServiceUnavailable:
+			// still scanning
+			if !sleep(ctx, time.Second) {
+				return "", ctx.Err()
+			}
+			continue
+		case http.StatusNotFound:
+			// no more documents
+		default:
+			return "", fmt.Errorf("fetching document: %d", code)
+		}
+		break
+	}
+	return dropScan(cfg, dir)
+}
+
+func esclGet(ctx context.Context, c *http.Client, url string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, res.Body); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), res.StatusCode, nil
+}
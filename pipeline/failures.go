@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// failureEntry is an input that failed to process.
+type failureEntry struct {
+	Path     string    `json:"path"`
+	Sha256   string    `json:"sha256"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"` // of the last attempt
+	// the last failed attempts, oldest first
+	Errors []failedAttempt `json:"errors,omitempty"`
+}
+
+type failedAttempt struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// maxFailedAttempts is the number of errors kept per input.
+const maxFailedAttempts = 10
+
+func (e *failureEntry) add(reason string) {
+	e.Reason = reason
+	e.Attempts++
+	e.Time = time.Now()
+	e.Errors = append(e.Errors, failedAttempt{Time: e.Time, Reason: reason})
+	if len(e.Errors) > maxFailedAttempts {
+		e.Errors = e.Errors[len(e.Errors)-maxFailedAttempts:]
+	}
+}
+
+// failureStore remembers failed inputs in the state dir, so a broken scan
+// isn't processed again on every start.
+type failureStore struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*failureEntry // by input path
+}
+
+var failures *failureStore
+
+func loadFailureStore(dir string) (*failureStore, error) {
+	s := &failureStore{path: filepath.Join(dir, "failures.json"), entries: map[string]*failureEntry{}}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*failureEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		s.entries[e.Path] = e
+	}
+	return s, nil
+}
+
+func (s *failureStore) save() error {
+	entries := make([]*failureEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	return writeJSON(s.path, entries)
+}
+
+// record counts a failed attempt for path and returns the number of
+// attempts so far. A changed file starts over.
+func (s *failureStore) record(path string, sum string, reason string) (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[path]
+	if e == nil || e.Sha256 != sum {
+		e = &failureEntry{Path: path, Sha256: sum}
+		s.entries[path] = e
+	}
+	e.add(reason)
+	return e.Attempts, s.save()
+}
+
+// block records a failure for path that uses up all attempts at once.
+func (s *failureStore) block(path string, sum string, reason string, attempts int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &failureEntry{Path: path, Sha256: sum}
+	e.add(reason)
+	e.Attempts = max(attempts, 1)
+	s.entries[path] = e
+	return s.save()
+}
+
+// forget drops path after it was processed or is retried on request.
+func (s *failureStore) forget(path string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[path] == nil {
+		return nil
+	}
+	delete(s.entries, path)
+	return s.save()
+}
+
+// blocked returns the failure entry of path if it used up its attempts
+// and the file hasn't changed since.
+func (s *failureStore) blocked(path string, attempts int) *failureEntry {
+	if s == nil || attempts <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	e := s.entries[path]
+	s.mu.Unlock()
+	if e == nil || e.Attempts < attempts {
+		return nil
+	}
+	sum, err := fileHash(path)
+	if err != nil || sum != e.Sha256 {
+		return nil
+	}
+	return e
+}
+
+// deadLetters returns the inputs that used up their attempts, oldest
+// first. They stay where they are until they are retried or changed.
+func (s *failureStore) deadLetters(attempts int) []failureEntry {
+	if s == nil || attempts <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []failureEntry
+	for _, e := range s.entries {
+		if e.Attempts >= attempts {
+			list = append(list, *e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list
+}
+
+// rememberFailure records the failed input of job. Stapled and duplex
+// inputs are remembered by their first file.
+func (job *Job) rememberFailure(err error) error {
+	if failures == nil || !exists(job.Input) {
+		return nil
+	}
+	// Doc.Hash may be the hash of a merged document
+	sum, herr := fileHash(job.Input)
+	if herr != nil {
+		return herr
+	}
+	attempts, rerr := failures.record(job.Input, sum, err.Error())
+	if limit := job.Cfg.Retry.Attempts; limit > 0 && attempts == limit {
+		job.Warn("Giving up on input, moved to the dead letters", "attempts", attempts)
+	}
+	return rerr
+}
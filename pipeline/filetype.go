@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// magics are the leading bytes of the image formats scanners write.
+var magics = []struct {
+	prefix []byte
+	ext    string
+}{
+	{[]byte("\xff\xd8\xff"), ".jpg"},
+	{[]byte("\x89PNG\r\n\x1a\n"), ".png"},
+	{[]byte("II*\x00"), ".tif"},
+	{[]byte("MM\x00*"), ".tif"},
+}
+
+// sniffType returns the extension matching the content of f, .pdf, .heic
+// or one of the image extensions, or an empty string for anything else. Some
+// scanners put a few bytes before the PDF header, it is looked for in the
+// first kilobyte.
+func sniffType(f io.ReaderAt) string {
+	head := make([]byte, 1024)
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.prefix) {
+			return m.ext
+		}
+	}
+	if isHEIF(head) {
+		return ".heic"
+	}
+	if bytes.Contains(head, []byte("%PDF-")) {
+		return ".pdf"
+	}
+	return ""
+}
+
+// fileType returns the extension of path in the form sniffType uses.
+func fileType(path string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".jpeg":
+		return ".jpg"
+	case ".tiff":
+		return ".tif"
+	case ".heif":
+		return ".heic"
+	default:
+		return ext
+	}
+}
+
+// retype makes the working file of a job whose input is misnamed, e.g. a
+// JPEG named .pdf, a copy with the extension of its content, so it takes
+// the right way through the steps.
+func (job *Job) retype(kind string) error {
+	if kind == "" || kind == fileType(job.Input) {
+		return nil
+	}
+	job.Warn("File content doesn't match the extension", "file", job.Input, "type", kind)
+	base := filepath.Base(job.Input)
+	in := filepath.Join(job.TempDir, "in", strings.TrimSuffix(base, filepath.Ext(base))+kind)
+	if err := os.MkdirAll(filepath.Dir(in), 0700); err != nil {
+		return err
+	}
+	if err := copyFile(job.Input, in); err != nil {
+		return err
+	}
+	job.Files = []string{in}
+	return nil
+}
@@ -0,0 +1,246 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcMaxMessage bounds submitted documents.
+const grpcMaxMessage = 256 << 20
+
+// eventSubs are the listeners of job events, the gRPC event streams.
+var eventSubs = struct {
+	sync.Mutex
+	chans map[chan jobEvent]struct{}
+}{chans: map[chan jobEvent]struct{}{}}
+
+// subscribeEvents returns a channel receiving the job events and the
+// function ending the subscription.
+func subscribeEvents() (chan jobEvent, func()) {
+	ch := make(chan jobEvent, 64)
+	eventSubs.Lock()
+	eventSubs.chans[ch] = struct{}{}
+	eventSubs.Unlock()
+	return ch, func() {
+		eventSubs.Lock()
+		delete(eventSubs.chans, ch)
+		eventSubs.Unlock()
+	}
+}
+
+// publishEvent hands e to the listeners. Slow ones miss events rather than
+// holding up the jobs.
+func publishEvent(e jobEvent) {
+	eventSubs.Lock()
+	defer eventSubs.Unlock()
+	for ch := range eventSubs.chans {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// progress publishes the start of the step name.
+func (job *Job) progress(name string) {
+	eventSubs.Lock()
+	listening := len(eventSubs.chans) > 0
+	eventSubs.Unlock()
+	if !listening {
+		return
+	}
+	e := job.event("running", nil)
+	e.Step = name
+	publishEvent(e)
+}
+
+// grpcServer implements the gRPC API of rpc/scan2webdav.proto.
+type grpcServer struct {
+	rpc.UnimplementedScan2WebDAVServer
+	cfg Config
+}
+
+// grpcSrv serves the gRPC API, nil if it is off.
+var grpcSrv *grpc.Server
+
+// setupGRPC serves the gRPC API on GRPC_LISTEN, protected by HTTP_TOKEN
+// like the JSON API.
+func setupGRPC(cfg Config) error {
+	if cfg.Grpc.Listen == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", cfg.Grpc.Listen)
+	if err != nil {
+		return fmt.Errorf("unable to serve gRPC: %w", err)
+	}
+	srv := grpc.NewServer(
+		grpc.MaxRecvMsgSize(grpcMaxMessage),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := grpcAuth(cfg, ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAuth(cfg, ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	rpc.RegisterScan2WebDAVServer(srv, &grpcServer{cfg: cfg})
+	grpcSrv = srv
+	go func() {
+		slog.Info("Serving gRPC", "addr", cfg.Grpc.Listen)
+		if err := srv.Serve(ln); err != nil {
+			slog.Error("Unable to serve gRPC", "error", err)
+		}
+	}()
+	return nil
+}
+
+// closeGRPC stops the gRPC server. Calls in flight get a few seconds,
+// streams are cut off after them.
+func closeGRPC() {
+	if grpcSrv == nil {
+		return
+	}
+	srv := grpcSrv
+	grpcSrv = nil
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		srv.Stop()
+	}
+}
+
+func grpcAuth(cfg Config, ctx context.Context) error {
+	if cfg.Http.Token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, _ := strings.CutPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Http.Token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid token")
+}
+
+// Submit queues the file at path or, without, stores the uploaded content
+// in the watcher path, where the watcher picks it up like a scan.
+func (s *grpcServer) Submit(ctx context.Context, req *rpc.SubmitRequest) (*rpc.SubmitResponse, error) {
+	if req.Path != "" {
+		path, err := submitPath(s.cfg, req.Path)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		failures.forget(path)
+		go enqueue(jobCtx, s.cfg, path, false)
+		return &rpc.SubmitResponse{Path: path}, nil
+	}
+
+	name := filepath.Base(req.Filename)
+	if name == "." || name == string(filepath.Separator) || len(req.Content) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "path or filename and content are missing")
+	}
+	dir, err := os.MkdirTemp("", "scan2webdav-submit")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, name)
+	if err := os.WriteFile(tmp, req.Content, 0600); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	dst := freeName(filepath.Join(s.cfg.Watcher.Path, name))
+	if err := moveFile(tmp, dst); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	slog.Info("Document submitted", "file", dst, "bytes", len(req.Content))
+	return &rpc.SubmitResponse{Path: dst}, nil
+}
+
+// Events streams the job events, of one input if given, until the client
+// goes away.
+func (s *grpcServer) Events(req *rpc.EventsRequest, stream grpc.ServerStreamingServer[rpc.JobEvent]) error {
+	ch, done := subscribeEvents()
+	defer done()
+	for {
+		select {
+		case e := <-ch:
+			if req.Input != "" && e.Input != req.Input {
+				continue
+			}
+			if err := stream.Send(&rpc.JobEvent{
+				Id:       e.ID,
+				Status:   e.Status,
+				Step:     e.Step,
+				Input:    e.Input,
+				Url:      e.URL,
+				Uploads:  e.Uploads,
+				Duration: e.Duration,
+				Pages:    int32(e.Pages),
+				Bytes:    e.Bytes,
+				Error:    e.Error,
+			}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *grpcServer) History(ctx context.Context, req *rpc.HistoryRequest) (*rpc.HistoryResponse, error) {
+	if history == nil {
+		return nil, status.Error(codes.FailedPrecondition, "job history is disabled")
+	}
+	q := historyQuery{ID: req.Id, Status: req.Status, Hash: req.Hash, Limit: int(req.Limit)}
+	if req.Since != nil {
+		q.Since = req.Since.AsTime()
+	}
+	entries, err := history.query(q)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &rpc.HistoryResponse{}
+	for _, e := range entries {
+		res.Entries = append(res.Entries, &rpc.HistoryEntry{
+			Id:       e.ID,
+			Input:    e.Input,
+			Hash:     e.Hash,
+			Url:      e.URL,
+			Uploads:  e.Uploads,
+			Status:   e.Status,
+			Error:    e.Error,
+			Engine:   e.Engine,
+			Started:  timestamppb.New(e.Started),
+			Finished: timestamppb.New(e.Finished),
+			Pages:    int32(e.Pages),
+			Bytes:    e.Bytes,
+		})
+	}
+	return res, nil
+}
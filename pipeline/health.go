@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// health is the state reported on /healthz.
+var health struct {
+	sync.Mutex
+	watching   bool
+	lastUpload time.Time
+}
+
+func setWatching(watching bool) {
+	health.Lock()
+	health.watching = watching
+	health.Unlock()
+}
+
+func uploadSucceeded() {
+	health.Lock()
+	health.lastUpload = time.Now()
+	health.Unlock()
+}
+
+type healthReport struct {
+	Status     string         `json:"status"`
+	Watching   bool           `json:"watching"`
+	LastUpload *time.Time     `json:"last_upload,omitempty"`
+	Queue      map[string]int `json:"queue"`
+	Server     string         `json:"server"`
+	Circuit    string         `json:"circuit,omitempty"` // open while uploads are held
+	Error      string         `json:"error,omitempty"`
+}
+
+// healthHandler reports ok with 200 while the watcher runs and the server
+// answers, 503 otherwise.
+func healthHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{Status: "ok", Server: "reachable", Queue: map[string]int{}}
+
+		health.Lock()
+		report.Watching = health.watching
+		if !health.lastUpload.IsZero() {
+			last := health.lastUpload
+			report.LastUpload = &last
+		}
+		health.Unlock()
+		if queue != nil {
+			report.Queue["jobs"] = queue.len()
+		}
+		if uploads != nil {
+			report.Queue["uploads"] = uploads.len()
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if err := checkServer(ctx, cfg); err != nil {
+			report.Server = "unreachable"
+			report.Error = err.Error()
+		}
+		if circuitOpen() {
+			report.Circuit = "open"
+		}
+
+		code := http.StatusOK
+		if !report.Watching || report.Error != "" {
+			report.Status = "unhealthy"
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// checkServer looks up the root of the upload target. Servers that don't
+// allow looking at a collection still count as reachable, unless they
+// reject the credentials or fail.
+func checkServer(ctx context.Context, cfg Config) error {
+	store, err := newStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+	_, err = store.Exists(ctx, "")
+	var se *storage.StatusError
+	if errors.As(err, &se) && se.Code < 500 && se.Code != http.StatusUnauthorized && se.Code != http.StatusForbidden {
+		return nil
+	}
+	return err
+}
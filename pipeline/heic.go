@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// heifBrands are the major brands of the ISO BMFF ftyp box of HEIC/HEIF
+// still images, as written by phone cameras.
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1"}
+
+// isHEIF tells if head starts like a HEIC/HEIF file.
+func isHEIF(head []byte) bool {
+	if len(head) < 12 || string(head[4:8]) != "ftyp" {
+		return false
+	}
+	for _, b := range heifBrands {
+		if string(head[8:12]) == b {
+			return true
+		}
+	}
+	return false
+}
+
+// heicStep turns HEIC/HEIF images, like the document captures of iPhones,
+// into JPEGs with HEIC_EXEC, as Go has no decoder for them. The steps after
+// take them like any scanned image.
+func heicStep(job *Job) error {
+	dir := filepath.Join(job.TempDir, "heic")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, in := range job.Files {
+		out := filepath.Join(dir, filepath.Base(in))
+		if fileType(in) != ".heic" {
+			if err := copyFile(in, out); err != nil {
+				return err
+			}
+			job.Files[i] = out
+			continue
+		}
+		out = strings.TrimSuffix(out, filepath.Ext(out)) + ".jpg"
+		cmd := exec.CommandContext(job.ctx, job.Cfg.Heic.Exec, "-q", "95", in, out)
+		cmd.WaitDelay = 10 * time.Second
+		output, err := cmd.CombinedOutput()
+		job.output("heif-convert", output)
+		if err == nil && !exists(out) {
+			err = errors.New("no image written")
+		}
+		if err != nil {
+			return fmt.Errorf("converting %s: %w", filepath.Base(in), err)
+		}
+		job.Info("Converted HEIC image", "file", filepath.Base(in))
+		job.Files[i] = out
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package pipeline
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// historyEntry is a finished job as recorded in the history database.
+type historyEntry struct {
+	ID       string            `json:"id"`
+	Input    string            `json:"input"`
+	Hash     string            `json:"hash,omitempty"`
+	URL      string            `json:"url"`
+	Uploads  []string          `json:"uploads,omitempty"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Engine   string            `json:"engine"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	Steps    map[string]string `json:"steps,omitempty"` // step durations
+	Pages    int               `json:"pages"`
+	Bytes    int64             `json:"bytes"`
+	// resources of the OCR subprocesses
+	OcrWall   float64 `json:"ocr_wall,omitempty"` // seconds
+	OcrCPU    float64 `json:"ocr_cpu,omitempty"`  // seconds
+	OcrMaxRSS int64   `json:"ocr_max_rss,omitempty"`
+}
+
+// jobHistory records every finished job in an SQLite database in the state
+// directory.
+type jobHistory struct {
+	db *sql.DB
+}
+
+var history *jobHistory
+
+func closeHistory() {
+	if history != nil {
+		history.db.Close()
+		history = nil
+	}
+}
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id       TEXT PRIMARY KEY,
+	input    TEXT NOT NULL,
+	hash     TEXT NOT NULL,
+	url      TEXT NOT NULL,
+	uploads  TEXT NOT NULL,
+	status   TEXT NOT NULL,
+	error    TEXT NOT NULL,
+	engine   TEXT NOT NULL,
+	started  INTEGER NOT NULL,
+	finished INTEGER NOT NULL,
+	steps    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_finished ON jobs (finished);
+CREATE INDEX IF NOT EXISTS jobs_hash ON jobs (hash);
+`
+
+// historyMigrations upgrade the schema, the index into the slice is the
+// PRAGMA user_version reached with the migration.
+var historyMigrations = []string{
+	1: `ALTER TABLE jobs ADD COLUMN pages INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE jobs ADD COLUMN bytes INTEGER NOT NULL DEFAULT 0;`,
+	// originals that were deleted, for the consistency check
+	2: `CREATE TABLE deletions (
+			id      TEXT PRIMARY KEY,
+			input   TEXT NOT NULL,
+			server  TEXT NOT NULL,
+			remotes TEXT NOT NULL,
+			deleted INTEGER NOT NULL,
+			missing TEXT NOT NULL DEFAULT ''
+		);`,
+	3: `ALTER TABLE jobs ADD COLUMN ocr_wall INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE jobs ADD COLUMN ocr_cpu INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE jobs ADD COLUMN ocr_max_rss INTEGER NOT NULL DEFAULT 0;`,
+}
+
+func openHistory(stateDir string) (*jobHistory, error) {
+	db, err := sql.Open("sqlite", filepath.Join(stateDir, "history.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrateHistory(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &jobHistory{db: db}, nil
+}
+
+func migrateHistory(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for v := version + 1; v < len(historyMigrations); v++ {
+		if _, err := db.Exec(historyMigrations[v]); err != nil {
+			return fmt.Errorf("migrating job history to version %d: %w", v, err)
+		}
+		if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record adds a finished job. Retried jobs get a new ID and a new row.
+func (h *jobHistory) record(job *Job, status string, err error) error {
+	if h == nil {
+		return nil
+	}
+	e := historyEntry{
+		ID:       job.ID,
+		Input:    job.Input,
+		Hash:     job.Doc.Hash,
+		URL:      job.URL,
+		Uploads:  job.uploaded,
+		Status:   status,
+		Engine:   ocrEngine(job.Cfg),
+		Started:  job.started,
+		Finished: time.Now(),
+		Steps:    map[string]string{},
+		Pages:    job.pages,
+		Bytes:    job.bytes,
+
+		OcrWall:   job.usage.Wall.Seconds(),
+		OcrCPU:    job.usage.CPU.Seconds(),
+		OcrMaxRSS: job.usage.MaxRSS,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	for name, d := range job.timings {
+		e.Steps[name] = d.Round(time.Millisecond).String()
+	}
+	if (job.disposal == "delete" || job.disposal == "trash") && len(job.remotes) > 0 {
+		remotes, _ := json.Marshal(job.remotes)
+		_, err := h.db.Exec(`INSERT OR REPLACE INTO deletions (id, input, server, remotes, deleted) VALUES (?, ?, ?, ?, ?)`,
+			e.ID, e.Input, job.Cfg.Server.Url, string(remotes), e.Finished.UnixMilli())
+		if err != nil {
+			return err
+		}
+	}
+	uploads, _ := json.Marshal(e.Uploads)
+	steps, _ := json.Marshal(e.Steps)
+	_, err = h.db.Exec(`INSERT OR REPLACE INTO jobs
+		(id, input, hash, url, uploads, status, error, engine, started, finished, steps, pages, bytes, ocr_wall, ocr_cpu, ocr_max_rss)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.Input, e.Hash, e.URL, string(uploads), e.Status, e.Error, e.Engine,
+		e.Started.UnixMilli(), e.Finished.UnixMilli(), string(steps), e.Pages, e.Bytes,
+		job.usage.Wall.Milliseconds(), job.usage.CPU.Milliseconds(), e.OcrMaxRSS)
+	return err
+}
+
+// historyQuery selects entries, newest first. Empty fields match anything.
+type historyQuery struct {
+	ID     string
+	Status string
+	Hash   string
+	Since  time.Time
+	Limit  int
+}
+
+func (h *jobHistory) query(q historyQuery) ([]historyEntry, error) {
+	where, args := "1 = 1", []any{}
+	if q.ID != "" {
+		where += " AND id = ?"
+		args = append(args, q.ID)
+	}
+	if q.Status != "" {
+		where += " AND status = ?"
+		args = append(args, q.Status)
+	}
+	if q.Hash != "" {
+		where += " AND hash = ?"
+		args = append(args, q.Hash)
+	}
+	if !q.Since.IsZero() {
+		where += " AND finished >= ?"
+		args = append(args, q.Since.UnixMilli())
+	}
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+	args = append(args, q.Limit)
+	rows, err := h.db.Query(`SELECT id, input, hash, url, uploads, status, error, engine, started, finished, steps, pages, bytes, ocr_wall, ocr_cpu, ocr_max_rss
+		FROM jobs WHERE `+where+` ORDER BY finished DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+// between returns the jobs finished from from until before to, the oldest
+// first.
+func (h *jobHistory) between(from, to time.Time) ([]historyEntry, error) {
+	rows, err := h.db.Query(`SELECT id, input, hash, url, uploads, status, error, engine, started, finished, steps, pages, bytes, ocr_wall, ocr_cpu, ocr_max_rss
+		FROM jobs WHERE finished >= ? AND finished < ? ORDER BY finished`, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func scanEntries(rows *sql.Rows) ([]historyEntry, error) {
+	defer rows.Close()
+	entries := []historyEntry{}
+	for rows.Next() {
+		var e historyEntry
+		var uploads, steps string
+		var started, finished, wall, cpu int64
+		if err := rows.Scan(&e.ID, &e.Input, &e.Hash, &e.URL, &uploads, &e.Status, &e.Error, &e.Engine, &started, &finished, &steps, &e.Pages, &e.Bytes, &wall, &cpu, &e.OcrMaxRSS); err != nil {
+			return nil, err
+		}
+		e.Started, e.Finished = time.UnixMilli(started), time.UnixMilli(finished)
+		e.OcrWall, e.OcrCPU = float64(wall)/1000, float64(cpu)/1000
+		json.Unmarshal([]byte(uploads), &e.Uploads)
+		json.Unmarshal([]byte(steps), &e.Steps)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ocrEngine describes the OCR command used for a job.
+func ocrEngine(cfg Config) string {
+	if cfg.Ocr.Engine == "docker" {
+		return "docker:" + cfg.Ocr.Image
+	}
+	return fmt.Sprintf("local:%s", filepath.Base(cfg.Ocr.Exec))
+}
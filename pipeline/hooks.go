@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/google/shlex"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// jobInfo is the JSON description of a job passed to external commands.
+type jobInfo struct {
+	Input    string   `json:"input"`
+	Files    []string `json:"files"`
+	URL      string   `json:"url"`
+	Rule     string   `json:"rule,omitempty"`
+	Document Document `json:"document"`
+}
+
+func (job *Job) info() jobInfo {
+	info := jobInfo{
+		Input:    job.Input,
+		Files:    job.Files,
+		URL:      job.URL,
+		Document: job.Doc,
+	}
+	if job.Rule != nil {
+		info.Rule = job.Rule.Name
+	}
+	return info
+}
+
+// runHook executes command with the working files as arguments and the job
+// description as JSON on stdin.
+func runHook(name string, command string, job *Job) error {
+	if command == "" {
+		return nil
+	}
+	args, err := shlex.Split(command)
+	if err != nil {
+		return fmt.Errorf("parsing %s hook: %w", name, err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	args = append(args, job.Files...)
+
+	stdin, err := json.Marshal(job.info())
+	if err != nil {
+		return err
+	}
+
+	job.Info("Running hook", "hook", name, "args", args)
+	end := job.startSpan("hook "+name, attribute.StringSlice("args", args))
+	cmd := exec.CommandContext(job.ctx, args[0], args[1:]...)
+	cmd.WaitDelay = 10 * time.Second
+	cmd.Stdin = bytes.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	job.output(name+" hook", out)
+	end(err)
+	if err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
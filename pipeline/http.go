@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// mux serves the HTTP endpoints of the daemon on HTTP_LISTEN. Every Run
+// registers them on a new one.
+var mux *http.ServeMux
+
+// httpServer serves mux, nil if HTTP is off.
+var httpServer *http.Server
+
+// setupHTTP serves on the socket passed by systemd socket activation, if
+// any, which leaves access control to the socket unit, otherwise on
+// HTTP_LISTEN.
+func setupHTTP(cfg Config) error {
+	mux = http.NewServeMux()
+	ln, err := activationListener()
+	if err != nil {
+		return fmt.Errorf("unable to use the socket passed by systemd: %w", err)
+	}
+	if cfg.Http.Listen == "" && ln == nil {
+		return nil
+	}
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("GET /healthz", healthHandler(cfg))
+	if cfg.Http.Dashboard {
+		setupDashboard(cfg)
+	}
+	if cfg.Http.Api {
+		setupApi(cfg)
+	}
+	if cfg.Http.Pprof {
+		setupPprof(cfg)
+	}
+
+	if ln != nil {
+		slog.Info("Serving HTTP", "addr", ln.Addr().String(), "socket", "systemd")
+	} else {
+		if ln, err = net.Listen("tcp", cfg.Http.Listen); err != nil {
+			return fmt.Errorf("unable to serve HTTP: %w", err)
+		}
+		slog.Info("Serving HTTP", "addr", cfg.Http.Listen)
+	}
+	srv := &http.Server{Handler: mux}
+	httpServer = srv
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Unable to serve HTTP", "error", err)
+		}
+	}()
+	return nil
+}
+
+// closeHTTP stops the HTTP server. Requests in flight get a few seconds,
+// event streams are cut off after them.
+func closeHTTP() {
+	if httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		httpServer.Close()
+	}
+	httpServer = nil
+}
+
+// setupPprof serves the runtime profiles below /debug/pprof/, protected by
+// HTTP_TOKEN like the API.
+func setupPprof(cfg Config) {
+	handle := func(pattern string, fn http.HandlerFunc) {
+		mux.Handle(pattern, apiAuth(cfg, fn))
+	}
+	handle("GET /debug/pprof/", pprof.Index)
+	handle("GET /debug/pprof/cmdline", pprof.Cmdline)
+	handle("GET /debug/pprof/profile", pprof.Profile)
+	handle("GET /debug/pprof/symbol", pprof.Symbol)
+	handle("GET /debug/pprof/trace", pprof.Trace)
+	slog.Warn("Profiling endpoints enabled", "path", "/debug/pprof/")
+}
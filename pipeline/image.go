@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	_ "golang.org/x/image/tiff"
+)
+
+// firstPageImage returns the image of an image file or the largest image on
+// the first page of a PDF, which for scans is the page itself.
+func firstPageImage(file string) (image.Image, error) {
+	images, err := pageImages(file, []string{"1"})
+	if err != nil {
+		return nil, err
+	}
+	if images[0] == nil {
+		return nil, errors.New("no decodable image on first page")
+	}
+	return images[0], nil
+}
+
+// pageImages returns the image of an image file or the largest image of
+// each selected page of a PDF, all pages if selected is nil. Pages without
+// a decodable image have a nil entry.
+func pageImages(file string, selected []string) ([]image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if imageExts[strings.ToLower(filepath.Ext(file))] {
+		img, _, err := image.Decode(f)
+		return []image.Image{img}, err
+	}
+
+	pages, err := api.ExtractImagesRaw(f, selected, pdfConf())
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("no pages")
+	}
+	images := make([]image.Image, len(pages))
+	for i, page := range pages {
+		size := 0
+		for _, pimg := range page {
+			img, _, err := image.Decode(pimg)
+			if err != nil {
+				continue
+			}
+			if b := img.Bounds(); b.Dx()*b.Dy() > size {
+				images[i], size = img, b.Dx()*b.Dy()
+			}
+		}
+	}
+	return images, nil
+}
+
+// diffHash computes a 64 bit difference hash of img. Similar images have
+// hashes with a small hamming distance.
+func diffHash(img image.Image) uint64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var gray [8][9]uint32
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			// average a sparse grid of pixels per cell
+			var sum, n uint32
+			for sy := 0; sy < 4; sy++ {
+				for sx := 0; sx < 4; sx++ {
+					px := b.Min.X + (x*4+sx)*w/36
+					py := b.Min.Y + (y*4+sy)*h/32
+					r, g, bl, _ := img.At(px, py).RGBA()
+					sum += (r*299 + g*587 + bl*114) / 1000
+					n++
+				}
+			}
+			gray[y][x] = sum / n
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func hammingDistance(a, b uint64) int {
+	n := 0
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
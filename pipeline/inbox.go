@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// inbox tracks the files of the remote folder INBOX_URL, for scanners that
+// can only write to a cloud folder or a file share.
+var inbox = struct {
+	sync.Mutex
+	seen    map[string]string // remote path to the ETag of the last poll
+	fetched map[string]string // local file to the remote original
+}{seen: map[string]string{}, fetched: map[string]string{}}
+
+// inboxSource is the folder polled by the inbox, a WebDAV collection or an
+// SMB share. Paths are relative to INBOX_URL.
+type inboxSource interface {
+	list(ctx context.Context, remotePath string) ([]storage.Entry, error)
+	get(ctx context.Context, remotePath string, filename string) error
+	// dispose deletes remotePath or, with move set, moves it to that
+	// directory below the inbox
+	dispose(ctx context.Context, remotePath string, move string) error
+}
+
+// newInboxSource returns the source of INBOX_URL, an SMB share for smb://
+// URLs.
+func newInboxSource(cfg Config) (inboxSource, error) {
+	if strings.HasPrefix(cfg.Inbox.Url, "smb://") {
+		return newSmbInbox(cfg)
+	}
+	return webdavInbox{inboxStorage(cfg)}, nil
+}
+
+// inboxStorage returns the storage of the inbox collection, with the
+// SERVER_ credentials unless INBOX_USER is set.
+func inboxStorage(cfg Config) *storage.WebDAV {
+	c := cfg
+	c.Server.Url = strings.TrimSuffix(cfg.Inbox.Url, "/")
+	if cfg.Inbox.User != "" {
+		c.Server.User, c.Server.Pass, c.Server.Token = cfg.Inbox.User, cfg.Inbox.Pass, ""
+		c.OAuth.TokenUrl = ""
+	}
+	return newWebDAV(c)
+}
+
+// startInbox polls INBOX_URL every INBOX_INTERVAL and downloads the files
+// into the watcher path once their ETag stopped changing, so they are
+// processed like local scans. The originals are disposed of after their
+// job succeeded.
+func startInbox(ctx context.Context, cfg Config) {
+	if cfg.Inbox.Url == "" {
+		return
+	}
+	s, err := newInboxSource(cfg)
+	if err != nil {
+		slog.Error("Unable to poll inbox", "url", cfg.Inbox.Url, "error", err)
+		return
+	}
+	go func() {
+		slog.Info("Polling inbox", "url", cfg.Inbox.Url, "interval", cfg.Inbox.Interval)
+		for {
+			if err := pollInbox(ctx, cfg, s); err != nil && ctx.Err() == nil {
+				slog.Warn("Unable to poll inbox", "url", cfg.Inbox.Url, "error", err)
+			}
+			if !sleep(ctx, cfg.Inbox.Interval) {
+				return
+			}
+		}
+	}()
+}
+
+func pollInbox(ctx context.Context, cfg Config, s inboxSource) error {
+	entries, err := s.list(ctx, "")
+	if err != nil {
+		return err
+	}
+	inbox.Lock()
+	last := inbox.seen
+	inbox.seen = map[string]string{}
+	for _, e := range entries {
+		if !e.Collection {
+			inbox.seen[e.Path] = fmt.Sprintf("%s/%d", e.ETag, e.Size)
+		}
+	}
+	fetched := map[string]bool{}
+	for _, remote := range inbox.fetched {
+		fetched[remote] = true
+	}
+	var stable []string
+	for p, tag := range inbox.seen {
+		if last[p] == tag && !fetched[p] {
+			stable = append(stable, p)
+		}
+	}
+	inbox.Unlock()
+
+	for _, p := range stable {
+		local := filepath.Join(cfg.Watcher.Path, path.Base(p))
+		if exists(local) {
+			// still waiting for its job
+			continue
+		}
+		if err := fetchInbox(ctx, s, p, local); err != nil {
+			slog.Warn("Unable to download from inbox", "path", p, "error", err)
+		}
+	}
+	return nil
+}
+
+// fetchInbox downloads remote to local, through a temp file so the watcher
+// sees it complete.
+func fetchInbox(ctx context.Context, s inboxSource, remote, local string) error {
+	dir, err := os.MkdirTemp("", "scan2webdav-inbox")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, filepath.Base(local))
+	if err := s.get(ctx, remote, tmp); err != nil {
+		return err
+	}
+	inbox.Lock()
+	inbox.fetched[local] = remote
+	inbox.Unlock()
+	if err := moveFile(tmp, local); err != nil {
+		inbox.Lock()
+		delete(inbox.fetched, local)
+		inbox.Unlock()
+		return err
+	}
+	slog.Info("Downloaded from inbox", "path", remote, "file", local)
+	return nil
+}
+
+// disposeInbox deletes the remote original of a finished job downloaded
+// from the inbox, or moves it to INBOX_MOVE. Failures are only logged, the
+// document is stored already.
+func disposeInbox(job *Job) {
+	inbox.Lock()
+	remote, ok := inbox.fetched[job.Input]
+	delete(inbox.fetched, job.Input)
+	inbox.Unlock()
+	if !ok {
+		return
+	}
+	s, err := newInboxSource(job.Cfg)
+	if err == nil {
+		err = s.dispose(context.WithoutCancel(job.ctx), remote, job.Cfg.Inbox.Move)
+	}
+	if err != nil {
+		job.Warn("Unable to dispose of the inbox original", "path", remote, "error", err)
+		return
+	}
+	job.Info("Disposed of the inbox original", "path", remote, "moved", job.Cfg.Inbox.Move != "")
+}
+
+// movedName is the name of a disposed of inbox original in the INBOX_MOVE
+// directory, with the time so earlier ones aren't overwritten.
+func movedName(name string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + inZone(time.Now()).Format("20060102-150405") + ext
+}
+
+// webdavInbox is the inbox collection on the WebDAV server.
+type webdavInbox struct {
+	*storage.WebDAV
+}
+
+func (s webdavInbox) list(ctx context.Context, remotePath string) ([]storage.Entry, error) {
+	return s.List(ctx, remotePath)
+}
+
+func (s webdavInbox) get(ctx context.Context, remotePath string, filename string) error {
+	return s.Get(ctx, remotePath, filename)
+}
+
+// dispose deletes remotePath or moves it to the collection move.
+func (s webdavInbox) dispose(ctx context.Context, remotePath string, move string) error {
+	if move == "" {
+		return s.Remove(ctx, remotePath)
+	}
+	if err := s.Mkdir(ctx, move); err != nil {
+		return fmt.Errorf("creating the inbox archive: %w", err)
+	}
+	return s.Rename(ctx, remotePath, path.Join(move, movedName(path.Base(remotePath))))
+}
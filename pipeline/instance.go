@@ -0,0 +1,22 @@
+package pipeline
+
+import (
+	"io"
+
+	"github.com/chbmuc/scan2webdav/watcher"
+)
+
+// instanceLock holds the lock on the watcher path for the lifetime of the
+// process.
+var instanceLock io.Closer
+
+// lockWatchPath locks the watcher directory, so a second instance watching
+// the same path refuses to start instead of running duplicate jobs.
+func lockWatchPath(path string) error {
+	f, err := watcher.Lock(path)
+	if err != nil {
+		return err
+	}
+	instanceLock = f
+	return nil
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// jobLogSize is the size of the job log kept in memory for the dashboard.
+const jobLogSize = 64 << 10
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// openLog starts the job log. It is kept in memory and, with LOG_JOB_PATH,
+// in a file.
+func (job *Job) openLog() {
+	if job.logs == nil {
+		job.logs = &tailBuffer{max: jobLogSize}
+	}
+	var w io.Writer = job.logs
+	if f := job.openLogFile(); f != nil {
+		job.logFile = f
+		w = io.MultiWriter(f, job.logs)
+	}
+	job.logger = slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// openLogFile opens the job log file in LOG_JOB_PATH. Resumed jobs append
+// to the file of the interrupted run.
+func (job *Job) openLogFile() *os.File {
+	dir := job.Cfg.Log.JobPath
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		slog.Warn("Unable to create job log directory", "error", err)
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s-%s.log", job.Doc.Time.Format("20060102-150405"), job.ID, job.Doc.Filename)
+	job.LogFile = filepath.Join(dir, name)
+	f, err := os.OpenFile(job.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		slog.Warn("Unable to open job log", "error", err)
+		job.LogFile = ""
+		return nil
+	}
+	return f
+}
+
+func (job *Job) closeLog() {
+	if job.logFile != nil {
+		job.logFile.Close()
+	}
+	job.logFile, job.logger = nil, nil
+}
+
+// log writes to the process log and the job log. The job log records every
+// level, the process log only what LOG_LEVEL lets through.
+func (job *Job) log(level slog.Level, msg string, args ...any) {
+	slog.Log(job.ctx, level, msg, args...)
+	if job.logger != nil {
+		job.logger.Log(context.Background(), level, msg, args...)
+	}
+}
+
+func (job *Job) Debug(msg string, args ...any) { job.log(slog.LevelDebug, msg, args...) }
+func (job *Job) Info(msg string, args ...any)  { job.log(slog.LevelInfo, msg, args...) }
+func (job *Job) Warn(msg string, args ...any)  { job.log(slog.LevelWarn, msg, args...) }
+func (job *Job) Error(msg string, args ...any) { job.log(slog.LevelError, msg, args...) }
+
+// output records the output of a command. With a job log file it is kept
+// out of the process log where concurrent jobs would interleave.
+func (job *Job) output(name string, out []byte) {
+	if len(out) == 0 {
+		return
+	}
+	job.lastOutput = excerpt(string(out), outputExcerpt)
+	if job.logger != nil {
+		job.logger.Info("Command output", "command", name, "output", string(out))
+	}
+	if job.logFile == nil {
+		slog.DebugContext(job.ctx, "Command output", "command", name, "output", string(out))
+	}
+}
+
+func (job *Job) timing(name string, start time.Time) {
+	if job.timings == nil {
+		job.timings = map[string]time.Duration{}
+	}
+	job.timings[name] += time.Since(start)
+	job.Info("Step finished", "step", name, "duration", time.Since(start).Round(time.Millisecond))
+}
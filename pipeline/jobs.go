@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// historySize is the number of finished jobs kept for the dashboard.
+const historySize = 100
+
+// jobStatus is a snapshot of a job for the dashboard.
+type jobStatus struct {
+	ID       string    `json:"id"`
+	Input    string    `json:"input"`
+	State    string    `json:"state"`
+	Step     string    `json:"step,omitempty"`
+	Status   string    `json:"status"` // active, done, failed or cancelled
+	Error    string    `json:"error,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+
+	job *Job
+}
+
+// jobs keeps track of the unfinished jobs and the recently finished ones.
+var jobs = struct {
+	sync.Mutex
+	active  map[string]*jobStatus
+	history []*jobStatus // oldest first
+}{active: map[string]*jobStatus{}}
+
+// checkpoint records the progress of job in the journal and for the
+// dashboard.
+func (job *Job) checkpoint() {
+	journal.save(job)
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	info := jobs.active[job.ID]
+	if info == nil {
+		info = &jobStatus{ID: job.ID, Input: job.Input, Status: "active", Started: time.Now(), job: job}
+		jobs.active[job.ID] = info
+	}
+	info.State = job.State
+	info.Step = ""
+	if job.next < len(job.Cfg.Pipeline.Steps) {
+		info.Step = job.Cfg.Pipeline.Steps[job.next]
+	}
+}
+
+// finish moves job into the history.
+func (job *Job) finish(status string, err error) {
+	job.span.SetAttributes(attribute.String("status", status))
+	endSpan(job.span, err)
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	info := jobs.active[job.ID]
+	if info == nil {
+		info = &jobStatus{ID: job.ID, Input: job.Input, Started: time.Now(), job: job}
+	}
+	delete(jobs.active, job.ID)
+	info.State = job.State
+	info.Step = ""
+	info.Status = status
+	info.Target = job.URL
+	info.Finished = time.Now()
+	if err != nil {
+		info.Error = err.Error()
+	}
+	jobs.history = append(jobs.history, info)
+	if len(jobs.history) > historySize {
+		jobs.history = jobs.history[len(jobs.history)-historySize:]
+	}
+}
+
+// listJobs returns copies of the active jobs, oldest first, and of the
+// history, newest first.
+func listJobs() (active []jobStatus, history []jobStatus) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for _, info := range jobs.active {
+		active = append(active, *info)
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Started.Before(active[j].Started) })
+	for i := len(jobs.history) - 1; i >= 0; i-- {
+		history = append(history, *jobs.history[i])
+	}
+	return active, history
+}
+
+// findJob returns the active or finished job with id.
+func findJob(id string) *jobStatus {
+	jobs.Lock()
+	defer jobs.Unlock()
+	if info := jobs.active[id]; info != nil {
+		c := *info
+		return &c
+	}
+	for _, info := range jobs.history {
+		if info.ID == id {
+			c := *info
+			return &c
+		}
+	}
+	return nil
+}
+
+// forgetJob removes a finished job from the history.
+func forgetJob(id string) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for i, info := range jobs.history {
+		if info.ID == id {
+			jobs.history = append(jobs.history[:i], jobs.history[i+1:]...)
+			return
+		}
+	}
+}
+
+var (
+	errJobNotFound  = errors.New("job not found")
+	errJobNotFailed = errors.New("job didn't fail")
+)
+
+// retryJob queues the input of a failed job again.
+func retryJob(cfg Config, id string) error {
+	info := findJob(id)
+	if info == nil {
+		return errJobNotFound
+	}
+	if info.Status != "failed" && info.Status != "cancelled" {
+		return errJobNotFailed
+	}
+	if _, err := os.Stat(info.Input); err != nil {
+		return err
+	}
+	forgetJob(id)
+	failures.forget(info.Input)
+	go enqueue(jobCtx, cfg, info.Input, false)
+	return nil
+}
+
+// discardJob disposes of the originals of a failed job like after an
+// upload and forgets about it.
+func discardJob(id string) error {
+	info := findJob(id)
+	if info == nil {
+		return errJobNotFound
+	}
+	if info.Status != "failed" && info.Status != "cancelled" {
+		return errJobNotFailed
+	}
+	if err := info.job.disposeSources(); err != nil {
+		return err
+	}
+	forgetJob(id)
+	return nil
+}
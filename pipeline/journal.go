@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Job states recorded in the journal.
+const (
+	stateDetected      = "detected"
+	stateOcrRunning    = "ocr-running"
+	stateOcrDone       = "ocr-done"
+	stateUploadQueued  = "upload-queued"
+	stateUploadPending = "upload-pending"
+	stateUploading     = "uploading"
+	stateDone          = "done"
+)
+
+// journalEntry is the persisted progress of a job. Next is the index of the
+// first pipeline step that hasn't completed yet.
+type journalEntry struct {
+	ID      string   `json:"id"`
+	State   string   `json:"state"`
+	Input   string   `json:"input"`
+	Sources []string `json:"sources"`
+	TempDir string   `json:"temp_dir"`
+	Files   []string `json:"files"`
+	Path    string   `json:"path"`
+	URL     string   `json:"url"`
+	Rule    string   `json:"rule,omitempty"`
+	Next    int      `json:"next"`
+	Doc     Document `json:"document"`
+}
+
+// jobJournal keeps one file per unfinished job so interrupted work can be
+// resumed after a crash.
+type jobJournal struct {
+	dir string
+}
+
+var journal *jobJournal
+
+func openJournal(stateDir string) (*jobJournal, error) {
+	dir := filepath.Join(stateDir, "jobs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &jobJournal{dir: dir}, nil
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (j *jobJournal) save(job *Job) {
+	if j == nil {
+		return
+	}
+	e := journalEntry{
+		ID:      job.ID,
+		State:   job.State,
+		Input:   job.Input,
+		Sources: job.Sources,
+		TempDir: job.TempDir,
+		Files:   job.Files,
+		Path:    job.Path,
+		URL:     job.URL,
+		Next:    job.next,
+		Doc:     job.Doc,
+	}
+	if job.Rule != nil {
+		e.Rule = job.Rule.Name
+	}
+	if err := writeJSON(filepath.Join(j.dir, job.ID+".json"), e); err != nil {
+		slog.Error("Unable to write job journal", "error", err)
+	}
+}
+
+func (j *jobJournal) remove(job *Job) {
+	if j == nil {
+		return
+	}
+	os.Remove(filepath.Join(j.dir, job.ID+".json"))
+}
+
+func (j *jobJournal) entries() []journalEntry {
+	files, err := filepath.Glob(filepath.Join(j.dir, "*.json"))
+	if err != nil {
+		return nil
+	}
+	var entries []journalEntry
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(data, &e); err != nil || e.ID == "" {
+			slog.Warn("Removing invalid journal entry", "entry", f)
+			os.Remove(f)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// resumeJobs continues jobs interrupted by a crash. Jobs whose temp files
+// are gone are dropped; their inputs are picked up by the initial sweep.
+func resumeJobs(ctx context.Context, cfg Config) {
+	if journal == nil {
+		return
+	}
+	for _, e := range journal.entries() {
+		resumable := e.Next > 0 && exists(e.TempDir)
+		for _, f := range e.Files {
+			resumable = resumable && exists(f)
+		}
+		// don't upload a second copy if the input is gone already
+		for _, f := range e.Sources {
+			resumable = resumable && (exists(f) || strings.HasPrefix(f, e.TempDir))
+		}
+		if !resumable {
+			slog.Warn("Dropping interrupted job", "job_id", e.ID, "file", e.Input, "state", e.State)
+			os.RemoveAll(e.TempDir)
+			os.Remove(filepath.Join(journal.dir, e.ID+".json"))
+			continue
+		}
+
+		slog.Info("Resuming job", "job_id", e.ID, "file", e.Input, "state", e.State)
+		// keep the initial sweep from starting a second job for the input
+		claim(e.Input)
+		job := newJob(ctx, cfg, e.ID, e.Input, e.TempDir)
+		job.Sources = e.Sources
+		job.Files = e.Files
+		job.Path = e.Path
+		job.URL = e.URL
+		job.Doc = e.Doc
+		job.next = e.Next
+		for i := range job.Cfg.Rules {
+			if job.Cfg.Rules[i].Name == e.Rule && e.Rule != "" {
+				job.Rule = &job.Cfg.Rules[i]
+			}
+		}
+		runJob(job)
+	}
+}
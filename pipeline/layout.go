@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chbmuc/scan2webdav/ocr"
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// layoutExts are the extensions of the SIDECAR_LAYOUT formats, which are
+// also the tesseract configs writing them.
+var layoutExts = map[string]string{
+	"hocr": ".hocr",
+	"alto": ".xml",
+}
+
+// uploadLayout uploads the words of file with their positions on the
+// pages as hOCR or ALTO XML, named like target, for archival systems.
+// Tesseract recognizes the page images once more for it, pages without an
+// image are left out. Like sidecars, failing layouts are only logged.
+func uploadLayout(job *Job, store storage.Storage, file string, target string) {
+	format := job.Cfg.Sidecar.Layout
+	if format == "" || !strings.EqualFold(filepath.Ext(file), ".pdf") {
+		return
+	}
+	if _, ok := store.(*storage.Paperless); ok {
+		return
+	}
+	dir := filepath.Join(job.TempDir, "layout")
+	defer os.RemoveAll(dir)
+	local, err := writeLayout(job, file, dir, format)
+	if err != nil {
+		job.Warn("Unable to create layout", "path", file, "format", format, "error", err)
+		return
+	}
+
+	name := path.Base(target)
+	remote := path.Join(path.Dir(target), strings.TrimSuffix(name, path.Ext(name))+layoutExts[format])
+	if err := retryUpload(job.ctx, job.Cfg, store, local, remote); err != nil {
+		job.Warn("Unable to upload layout", "target", remote, "error", err)
+		return
+	}
+	job.Info("Uploaded layout", "target", remote, "format", format)
+}
+
+// writeLayout runs tesseract over the page images of file in dir and
+// returns the hOCR or ALTO file of all pages.
+func writeLayout(job *Job, file, dir, format string) (string, error) {
+	images, err := pageImages(file, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// tesseract takes a text file listing the images for a multi-page
+	// output
+	var list strings.Builder
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		name := filepath.Join(dir, fmt.Sprintf("page%d.png", i+1))
+		f, err := os.Create(name)
+		if err != nil {
+			return "", err
+		}
+		err = png.Encode(f, img)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintln(&list, name)
+	}
+	if list.Len() == 0 {
+		return "", errors.New("no page images")
+	}
+	pages := filepath.Join(dir, "pages.txt")
+	if err := os.WriteFile(pages, []byte(list.String()), 0600); err != nil {
+		return "", err
+	}
+
+	base := filepath.Join(dir, "layout")
+	args := []string{pages, base}
+	if job.Doc.Language != "" {
+		args = append(args, "-l", job.Doc.Language)
+	}
+	opts := ocrOptions(job.Cfg)
+	if err := ocr.Own(opts, job.TempDir); err != nil {
+		return "", err
+	}
+	cmd, args := ocr.Tesseract(opts, []string{job.TempDir}, append(args, format))
+	out, usage, err := ocr.Run(job.ctx, opts, cmd, args)
+	job.addUsage(usage)
+	if err != nil {
+		job.output(cmd, out)
+		return "", err
+	}
+	local := base + layoutExts[format]
+	if !exists(local) {
+		job.output(cmd, out)
+		return "", errors.New("tesseract wrote no " + format + " output")
+	}
+	return local, nil
+}
@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const llmPrompt = `You extract metadata from scanned documents. Reply with a JSON object
+with the keys "title", "correspondent", "date" (YYYY-MM-DD) and "category".
+Use an empty string for unknown values. Keep the title short.`
+
+// llmClient is kept apart from the upload client: the model may take
+// minutes to answer and none of the SERVER_ and TLS_ options apply to it.
+// Only the job context ends a request.
+var llmClient = &http.Client{}
+
+// the OCR text is truncated to this many characters to keep small local
+// models within their context
+const llmMaxText = 8000
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model          string        `json:"model"`
+	Messages       []chatMessage `json:"messages"`
+	Temperature    float64       `json:"temperature"`
+	ResponseFormat struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// extractMetadata asks an OpenAI compatible endpoint (OpenAI, Ollama, ...)
+// to classify doc and stores the result in doc.
+func extractMetadata(ctx context.Context, cfg Config, doc *Document) error {
+	text := truncate(llmMaxText, doc.Text)
+
+	var creq chatRequest
+	creq.Model = cfg.Llm.Model
+	creq.Messages = []chatMessage{
+		{Role: "system", Content: llmPrompt},
+		{Role: "user", Content: "Filename: " + doc.Filename + "\n\n" + text},
+	}
+	creq.ResponseFormat.Type = "json_object"
+	body, err := json.Marshal(creq)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(cfg.Llm.Url, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Llm.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Llm.Key)
+	}
+
+	res, err := llmClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("llm request failed: %s", res.Status)
+	}
+
+	var cres chatResponse
+	if err := json.NewDecoder(res.Body).Decode(&cres); err != nil {
+		return err
+	}
+	if len(cres.Choices) == 0 {
+		return fmt.Errorf("llm returned no choices")
+	}
+
+	var meta struct {
+		Title         string `json:"title"`
+		Correspondent string `json:"correspondent"`
+		Date          string `json:"date"`
+		Category      string `json:"category"`
+	}
+	content := strings.TrimSpace(cres.Choices[0].Message.Content)
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "```json"), "```")
+	if err := json.Unmarshal([]byte(content), &meta); err != nil {
+		return fmt.Errorf("unable to parse llm reply: %w", err)
+	}
+	doc.Title = meta.Title
+	doc.Correspondent = meta.Correspondent
+	doc.Date = meta.Date
+	doc.Category = meta.Category
+	return nil
+}
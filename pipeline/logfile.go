@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file that is rotated once it grows past maxSize or
+// gets older than maxAge. Rotated files get a timestamp suffix and only the
+// newest backups are kept.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	backups int
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	created time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, backups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open continues an existing log file, so a restart doesn't rotate.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.created = f, info.Size(), info.ModTime()
+	if info.Size() == 0 {
+		r.created = time.Now()
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) ||
+		(r.maxAge > 0 && time.Since(r.created) > r.maxAge)) {
+		if err := r.rotate(); err != nil {
+			// keep logging into the old file rather than losing lines
+			os.Stderr.WriteString("Unable to rotate log file: " + err.Error() + "\n")
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	backup := r.path + "." + time.Now().Format("20060102-150405")
+	if err := os.Rename(r.path, backup); err != nil {
+		return err
+	}
+	r.f.Close()
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes all but the newest backups. The timestamp suffix sorts
+// chronologically.
+func (r *rotatingFile) prune() {
+	if r.backups <= 0 {
+		return
+	}
+	old, err := filepath.Glob(r.path + ".*")
+	if err != nil || len(old) <= r.backups {
+		return
+	}
+	sort.Strings(old)
+	for _, f := range old[:len(old)-r.backups] {
+		os.Remove(f)
+	}
+}
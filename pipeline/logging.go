@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// SetupLogging installs the process logger. With LOG_FILE the log goes to a
+// rotated file as well as stderr, with LOG_SYSLOG to syslog as well.
+// Remaining users of the log package end up in the same handler. On an
+// error the logger is left as it was.
+func SetupLogging(cfg Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q", cfg.Log.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var w io.Writer = os.Stderr
+	if cfg.Log.File != "" {
+		f, err := openRotatingFile(cfg.Log.File, cfg.Log.MaxSize, cfg.Log.MaxAge, cfg.Log.Backups)
+		if err != nil {
+			return fmt.Errorf("unable to open log file: %w", err)
+		}
+		w = io.MultiWriter(os.Stderr, f)
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Log.Format) {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", cfg.Log.Format)
+	}
+	if cfg.Log.Syslog != "" {
+		sw, err := newSyslogWriter(cfg.Log.Syslog, cfg.Log.Facility)
+		if err != nil {
+			return fmt.Errorf("unable to connect to syslog: %w", err)
+		}
+		h = teeHandler{h, &syslogHandler{w: sw, level: level}}
+	}
+	slog.SetDefault(slog.New(jobHandler{h}))
+	return nil
+}
+
+type jobKey struct{}
+
+// jobFrom returns the job a context belongs to, if any.
+func jobFrom(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobKey{}).(*Job)
+	return job
+}
+
+// jobHandler adds the job_id and file of the job in the context to every
+// record, so log lines of concurrent jobs can be told apart.
+type jobHandler struct {
+	slog.Handler
+}
+
+func (h jobHandler) Handle(ctx context.Context, r slog.Record) error {
+	if job := jobFrom(ctx); job != nil {
+		r.AddAttrs(slog.String("job_id", job.ID), slog.String("file", job.Input))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h jobHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return jobHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h jobHandler) WithGroup(name string) slog.Handler {
+	return jobHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// failureMail returns the subject and body of the mail about a failed job.
+func failureMail(e jobEvent) (string, string) {
+	var body strings.Builder
+	fmt.Fprintf(&body, "File:     %s\n", e.Input)
+	fmt.Fprintf(&body, "Job:      %s\n", e.ID)
+	fmt.Fprintf(&body, "Target:   %s\n", e.URL)
+	fmt.Fprintf(&body, "Duration: %s\n", time.Duration(e.Duration*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(&body, "Error:    %s\n", e.Error)
+	if e.Output != "" {
+		body.WriteString("\nCommand output:\n\n")
+		body.WriteString(e.Output)
+		body.WriteString("\n")
+	}
+	return fmt.Sprintf("scan2webdav: %s %s", e.File, e.Status), body.String()
+}
+
+// sendMail mails a plain text message to SMTP_TO. smtp.SendMail switches
+// to TLS with STARTTLS if the server offers it.
+func sendMail(cfg Config, subject, body string) error {
+	mail := cfg.Smtp
+	host, _, err := net.SplitHostPort(mail.Host)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if mail.User != "" {
+		auth = smtp.PlainAuth("", mail.User, mail.Pass, host)
+	}
+	from := mail.From
+	if from == "" {
+		from = "scan2webdav@" + host
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(mail.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(mail.Host, auth, from, mail.To, msg.Bytes())
+}
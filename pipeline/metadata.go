@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func metadataEnabled(cfg Config) bool {
+	m := cfg.Metadata
+	return m.Title != "" || m.Author != "" || m.Subject != "" || m.Keywords != "" || m.Device != ""
+}
+
+// writeMetadata stores the templated metadata of doc in the info dictionary
+// of filename. Keywords default to the tags assigned by the rules.
+func writeMetadata(cfg Config, doc *Document, filename string) error {
+	props := map[string]string{
+		"ScanDate": doc.Time.Format(time.RFC3339),
+	}
+	for key, text := range map[string]string{
+		"Title":        cfg.Metadata.Title,
+		"Author":       cfg.Metadata.Author,
+		"Subject":      cfg.Metadata.Subject,
+		"SourceDevice": cfg.Metadata.Device,
+	} {
+		if text == "" {
+			continue
+		}
+		value, err := expand(text, doc)
+		if err != nil {
+			return err
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			props[key] = value
+		}
+	}
+	if err := api.AddPropertiesFile(filename, "", props, pdfConf()); err != nil {
+		return err
+	}
+
+	keywords := doc.Tags
+	if cfg.Metadata.Keywords != "" {
+		value, err := expand(cfg.Metadata.Keywords, doc)
+		if err != nil {
+			return err
+		}
+		keywords = nil
+		for _, k := range strings.Split(value, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+	}
+	if len(keywords) == 0 {
+		return nil
+	}
+	return api.AddKeywordsFile(filename, "", keywords, pdfConf())
+}
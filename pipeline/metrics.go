@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is written in the Prometheus text format.
+type metric interface {
+	write(w io.Writer)
+}
+
+// counter is a counter family, one value per label value.
+type counter struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help, label string) *counter {
+	c := &counter{name: name, help: help, label: label, values: map[string]float64{}}
+	metrics = append(metrics, c)
+	return c
+}
+
+func (c *counter) add(labelValue string, v float64) {
+	c.mu.Lock()
+	c.values[labelValue] += v
+	c.mu.Unlock()
+}
+
+func (c *counter) inc(labelValue string) {
+	c.add(labelValue, 1)
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.values) == 0 && c.label == "" {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, l := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, labels(c.label, l), c.values[l])
+	}
+}
+
+// gauge reports the values of fn, keyed by label value, at scrape time.
+type gauge struct {
+	name, help, label string
+	fn                func() map[string]float64
+}
+
+func newGauge(name, help, label string, fn func() map[string]float64) *gauge {
+	g := &gauge{name: name, help: help, label: label, fn: fn}
+	metrics = append(metrics, g)
+	return g
+}
+
+func (g *gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	values := g.fn()
+	for _, l := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %g\n", g.name, labels(g.label, l), values[l])
+	}
+}
+
+// histogram records durations in seconds or other values.
+type histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	h := &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	metrics = append(metrics, h)
+	return h
+}
+
+func (h *histogram) observe(d time.Duration) {
+	h.add(d.Seconds())
+}
+
+func (h *histogram) add(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
+
+func labels(name, value string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("{%s=%q}", name, value)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var metrics []metric
+
+var (
+	durationBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+	memoryBuckets   = []float64{64 << 20, 128 << 20, 256 << 20, 512 << 20, 1 << 30, 2 << 30, 4 << 30, 8 << 30}
+
+	filesDetected  = newCounter("scan2webdav_files_detected_total", "Files picked up from the watcher path.", "")
+	ocrResults     = newCounter("scan2webdav_ocr_total", "OCR runs by result.", "result")
+	ocrDuration    = newHistogram("scan2webdav_ocr_duration_seconds", "Duration of the OCR step.", durationBuckets)
+	ocrCPU         = newHistogram("scan2webdav_ocr_cpu_seconds", "CPU time of the OCR subprocesses.", durationBuckets)
+	ocrMemory      = newHistogram("scan2webdav_ocr_max_rss_bytes", "Peak memory of the OCR subprocesses.", memoryBuckets)
+	uploadDuration = newHistogram("scan2webdav_upload_duration_seconds", "Duration of the upload step.", durationBuckets)
+	uploadedBytes  = newCounter("scan2webdav_uploaded_bytes_total", "Bytes uploaded to the server.", "")
+	uploadedPages  = newCounter("scan2webdav_uploaded_pages_total", "Pages of the documents uploaded to the server.", "")
+	uploadRetries  = newCounter("scan2webdav_upload_retries_total", "Upload attempts that were retried.", "")
+	uploadErrors   = newCounter("scan2webdav_upload_errors_total", "Failed upload attempts by error class.", "class")
+	jobResults     = newCounter("scan2webdav_jobs_total", "Finished jobs by result.", "result")
+
+	_ = newGauge("scan2webdav_queue_depth", "Items waiting in the queues.", "queue", func() map[string]float64 {
+		depth := map[string]float64{}
+		if queue != nil {
+			depth["jobs"] = float64(queue.len())
+		}
+		if uploads != nil {
+			depth["uploads"] = float64(uploads.len())
+		}
+		return depth
+	})
+	_ = newGauge("scan2webdav_usage_pages", "Pages uploaded today and this month, from the job history.", "period", func() map[string]float64 {
+		values := map[string]float64{}
+		for by, u := range currentUsage() {
+			values[by] = float64(u.Pages)
+		}
+		return values
+	})
+	_ = newGauge("scan2webdav_usage_bytes", "Bytes uploaded today and this month, from the job history.", "period", func() map[string]float64 {
+		values := map[string]float64{}
+		for by, u := range currentUsage() {
+			values[by] = float64(u.Bytes)
+		}
+		return values
+	})
+)
+
+// observeStep records the metrics of a finished pipeline step.
+func observeStep(name string, d time.Duration, err error) {
+	switch name {
+	case "ocr":
+		ocrDuration.observe(d)
+		if err != nil {
+			ocrResults.inc("failure")
+		} else {
+			ocrResults.inc("success")
+		}
+	case "upload":
+		if err == nil {
+			uploadDuration.observe(d)
+		}
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	io.WriteString(w, b.String())
+}
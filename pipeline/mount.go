@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/watcher"
+)
+
+// mountCheck is the interval for checking that the watcher path is still
+// the directory that is watched.
+const mountCheck = 30 * time.Second
+
+// waitForDir waits up to WATCHER_WAIT for the watcher path to appear, as
+// bind mounts and network shares may show up after the daemon started.
+func waitForDir(ctx context.Context, cfg Config) (os.FileInfo, error) {
+	deadline := time.Now().Add(cfg.Watcher.Wait)
+	logged := false
+	for {
+		fi, err := os.Stat(cfg.Watcher.Path)
+		if err == nil && !fi.IsDir() {
+			return nil, fmt.Errorf("watcher path %s is not a directory", cfg.Watcher.Path)
+		}
+		if err == nil {
+			return fi, nil
+		}
+		if !os.IsNotExist(err) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("unable to access watcher path: %w", err)
+		}
+		if !logged {
+			slog.Warn("Waiting for the watcher path to appear", "path", cfg.Watcher.Path, "wait", cfg.Watcher.Wait)
+			logged = true
+		}
+		if !sleep(ctx, time.Second) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// rewatch locks and watches the watcher path again after it was replaced.
+func rewatch(ctx context.Context, cfg Config) (*watcher.Watcher, os.FileInfo, error) {
+	fi, err := waitForDir(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	instanceLock.Close()
+	if err := lockWatchPath(cfg.Watcher.Path); err != nil {
+		return nil, nil, fmt.Errorf("unable to lock watcher path: %w", err)
+	}
+	w, err := watcher.Watch(cfg.Watcher.Path, watchRecursive(cfg), cfg.Watcher.Events)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to watch %s: %w", cfg.Watcher.Path, err)
+	}
+	return w, fi, nil
+}
+
+// dirReplaced tells if the watcher path is no longer the directory
+// watched, e.g. because a mount appeared on top of it or it was
+// unmounted. Events of the new directory wouldn't be seen otherwise.
+func dirReplaced(cfg Config, watched os.FileInfo) bool {
+	fi, err := os.Stat(cfg.Watcher.Path)
+	return err != nil || !os.SameFile(fi, watched)
+}
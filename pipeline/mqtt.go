@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// broker is the MQTT connection, nil if MQTT_BROKER isn't set.
+var broker mqtt.Client
+
+// mqttState is published retained to <topic>/state.
+type mqttState struct {
+	Watching bool   `json:"watching"`
+	Paused   bool   `json:"paused"`
+	Jobs     int    `json:"jobs"`
+	Uploads  int    `json:"uploads"`
+	Last     string `json:"last,omitempty"` // file of the last finished job
+	Status   string `json:"last_status,omitempty"`
+}
+
+var lastEvent struct {
+	file, status string
+}
+
+// setupMQTT connects to the broker. The availability in <topic>/status is
+// set to offline by the broker's last will if the connection drops.
+// Job events go to <topic>/event, the pipeline state to <topic>/state.
+func setupMQTT(cfg Config) {
+	if cfg.Mqtt.Broker == "" {
+		return
+	}
+	topic := cfg.Mqtt.Topic
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Mqtt.Broker).
+		SetClientID(cfg.Mqtt.ClientId).
+		SetUsername(cfg.Mqtt.User).
+		SetPassword(cfg.Mqtt.Pass).
+		SetWill(topic+"/status", "offline", 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			slog.Info("Connected to MQTT broker", "broker", cfg.Mqtt.Broker)
+			c.Publish(topic+"/status", 1, true, "online")
+			if cfg.Mqtt.Discovery != "" {
+				publishDiscovery(c, cfg)
+			}
+			publishState(cfg)
+		}).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker", "error", err)
+		})
+	broker = mqtt.NewClient(opts)
+	// with connect retry the client keeps trying in the background
+	broker.Connect()
+
+	go func() {
+		for range time.Tick(30 * time.Second) {
+			publishState(cfg)
+		}
+	}()
+}
+
+// closeMQTT marks the daemon offline and disconnects.
+func closeMQTT(cfg Config) {
+	if broker == nil {
+		return
+	}
+	broker.Publish(cfg.Mqtt.Topic+"/status", 1, true, "offline").WaitTimeout(time.Second)
+	broker.Disconnect(1000)
+	broker = nil
+}
+
+func publishState(cfg Config) {
+	if broker == nil || !broker.IsConnected() {
+		return
+	}
+	state := mqttState{}
+	health.Lock()
+	state.Watching = health.watching
+	state.Last, state.Status = lastEvent.file, lastEvent.status
+	health.Unlock()
+	if queue != nil {
+		state.Paused = queue.isPaused()
+		state.Jobs = queue.len()
+	}
+	if uploads != nil {
+		state.Uploads = uploads.len()
+	}
+	publishJSON(cfg.Mqtt.Topic+"/state", true, state)
+}
+
+// publishJob sends a finished job to the broker.
+func publishJob(cfg Config, e jobEvent) {
+	if broker == nil {
+		return
+	}
+	health.Lock()
+	lastEvent.file, lastEvent.status = e.File, e.Status
+	health.Unlock()
+	publishJSON(cfg.Mqtt.Topic+"/event", false, e)
+	publishState(cfg)
+}
+
+func publishJSON(topic string, retained bool, v any) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		slog.Warn("Unable to encode MQTT message", "topic", topic, "error", err)
+		return
+	}
+	broker.Publish(topic, 1, retained, payload)
+}
+
+// publishDiscovery announces sensors for Home Assistant's MQTT discovery
+// below the discovery prefix, usually homeassistant.
+func publishDiscovery(c mqtt.Client, cfg Config) {
+	topic := cfg.Mqtt.Topic
+	id := cfg.Mqtt.ClientId
+	device := map[string]any{
+		"identifiers":  []string{id},
+		"name":         "scan2webdav",
+		"manufacturer": "scan2webdav",
+	}
+	sensors := []struct {
+		component, object string
+		config            map[string]any
+	}{
+		{"binary_sensor", "watching", map[string]any{
+			"name":           "Watching",
+			"value_template": "{{ 'ON' if value_json.watching else 'OFF' }}",
+			"device_class":   "running",
+		}},
+		{"sensor", "queue", map[string]any{
+			"name":           "Queued documents",
+			"value_template": "{{ value_json.jobs + value_json.uploads }}",
+		}},
+		{"sensor", "last_document", map[string]any{
+			"name":                  "Last document",
+			"value_template":        "{{ value_json.last }}",
+			"json_attributes_topic": topic + "/event",
+		}},
+		{"sensor", "last_status", map[string]any{
+			"name":           "Last status",
+			"value_template": "{{ value_json.last_status }}",
+		}},
+	}
+	for _, s := range sensors {
+		s.config["unique_id"] = id + "_" + s.object
+		s.config["state_topic"] = topic + "/state"
+		s.config["availability_topic"] = topic + "/status"
+		s.config["device"] = device
+		payload, err := json.Marshal(s.config)
+		if err != nil {
+			continue
+		}
+		c.Publish(cfg.Mqtt.Discovery+"/"+s.component+"/"+id+"/"+s.object+"/config", 1, true, payload)
+	}
+}
+
+// defaultClientId is unique per host, so discovered entities survive
+// restarts.
+func defaultClientId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "scan2webdav"
+	}
+	return "scan2webdav-" + host
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// umlauts are transliterated by hand, the generic way would drop the
+// diaeresis: ä → a instead of ae.
+var umlauts = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "Ä", "Ae", "Ö", "Oe", "Ü", "Ue", "ß", "ss")
+
+// sanitize cleans a single file or folder name for the remote side: the
+// name is normalized to NFC, invalid UTF-8, control characters and the
+// characters in NAMES_INVALID are replaced by NAMES_REPLACE and, with
+// NAMES_TRANSLITERATE, the name is reduced to ASCII.
+func sanitize(cfg Config, name string) string {
+	// phone apps and macOS hand out decomposed names, ä as a and a
+	// combining diaeresis, which wouldn't match the composed name of the
+	// same file on the server
+	name = norm.NFC.String(strings.ToValidUTF8(name, cfg.Names.Replace))
+	if cfg.Names.Transliterate {
+		name = umlauts.Replace(name)
+		// decompose and drop the accents: é → e
+		stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), name)
+		if err == nil {
+			name = stripped
+		}
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsControl(r), strings.ContainsRune(cfg.Names.Invalid, r):
+			b.WriteString(cfg.Names.Replace)
+		case cfg.Names.Transliterate && r > unicode.MaxASCII:
+			b.WriteString(cfg.Names.Replace)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	// Windows shares don't allow trailing dots and spaces
+	name = strings.TrimRight(strings.TrimSpace(b.String()), ". ")
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// sanitizePath sanitizes every segment of a slash separated path.
+func sanitizePath(cfg Config, p string) string {
+	var segments []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segments = append(segments, sanitize(cfg, s))
+		}
+	}
+	return strings.Join(segments, "/")
+}
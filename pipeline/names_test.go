@@ -0,0 +1,38 @@
+package pipeline
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name          string
+		in            string
+		transliterate bool
+		want          string
+	}{
+		{"plain", "Rechnung 2024.pdf", false, "Rechnung 2024.pdf"},
+		{"NFD umlaut", "Müller.pdf", false, "Müller.pdf"},
+		{"NFC umlaut", "Müller.pdf", false, "Müller.pdf"},
+		{"NFD umlaut transliterated", "Müller.pdf", true, "Mueller.pdf"},
+		{"accent transliterated", "Café.pdf", true, "Cafe.pdf"},
+		{"emoji", "Scan 📄.pdf", false, "Scan 📄.pdf"},
+		{"emoji transliterated", "Scan 📄.pdf", true, "Scan _.pdf"},
+		{"CJK", "請求書.pdf", false, "請求書.pdf"},
+		{"CJK transliterated", "請求書.pdf", true, "___.pdf"},
+		{"hash", "Rechnung #12.pdf", false, "Rechnung #12.pdf"},
+		{"question mark", "Was?.pdf", false, "Was_.pdf"},
+		{"invalid UTF-8", "Scan\xff\xfe.pdf", false, "Scan_.pdf"},
+		{"control character", "Scan\t1.pdf", false, "Scan_1.pdf"},
+		{"trailing dots and spaces", "Scan. . ", false, "Scan"},
+		{"dot dot", "..", false, "_"},
+		{"empty", "", false, "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Names.Transliterate = tt.transliterate
+			if got := sanitize(cfg, tt.in); got != tt.want {
+				t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// shareUpload creates a share link of the upload target with
+// NEXTCLOUD_SHARE. Failures are only logged, the upload succeeded.
+func shareUpload(job *Job, store storage.Storage, target string) {
+	if !job.Cfg.Nextcloud.Share {
+		return
+	}
+	s, ok := store.(*storage.WebDAV)
+	if !ok {
+		job.Warn("Share links need a Nextcloud upload target", "path", target)
+		return
+	}
+	var expire time.Time
+	if d := job.Cfg.Nextcloud.ShareExpire; d > 0 {
+		expire = inZone(time.Now().Add(d))
+	}
+	link, err := s.Share(job.ctx, target, job.Cfg.Nextcloud.SharePassword, expire)
+	if err != nil {
+		job.Warn("Unable to create share link", "path", target, "error", err)
+		return
+	}
+	job.Info("Created share link", "path", target, "url", link)
+	job.shares = append(job.shares, link)
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// outputExcerpt is the number of bytes of command output sent along with
+// notifications.
+const outputExcerpt = 2000
+
+// jobEvent is the JSON payload describing a finished job.
+type jobEvent struct {
+	ID       string   `json:"id"`
+	Status   string   `json:"status"`
+	Step     string   `json:"step,omitempty"` // starting, while running
+	File     string   `json:"file"`
+	Input    string   `json:"input"`
+	URL      string   `json:"url"`
+	Uploads  []string `json:"uploads,omitempty"`
+	Shares   []string `json:"shares,omitempty"`
+	Duration float64  `json:"duration"` // seconds
+	Pages    int      `json:"pages"`
+	Bytes    int64    `json:"bytes"` // uploaded
+	Error    string   `json:"error,omitempty"`
+	Output   string   `json:"output,omitempty"`
+	Document Document `json:"document"`
+}
+
+func (job *Job) event(status string, err error) jobEvent {
+	e := jobEvent{
+		ID:       job.ID,
+		Status:   status,
+		File:     filepath.Base(job.Input),
+		Input:    job.Input,
+		URL:      job.URL,
+		Uploads:  job.uploaded,
+		Shares:   job.shares,
+		Duration: time.Since(job.started).Seconds(),
+		Pages:    job.pages,
+		Bytes:    job.bytes,
+		Document: job.Doc,
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.Output = job.lastOutput
+	}
+	return e
+}
+
+// notifyJob reports a finished job to the configured notification targets.
+// Failing notifications are only logged.
+func notifyJob(job *Job, status string, err error) {
+	cfg := job.Cfg
+	e := job.event(status, err)
+	publishEvent(e)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(job.ctx), 10*time.Second)
+	defer cancel()
+
+	if cfg.Notify.Webhook != "" {
+		if err := postWebhook(ctx, cfg.Notify.Webhook, e); err != nil {
+			job.Warn("Unable to call webhook", "url", cfg.Notify.Webhook, "error", err)
+		}
+	}
+	pushJob(ctx, job, e)
+	publishJob(cfg, e)
+	// jobs only end up failed after all retries, so every failure is final
+	if cfg.Smtp.Host != "" && status == "failed" {
+		subject, body := failureMail(e)
+		if err := sendMail(cfg, subject, body); err != nil {
+			job.Warn("Unable to send mail", "host", cfg.Smtp.Host, "error", err)
+		}
+	}
+}
+
+// broadcast sends a message that isn't about a single job, like a report,
+// to every configured target regardless of their event settings. v is
+// posted to the webhook and published to <topic>/<kind>.
+func broadcast(cfg Config, kind string, n pushNote, v any) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if cfg.Notify.Webhook != "" {
+		if err := postWebhook(ctx, cfg.Notify.Webhook, v); err != nil {
+			slog.Warn("Unable to call webhook", "url", cfg.Notify.Webhook, "error", err)
+		}
+	}
+	sendPush(ctx, cfg, n, func(string) bool { return true })
+	if broker != nil {
+		publishJSON(cfg.Mqtt.Topic+"/"+kind, true, v)
+	}
+	if cfg.Smtp.Host != "" {
+		if err := sendMail(cfg, n.Title, n.Text); err != nil {
+			slog.Warn("Unable to send mail", "host", cfg.Smtp.Host, "error", err)
+		}
+	}
+}
+
+func postWebhook(ctx context.Context, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &storage.StatusError{Op: "webhook", Path: url, Status: res.Status, Code: res.StatusCode}
+	}
+	return nil
+}
+
+// excerpt returns the end of s, where errors usually show up.
+func excerpt(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	// start on a whole character
+	i := len(s) - max
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return "…" + s[i:]
+}
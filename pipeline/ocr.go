@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/ocr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ocrOptions returns the OCR settings of cfg.
+func ocrOptions(cfg Config) ocr.Options {
+	return ocr.Options{
+		Exec:     cfg.Ocr.Exec,
+		Engine:   cfg.Ocr.Engine,
+		Image:    cfg.Ocr.Image,
+		Nice:     cfg.Ocr.Nice,
+		IoClass:  cfg.Ocr.IoClass,
+		IoPrio:   cfg.Ocr.IoPrio,
+		CpuQuota: cfg.Ocr.CpuQuota,
+		User:     cfg.Ocr.User,
+		Env:      cfg.Ocr.Env,
+	}
+}
+
+// ocrSlots bounds the OCR processes running at once over all jobs to
+// OCR_PROCESSES, nil for no limit.
+var ocrSlots chan struct{}
+
+func runOcr(job *Job, args []string) (err error) {
+	cfg := job.Cfg
+	opts := ocrOptions(cfg)
+	if ocrSlots != nil {
+		select {
+		case ocrSlots <- struct{}{}:
+			defer func() { <-ocrSlots }()
+		case <-job.ctx.Done():
+			return job.ctx.Err()
+		}
+	}
+	if err := ocr.Own(opts, job.TempDir); err != nil {
+		return fmt.Errorf("handing the temp directory to the OCR user: %w", err)
+	}
+	name, args := ocr.Command(opts, []string{job.TempDir, cfg.Watcher.Path}, args)
+	job.Debug("Executing", "command", name, "args", args)
+	// not job.startSpan, page ranges run in parallel on the same job
+	ctx, span := tracer.Start(job.ctx, "exec "+filepath.Base(name), trace.WithAttributes(attribute.StringSlice("args", args)))
+	defer func() { endSpan(span, err) }()
+	out, usage, err := ocr.Run(ctx, opts, name, args)
+	job.ocrMu.Lock()
+	job.output(name, out)
+	job.addUsage(usage)
+	job.ocrMu.Unlock()
+	return err
+}
+
+// addUsage adds the resources of an OCR subprocess to the job and the
+// metrics.
+func (job *Job) addUsage(u ocr.Usage) {
+	job.usage.Wall += u.Wall
+	job.usage.CPU += u.CPU
+	job.usage.MaxRSS = max(job.usage.MaxRSS, u.MaxRSS)
+	ocrCPU.observe(u.CPU)
+	if u.MaxRSS > 0 {
+		ocrMemory.add(float64(u.MaxRSS))
+	}
+	job.Info("OCR resources", "wall", u.Wall.Round(time.Millisecond), "cpu", u.CPU.Round(time.Millisecond), "max_rss_mib", u.MaxRSS>>20)
+}
+
+// ocrOutputType returns the --output-type of OCR_OUTPUT. Text only
+// output is a sidecar file without a PDF.
+func ocrOutputType(output string) string {
+	if output == "text" {
+		return "none"
+	}
+	return output
+}
+
+// setOutputType replaces the --output-type in args.
+func setOutputType(args []string, outputType string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--output-type":
+			i++
+		case strings.HasPrefix(a, "--output-type="):
+		default:
+			out = append(out, a)
+		}
+	}
+	return append(out, "--output-type", outputType)
+}
+
+// validateOcrOutput rejects steps that need a PDF after the ocr step if
+// only text comes out of it.
+func validateOcrOutput(cfg Config, steps []string) error {
+	i := slices.Index(steps, "ocr")
+	if cfg.Ocr.Output != "text" || i < 0 {
+		return nil
+	}
+	for _, name := range steps[i+1:] {
+		switch {
+		case name == "deskew", name == "optimize",
+			name == "split" && (cfg.Split.Pages > 0 || cfg.Split.Size > 0),
+			name == "stamp" && (cfg.Stamp.Text != "" || cfg.Stamp.Image != ""),
+			name == "encrypt" && (cfg.Encrypt.User != "" || cfg.Encrypt.Owner != ""),
+			name == "metadata" && metadataEnabled(cfg):
+			return fmt.Errorf("the %s step needs a PDF, OCR_OUTPUT is text", name)
+		}
+	}
+	return nil
+}
+
+// checkOcrOutput makes sure the installed ocrmypdf supports OCR_OUTPUT.
+// Only a missing output type is an error, the OCR engine may just not be
+// available yet, e.g. while its image is pulled.
+func checkOcrOutput(ctx context.Context, cfg Config) error {
+	if cfg.Ocr.Output == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	opts := ocrOptions(cfg)
+	version, err := ocr.Version(ctx, opts)
+	if err != nil {
+		slog.Warn("Unable to detect the ocrmypdf version", "error", err)
+		return nil
+	}
+	types, err := ocr.OutputTypes(ctx, opts)
+	if err != nil {
+		slog.Warn("Unable to detect the output types of ocrmypdf", "version", version, "error", err)
+		return nil
+	}
+	if t := ocrOutputType(cfg.Ocr.Output); !slices.Contains(types, t) {
+		return fmt.Errorf("OCR_OUTPUT %s needs --output-type %s, which ocrmypdf %s doesn't support", cfg.Ocr.Output, t, version)
+	}
+	slog.Debug("OCR output type supported", "output", cfg.Ocr.Output, "version", version)
+	return nil
+}
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// disposeSources deals with the originals of a finished job according to
+// ORIGINALS_MODE: they are deleted, kept in place, moved below
+// ORIGINALS_PATH into date folders, so OCR can be re-run later, or moved to
+// the trash until ORIGINALS_RETENTION is over. An original that can't be
+// disposed of, e.g. locked on a share, is remembered and tried again
+// every ORIGINALS_RETRY instead of failing the stored document.
+func (job *Job) disposeSources() error {
+	cfg := job.Cfg.Originals
+	if cfg.Mode == "keep" {
+		return nil
+	}
+	job.disposal = cfg.Mode
+	var dir string
+	if cfg.Mode == "move" {
+		sub, err := expand(cfg.Dirs, &job.Doc)
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(cfg.Path, filepath.Clean("/"+sub))
+	}
+	for _, f := range job.Sources {
+		if !exists(f) {
+			job.Warn("Input disappeared, nothing to dispose", "path", f)
+			continue
+		}
+		job.Info("Disposing of input", "path", f, "mode", cfg.Mode)
+		err := disposeFile(job.Cfg, cfg.Mode, f, dir)
+		if err == nil {
+			continue
+		}
+		if !disposals.add(f, cfg.Mode, dir, err) {
+			return err
+		}
+		job.Warn("Unable to dispose of input, trying again later", "path", f, "error", err, "retry_in", cfg.Retry)
+	}
+	return nil
+}
+
+// disposeFile deletes f or moves it to the trash or into dir.
+func disposeFile(cfg Config, mode string, f string, dir string) error {
+	switch mode {
+	case "trash":
+		return trash(cfg.Originals.Trash, f)
+	case "move":
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		return moveFile(f, freeName(filepath.Join(dir, filepath.Base(f))))
+	}
+	return os.Remove(f)
+}
+
+// freeName appends -1, -2, ... to name until no such file exists.
+func freeName(name string) string {
+	ext := filepath.Ext(name)
+	candidate := name
+	for i := 1; exists(candidate); i++ {
+		candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	return candidate
+}
+
+// moveFile renames src to dst, copying it if they are on different devices.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// isOriginal reports whether path was moved to ORIGINALS_PATH or the trash,
+// which may be inside the watched directory.
+func isOriginal(cfg Config, path string) bool {
+	switch cfg.Originals.Mode {
+	case "move":
+		return within(cfg.Originals.Path, path)
+	case "trash":
+		return within(cfg.Originals.Trash, path)
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// intake holds the files detected while the pipeline is paused, they are
+// left untouched until it is resumed.
+var intake struct {
+	sync.Mutex
+	paused bool
+	since  time.Time
+	held   map[string]func() // queues the file
+}
+
+// pipelineStatus is the answer of the pipeline API.
+type pipelineStatus struct {
+	Paused bool           `json:"paused"`
+	Since  *time.Time     `json:"since,omitempty"`
+	Held   int            `json:"held"` // files detected while paused
+	Queue  map[string]int `json:"queue"`
+}
+
+// Pause stops taking in new files and starting queued jobs, e.g. before
+// maintenance of the server. Running jobs go on and the watcher keeps
+// watching, the files it reports in the meantime are taken in by Resume.
+func Pause() {
+	intake.Lock()
+	if !intake.paused {
+		intake.paused, intake.since = true, time.Now()
+		slog.Info("Pipeline paused")
+	}
+	intake.Unlock()
+	forQueues((*jobQueue).pause)
+}
+
+// Resume continues a paused pipeline.
+func Resume() {
+	intake.Lock()
+	paused, held := intake.paused, intake.held
+	intake.paused, intake.since, intake.held = false, time.Time{}, nil
+	intake.Unlock()
+	forQueues((*jobQueue).resume)
+	if paused {
+		slog.Info("Pipeline resumed", "held", len(held))
+	}
+	for _, take := range held {
+		go take()
+	}
+}
+
+// hold keeps the file at path for Resume if the pipeline is paused.
+func hold(path string, take func()) bool {
+	intake.Lock()
+	defer intake.Unlock()
+	if !intake.paused {
+		return false
+	}
+	if intake.held == nil {
+		intake.held = map[string]func(){}
+	}
+	intake.held[path] = take
+	return true
+}
+
+func currentPipeline() pipelineStatus {
+	intake.Lock()
+	s := pipelineStatus{Paused: intake.paused, Held: len(intake.held), Queue: map[string]int{}}
+	if intake.paused {
+		since := intake.since
+		s.Since = &since
+	}
+	intake.Unlock()
+	if queue != nil {
+		s.Paused = s.Paused || queue.isPaused()
+		s.Queue["jobs"] = queue.len()
+	}
+	if uploads != nil {
+		s.Queue["uploads"] = uploads.len()
+	}
+	return s
+}
+
+func pipelineHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, currentPipeline())
+}
+
+// pauseCommand pauses or, as resume, resumes the pipeline of the running
+// daemon.
+func pauseCommand(cfg Config, name string, args []string) int {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var s pipelineStatus
+	if err := apiDo(cfg, http.MethodPost, *addr+"/api/pipeline/"+name, &s); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(s)
+	}
+	if s.Paused {
+		fmt.Print("Paused")
+		if s.Since != nil {
+			fmt.Print(" since ", s.Since.Format(time.DateTime))
+		}
+		fmt.Printf(", %d files held\n", s.Held)
+	} else {
+		fmt.Println("Running")
+	}
+	fmt.Printf("Queued: %d jobs, %d uploads\n", s.Queue["jobs"], s.Queue["uploads"])
+	return 0
+}
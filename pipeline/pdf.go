@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func init() {
+	// don't create a pdfcpu config dir in the home of the service user
+	api.DisableConfigDir()
+}
+
+// pdfConf returns a pdfcpu configuration that tolerates the slightly
+// malformed PDFs many scanners produce.
+func pdfConf() *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+// hasText reports whether a page of the PDF file uses fonts. Scans without
+// OCR only have images, so this tells whether it still needs a text layer.
+func hasText(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	ctx, err := api.ReadContext(f, pdfConf())
+	if err != nil {
+		return false, err
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		return false, err
+	}
+	for i := 1; i <= ctx.PageCount; i++ {
+		_, _, attrs, err := ctx.PageDict(i, true)
+		if err != nil {
+			return false, err
+		}
+		if attrs == nil || attrs.Resources == nil {
+			continue
+		}
+		if fonts, found := attrs.Resources.Find("Font"); found {
+			if d, err := ctx.DereferenceDict(fonts); err == nil && len(d) > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// count adds the size and pages of an uploaded file to the job totals.
+// Files that aren't PDFs count as one page.
+func (job *Job) count(file string) {
+	if fi, err := os.Stat(file); err == nil {
+		job.bytes += fi.Size()
+	}
+	pages := pageCount(file)
+	job.pages += pages
+	uploadedPages.add("", float64(pages))
+}
+
+// pageCount returns the pages of a PDF, one for other files.
+func pageCount(file string) int {
+	if strings.EqualFold(filepath.Ext(file), ".pdf") {
+		if n, err := api.PageCountFile(file); err == nil {
+			return n
+		}
+	}
+	return 1
+}
@@ -0,0 +1,279 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/ocr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Job is a single input file travelling through the pipeline.
+type Job struct {
+	ID      string
+	State   string
+	Cfg     Config // configuration with the matching profile applied
+	Input   string
+	Sources []string // originals removed after the upload
+	TempDir string
+	Files   []string // current working files, more than one after a split
+	Doc     Document
+	Rule    *Rule
+	Ticket  *Ticket
+	Path    string // destination collection relative to the server URL
+	URL     string // destination collection
+
+	LogFile string // per-job log, empty if disabled
+
+	ctx        context.Context
+	span       trace.Span // root span of the job
+	imageHash  uint64
+	logFile    *os.File
+	logs       *tailBuffer
+	lastOutput string    // excerpt of the last command output
+	started    time.Time // when the job was created
+	uploaded   []string  // URLs of the uploaded files
+	remotes    []string  // uploaded remote paths
+	shares     []string  // share links of the uploads
+	disposal   string    // what happened to the originals
+	pages      int       // pages uploaded
+	bytes      int64     // bytes uploaded
+	timings    map[string]time.Duration
+	usage      ocr.Usage // of the OCR subprocesses
+	logger     *slog.Logger
+	next       int  // index of the next pipeline step
+	uploading  bool // running on the upload workers
+	claimed    bool // hash added to the shared registry
+	// guards the output and usage of page ranges OCRed in parallel
+	ocrMu sync.Mutex
+}
+
+// errSkip stops the pipeline without failing the job.
+var errSkip = errors.New("skipped")
+
+// errVanished stops a job whose input was deleted or renamed while it was
+// processed. A renamed file is picked up again by the watcher.
+var errVanished = errors.New("input disappeared")
+
+// errHandoff stops the pipeline in front of the upload step so the job can
+// continue on the upload workers.
+var errHandoff = errors.New("handed off to the upload workers")
+
+type step func(job *Job) error
+
+var steps = map[string]step{
+	"clamav":   clamavStep,
+	"dedupe":   dedupeStep,
+	"decrypt":  decryptStep,
+	"heic":     heicStep,
+	"convert":  convertStep,
+	"barcode":  barcodeStep,
+	"deskew":   deskewStep,
+	"resample": resampleStep,
+	"crop":     cropStep,
+	"spread":   spreadStep,
+	"split":    splitStep,
+	"ocr":      ocrStep,
+	"classify": classifyStep,
+	"metadata": metadataStep,
+	"stamp":    stampStep,
+	"optimize": optimizeStep,
+	"rename":   renameStep,
+	"encrypt":  encryptStep,
+	"seal":     sealStep,
+	"upload":   uploadStep,
+	"receipt":  receiptStep,
+	"dispatch": dispatchStep,
+	"archive":  archiveStep,
+}
+
+var defaultSteps = []string{"clamav", "heic", "dedupe", "decrypt", "resample", "crop", "spread", "convert", "barcode", "ocr", "classify", "stamp", "metadata", "rename", "archive", "split", "encrypt", "seal", "upload", "receipt"}
+
+func validateSteps(names []string) error {
+	for _, name := range names {
+		if _, ok := steps[name]; !ok {
+			return fmt.Errorf("unknown pipeline step %q", name)
+		}
+	}
+	return nil
+}
+
+// newJob creates a job below ctx, the daemon context.
+func newJob(ctx context.Context, cfg Config, id string, inFile string, tempDir string) *Job {
+	cfg = cfg.forFile(inFile)
+	job := &Job{
+		ID:      id,
+		State:   stateDetected,
+		Input:   inFile,
+		Sources: []string{inFile},
+		TempDir: tempDir,
+		Files:   []string{inFile},
+		Doc:     Document{Filename: filepath.Base(inFile), Time: inZone(time.Now())},
+		started: time.Now(),
+	}
+	if p := profileFor(cfg, inFile); p != nil {
+		job.Doc.Match = p.match(job.Doc.Filename)
+		if p.StripPrefix {
+			job.Doc.Filename = strings.TrimPrefix(job.Doc.Filename, p.Prefix)
+		}
+	}
+	if t := loadTicket(inFile); t != nil {
+		t.apply(&cfg)
+		job.Ticket = t
+		job.Sources = append(job.Sources, t.file)
+	}
+	if cfg.Dispatch.Role == "watcher" {
+		// the workers run the pipeline
+		cfg.Pipeline.Steps = []string{"dispatch"}
+	}
+	job.Cfg = cfg
+	job.URL = cfg.Server.Url
+	job.ctx, job.span = tracer.Start(context.WithValue(ctx, jobKey{}, job), "job",
+		trace.WithAttributes(attribute.String("job.id", job.ID), attribute.String("file", inFile)))
+	trackTempDir(tempDir)
+	return job
+}
+
+func (job *Job) run() error {
+	hooks := job.Cfg.Hooks
+	for job.next < len(job.Cfg.Pipeline.Steps) {
+		if err := job.ctx.Err(); err != nil {
+			return err
+		}
+		name := job.Cfg.Pipeline.Steps[job.next]
+		switch name {
+		case "ocr":
+			if err := runHook("pre-ocr", hooks.PreOcr, job); err != nil {
+				return err
+			}
+			job.State = stateOcrRunning
+		case "upload":
+			if uploads != nil && !job.uploading {
+				job.State = stateUploadQueued
+				job.checkpoint()
+				return errHandoff
+			}
+			if err := waitForThrottle(job); err != nil {
+				return err
+			}
+			job.State = stateUploading
+		}
+		job.checkpoint()
+
+		job.Debug("Running step", "step", name)
+		job.progress(name)
+		start := time.Now()
+		end := job.startSpan("step "+name, attribute.String("step", name))
+		restore := job.withTimeout(job.Cfg.Pipeline.Timeout)
+		err := steps[name](job)
+		restore()
+		if errors.Is(err, errSkip) {
+			end(nil)
+		} else {
+			end(err)
+		}
+		job.timing(name, start)
+		observeStep(name, time.Since(start), err)
+		if errors.Is(err, errSkip) {
+			job.Info("Skipping remaining steps", "step", name)
+			return nil
+		} else if err != nil && job.vanished() {
+			return errVanished
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if name == "ocr" {
+			job.State = stateOcrDone
+		}
+		job.next++
+		job.checkpoint()
+
+		switch name {
+		case "ocr":
+			if err := runHook("post-ocr", hooks.PostOcr, job); err != nil {
+				return err
+			}
+		case "upload":
+			// the document is stored already, so don't fail the job
+			if err := runHook("post-upload", hooks.PostUpload, job); err != nil {
+				job.Warn("Hook failed", "error", err)
+			}
+		}
+	}
+	job.State = stateDone
+	rememberHash(job)
+	return nil
+}
+
+// vanished tells if an original of job is gone before it was disposed of.
+func (job *Job) vanished() bool {
+	if job.disposal != "" {
+		return false
+	}
+	for _, f := range job.Sources {
+		if !strings.HasPrefix(f, job.TempDir) && !exists(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTimeout limits the context of job to d until the returned function
+// is called. Zero means no limit.
+func (job *Job) withTimeout(d time.Duration) func() {
+	if d <= 0 {
+		return func() {}
+	}
+	parent := job.ctx
+	ctx, cancel := context.WithTimeout(parent, d)
+	job.ctx = ctx
+	return func() {
+		cancel()
+		job.ctx = parent
+	}
+}
+
+// each runs fn for every working file with an output path in the temp
+// directory of the step and replaces the working files with the outputs.
+func (job *Job) each(name string, fn func(in, out string) error) error {
+	dir := filepath.Join(job.TempDir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, in := range job.Files {
+		out := filepath.Join(dir, filepath.Base(in))
+		if err := fn(in, out); err != nil {
+			return err
+		}
+		job.Files[i] = out
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/google/shlex"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Plugin is an external pipeline step, used by its name in PIPELINE_STEPS.
+//
+// The command gets a pluginRequest as JSON on stdin and answers with a
+// pluginResult as JSON on stdout, stderr ends up in the job log. A non-zero
+// exit status fails the job.
+type Plugin struct {
+	Name    string
+	Command string
+}
+
+// pluginRequest describes the job to a plugin. New files go to Dir.
+type pluginRequest struct {
+	jobInfo
+	Step string `json:"step"`
+	Dir  string `json:"dir"`
+}
+
+// pluginResult is the answer of a plugin, every field is optional.
+type pluginResult struct {
+	Files    []string  `json:"files"`    // replace the working files
+	Document *Document `json:"document"` // replaces the document metadata
+	Skip     bool      `json:"skip"`     // stop the pipeline without failing
+	Error    string    `json:"error"`    // fail the job
+}
+
+// registerPlugins adds the plugins to the pipeline steps.
+func registerPlugins(plugins []Plugin) error {
+	for _, p := range plugins {
+		if p.Name == "" || p.Command == "" {
+			return errors.New("plugins need a name and a command")
+		}
+		if _, ok := steps[p.Name]; ok {
+			return fmt.Errorf("plugin %s clashes with a step of the same name", p.Name)
+		}
+		args, err := shlex.Split(p.Command)
+		if err != nil || len(args) == 0 {
+			return fmt.Errorf("parsing command of plugin %s: %v", p.Name, err)
+		}
+		steps[p.Name] = pluginStep(p.Name, args)
+	}
+	return nil
+}
+
+func pluginStep(name string, args []string) step {
+	return func(job *Job) error {
+		dir := filepath.Join(job.TempDir, name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		stdin, err := json.Marshal(pluginRequest{jobInfo: job.info(), Step: name, Dir: dir})
+		if err != nil {
+			return err
+		}
+
+		job.Debug("Running plugin", "plugin", name, "args", args)
+		end := job.startSpan("plugin "+name, attribute.StringSlice("args", args))
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(job.ctx, args[0], args[1:]...)
+		cmd.WaitDelay = 10 * time.Second
+		cmd.Stdin = bytes.NewReader(stdin)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		job.output(name+" plugin", stderr.Bytes())
+		end(err)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", name, err)
+		}
+
+		// no output means no changes
+		var res pluginResult
+		if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+			if err := json.Unmarshal(out, &res); err != nil {
+				return fmt.Errorf("plugin %s: invalid result: %w", name, err)
+			}
+		}
+		if res.Error != "" {
+			return fmt.Errorf("plugin %s: %s", name, res.Error)
+		}
+		for _, f := range res.Files {
+			if !exists(f) {
+				return fmt.Errorf("plugin %s: no such file %s", name, f)
+			}
+		}
+		if len(res.Files) > 0 {
+			job.Files = res.Files
+		}
+		if res.Document != nil {
+			job.Doc = *res.Document
+		}
+		if res.Skip {
+			return errSkip
+		}
+		return nil
+	}
+}
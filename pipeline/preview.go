@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chbmuc/scan2webdav/storage"
+	"golang.org/x/image/draw"
+)
+
+// uploadPreview uploads a thumbnail of the first page of file, which was
+// uploaded to target. Previews are a convenience, so failures are only
+// logged.
+func uploadPreview(job *Job, store storage.Storage, file string, target string) {
+	cfg := job.Cfg.Preview
+	if !cfg.Enabled {
+		return
+	}
+	if _, ok := store.(*storage.Paperless); ok {
+		// it would become a document of its own
+		return
+	}
+	img, err := firstPageImage(file)
+	if err != nil {
+		// e.g. text only output or a PDF without images
+		job.Debug("No preview", "path", file, "error", err)
+		return
+	}
+
+	ext := ".jpg"
+	if cfg.Format == "png" {
+		ext = ".png"
+	}
+	preview := filepath.Join(job.TempDir, "preview"+ext)
+	if err := writePreview(preview, img, cfg.Size, cfg.Format); err != nil {
+		job.Warn("Unable to create preview", "path", file, "error", err)
+		return
+	}
+	defer os.Remove(preview)
+
+	dir := path.Dir(target)
+	if cfg.Path != "" {
+		if dir, err = expand(cfg.Path, &job.Doc); err != nil {
+			job.Warn("Unable to expand preview path template", "error", err)
+			return
+		}
+		dir = sanitizePath(job.Cfg, dir)
+	}
+	name := path.Base(target)
+	remote := path.Join(dir, strings.TrimSuffix(name, path.Ext(name))+ext)
+
+	err = retryUpload(job.ctx, job.Cfg, store, preview, remote)
+	var se *storage.StatusError
+	if errors.As(err, &se) && se.Code == http.StatusConflict {
+		if err = store.Mkdir(job.ctx, dir); err == nil {
+			err = retryUpload(job.ctx, job.Cfg, store, preview, remote)
+		}
+	}
+	if err != nil {
+		job.Warn("Unable to upload preview", "target", remote, "error", err)
+		return
+	}
+	job.Info("Uploaded preview", "target", remote)
+}
+
+// writePreview scales img down to size pixels on the longer edge.
+func writePreview(file string, img image.Image, size int, format string) error {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w > h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, b, draw.Src, nil)
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if format == "png" {
+		err = png.Encode(f, thumb)
+	} else {
+		err = jpeg.Encode(f, thumb, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// setupUploads prepares cfg and the HTTP client for commands that upload
+// without the daemon.
+func setupUploads(cfg *Config) error {
+	if err := checkConfig(cfg); err != nil {
+		return err
+	}
+	u, err := expand(cfg.Server.Url, cfg.Server)
+	if err != nil {
+		return err
+	}
+	cfg.Server.Url = strings.TrimSuffix(u, "/")
+	if err := setupClient(*cfg); err != nil {
+		return err
+	}
+	setupOAuth(*cfg)
+	return nil
+}
+
+// processCommand runs the pipeline for a single file and exits, so
+// documents can be pushed without the watch folder. The input is kept and
+// the uploaded URLs are printed.
+func processCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("process", flag.ContinueOnError)
+	dest := flags.String("dest", "", "upload to the collection `path` instead of SERVER_PATH and the rules")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	// flags may follow the file as well
+	file := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[min(1, flags.NArg()):]); err != nil {
+		return 2
+	}
+	if file == "" || flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: process [-dest path] file")
+		return 2
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	kind, err := checkInput(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		return 1
+	}
+
+	cfg.Originals.Mode = "keep"
+	if err := setupUploads(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	tempDir, err := newTempDir(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer removeTempDir(tempDir)
+
+	job := newJob(ctx, cfg, newID(), file, tempDir)
+	// steps may move their input, the file stays where it is
+	in := filepath.Join(tempDir, "in", filepath.Base(file))
+	if err := os.MkdirAll(filepath.Dir(in), 0700); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := copyFile(file, in); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	job.Files = []string{in}
+	if err := job.retype(kind); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *dest != "" {
+		// like the destination of a job ticket
+		if job.Ticket == nil {
+			job.Ticket = &Ticket{}
+		}
+		job.Ticket.Path = *dest
+		job.Cfg.Server.Path = *dest
+	}
+	err = job.run()
+	endSpan(job.span, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		return 1
+	}
+	for _, u := range job.uploaded {
+		fmt.Println(u)
+	}
+	return 0
+}
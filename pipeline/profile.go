@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Profile overrides settings for files below a subfolder of the watch path
+// or with a filename prefix or pattern, e.g. to deliver the scans of
+// everyone sharing a scanner into their own account.
+type Profile struct {
+	Name   string
+	Path   string // relative to the watch path
+	Prefix string // of the filename, e.g. alice_
+	// regular expression the filename has to match, e.g.
+	// ^(?P<device>KM_C\d+)-; the named groups are .Match in the templates
+	Pattern string
+	// drop the prefix from the uploaded name
+	StripPrefix bool `yaml:"strip_prefix"`
+	Steps       []string
+
+	Priority int // queue priority of documents in this folder
+
+	OcrArgs      string `yaml:"ocr_args"`       // replaces OCR_ARGS
+	OcrExtraArgs string `yaml:"ocr_extra_args"` // appended to OCR_ARGS
+	Language     string // replaces OCR_LANGUAGE
+	Color        string // replaces RESAMPLE_COLOR
+	Crop         bool   // sets CROP_ENABLED
+	Spread       bool   // sets SPREAD_ENABLED
+	Receipts     bool   // sets RECEIPTS_ENABLED
+
+	// upload target and credentials, replacing the SERVER_ settings that
+	// are set here
+	Server struct {
+		Url   string
+		User  string
+		Pass  string
+		Token string
+		Path  string // collection template
+		Name  string // filename template
+	}
+
+	// notification recipients
+	MailTo       []string `yaml:"mail_to"`
+	NtfyUrl      string   `yaml:"ntfy_url"`
+	TelegramChat string   `yaml:"telegram_chat"`
+	Webhook      string
+
+	re *regexp.Regexp
+}
+
+func compileProfiles(profiles []Profile) error {
+	for i := range profiles {
+		if profiles[i].Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(profiles[i].Pattern)
+		if err != nil {
+			return err
+		}
+		profiles[i].re = re
+	}
+	return nil
+}
+
+// match returns the named groups of the pattern in name, nil if there
+// are none.
+func (p *Profile) match(name string) map[string]string {
+	if p.re == nil {
+		return nil
+	}
+	m := p.re.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	groups := map[string]string{}
+	for i, g := range p.re.SubexpNames() {
+		if g != "" {
+			groups[g] = m[i]
+		}
+	}
+	return groups
+}
+
+// profileFor returns the profile with the longest path containing file
+// and, among those, the longest matching prefix, preferring those with a
+// pattern.
+func profileFor(cfg Config, file string) *Profile {
+	rel, err := filepath.Rel(cfg.Watcher.Path, file)
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Dir(rel)
+	name := filepath.Base(rel)
+
+	var best *Profile
+	for i := range cfg.Watcher.Profiles {
+		p := &cfg.Watcher.Profiles[i]
+		path := filepath.Clean(p.Path)
+		if dir != path && !strings.HasPrefix(dir, path+string(filepath.Separator)) {
+			continue
+		}
+		if !strings.HasPrefix(name, p.Prefix) || p.re != nil && !p.re.MatchString(name) {
+			continue
+		}
+		if best == nil {
+			best = p
+			continue
+		}
+		bestPath := filepath.Clean(best.Path)
+		switch {
+		case len(path) != len(bestPath):
+			if len(path) > len(bestPath) {
+				best = p
+			}
+		case len(p.Prefix) != len(best.Prefix):
+			if len(p.Prefix) > len(best.Prefix) {
+				best = p
+			}
+		case p.re != nil && best.re == nil:
+			best = p
+		}
+	}
+	return best
+}
+
+// forFile returns the configuration to use for file.
+func (cfg Config) forFile(file string) Config {
+	p := profileFor(cfg, file)
+	if p == nil {
+		return cfg
+	}
+	if len(p.Steps) > 0 {
+		cfg.Pipeline.Steps = p.Steps
+	}
+	if p.OcrArgs != "" {
+		cfg.Ocr.Args = p.OcrArgs
+	}
+	if p.OcrExtraArgs != "" {
+		cfg.Ocr.Args = cfg.Ocr.Args + " " + p.OcrExtraArgs
+	}
+	if p.Language != "" {
+		cfg.Ocr.Language = p.Language
+	}
+	if p.Crop {
+		cfg.Crop.Enabled = true
+	}
+	if p.Spread {
+		cfg.Spread.Enabled = true
+	}
+	if p.Receipts {
+		cfg.Receipts.Enabled = true
+	}
+
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&cfg.Resample.Color, p.Color)
+	set(&cfg.Server.Url, p.Server.Url)
+	set(&cfg.Server.User, p.Server.User)
+	set(&cfg.Server.Pass, p.Server.Pass)
+	set(&cfg.Server.Token, p.Server.Token)
+	set(&cfg.Server.Path, p.Server.Path)
+	set(&cfg.Server.Name, p.Server.Name)
+	if p.Server.User != "" || p.Server.Token != "" {
+		// the shared OAuth tokens belong to another account
+		cfg.OAuth.TokenUrl = ""
+	}
+	if p.Server.Url != "" {
+		// like SERVER_URL at the start
+		if url, err := expand(p.Server.Url, cfg.Server); err == nil {
+			cfg.Server.Url = url
+		}
+	}
+	if len(p.MailTo) > 0 {
+		cfg.Smtp.To = p.MailTo
+	}
+	set(&cfg.Ntfy.Url, p.NtfyUrl)
+	set(&cfg.Telegram.Chat, p.TelegramChat)
+	set(&cfg.Notify.Webhook, p.Webhook)
+	return cfg
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pushNote is a message for the push providers.
+type pushNote struct {
+	Title  string
+	Text   string
+	Link   string // opened when the notification is clicked
+	Urgent bool
+}
+
+// jobNote returns the push notification of a finished job.
+func jobNote(e jobEvent) pushNote {
+	if e.Status == "failed" {
+		return pushNote{Title: "Scan failed: " + e.File, Text: e.Error, Urgent: true}
+	}
+	n := pushNote{Title: "Scan processed: " + e.File, Text: e.URL}
+	if e.Document.Review {
+		n = pushNote{Title: "Scan needs review: " + e.File, Text: e.URL, Urgent: true}
+	}
+	if len(e.Uploads) > 0 {
+		n.Text = strings.Join(e.Uploads, "\n")
+		n.Link = e.Uploads[0]
+	}
+	if len(e.Shares) > 0 {
+		// the link that can be forwarded
+		n.Text += "\n" + strings.Join(e.Shares, "\n")
+		n.Link = e.Shares[0]
+	}
+	return n
+}
+
+// pushJob sends a finished job to the push providers that want to hear
+// about it. On is failed to only report failures, or all.
+func pushJob(ctx context.Context, job *Job, e jobEvent) {
+	sendPush(ctx, job.Cfg, jobNote(e), func(on string) bool {
+		return on == "all" || e.Status == "failed" || e.Document.Review
+	})
+}
+
+// sendPush sends n to the configured providers for which want returns
+// true. Errors are only logged.
+func sendPush(ctx context.Context, cfg Config, n pushNote, want func(on string) bool) {
+	if cfg.Ntfy.Url != "" && want(cfg.Ntfy.On) {
+		if err := pushNtfy(ctx, cfg, n); err != nil {
+			slog.WarnContext(ctx, "Unable to notify ntfy", "error", err)
+		}
+	}
+	if cfg.Gotify.Url != "" && want(cfg.Gotify.On) {
+		if err := pushGotify(ctx, cfg, n); err != nil {
+			slog.WarnContext(ctx, "Unable to notify Gotify", "error", err)
+		}
+	}
+	if cfg.Telegram.Token != "" && want(cfg.Telegram.On) {
+		if err := pushTelegram(ctx, cfg, n); err != nil {
+			slog.WarnContext(ctx, "Unable to notify Telegram", "error", err)
+		}
+	}
+}
+
+// pushNtfy publishes to the topic URL.
+func pushNtfy(ctx context.Context, cfg Config, n pushNote) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Ntfy.Url, strings.NewReader(n.Text))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", n.Title)
+	if n.Urgent {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	}
+	if n.Link != "" {
+		req.Header.Set("Click", n.Link)
+	}
+	if cfg.Ntfy.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Ntfy.Token)
+	}
+	return push(req, "ntfy")
+}
+
+func pushGotify(ctx context.Context, cfg Config, n pushNote) error {
+	priority := 5
+	if n.Urgent {
+		priority = 8
+	}
+	body, err := json.Marshal(map[string]any{"title": n.Title, "message": n.Text, "priority": priority})
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(cfg.Gotify.Url, "/") + "/message?token=" + url.QueryEscape(cfg.Gotify.Token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return push(req, "gotify")
+}
+
+func pushTelegram(ctx context.Context, cfg Config, n pushNote) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  cfg.Telegram.Chat,
+		"text":                     n.Title + "\n" + n.Text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	u := "https://api.telegram.org/bot" + cfg.Telegram.Token + "/sendMessage"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return push(req, "telegram")
+}
+
+// push sends a notification request. The client is shared with the
+// uploads, so the proxy and TLS settings apply.
+func push(req *http.Request, op string) error {
+	res, err := client.Do(req)
+	if err != nil {
+		// the Telegram URL contains the bot token
+		var ue *url.Error
+		if op == "telegram" && errors.As(err, &ue) {
+			return ue.Err
+		}
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s failed: %s", op, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkInput runs a quick check on the input path, so obviously broken
+// files don't take up an OCR run. The type is told by the content, not the
+// extension, and returned as the matching extension; it is empty for files
+// that are neither PDFs nor images. Those are junk if their name claims
+// otherwise. PDFs need the end of file marker that is missing from
+// truncated files, images need a readable header.
+func checkInput(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	kind := sniffType(f)
+	switch {
+	case kind == "":
+		if ext := fileType(path); ext == "" || ext == ".pdf" || ext == ".heic" || imageExts[ext] {
+			return "", errors.New("unknown file type")
+		}
+		return "", nil
+	case kind == ".heic":
+		// left to HEIC_EXEC, Go can't decode them
+		return kind, nil
+	case kind != ".pdf":
+		if _, _, err := image.DecodeConfig(f); err != nil {
+			return "", fmt.Errorf("unreadable image: %w", err)
+		}
+		return kind, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	tail := make([]byte, min(fi.Size(), 1024))
+	if _, err := f.ReadAt(tail, fi.Size()-int64(len(tail))); err != nil && err != io.EOF {
+		return "", err
+	}
+	if !bytes.Contains(tail, []byte("%%EOF")) {
+		return "", errors.New("no end of file marker, the PDF is truncated")
+	}
+	return kind, nil
+}
+
+// quarantine puts a broken input aside. With QUARANTINE_PATH it is moved
+// there along with a file naming the reason, otherwise it is remembered as
+// failed, so it isn't tried again until it changes.
+func quarantine(cfg Config, id string, path string, reason error) {
+	slog.Warn("Quarantining broken input", "job_id", id, "file", path, "reason", reason)
+	jobResults.inc("quarantined")
+
+	if cfg.Quarantine.Path == "" {
+		sum, err := fileHash(path)
+		if err == nil {
+			err = failures.block(path, sum, reason.Error(), cfg.Retry.Attempts)
+		}
+		if err != nil {
+			slog.Warn("Unable to remember broken input", "job_id", id, "file", path, "error", err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(cfg.Quarantine.Path, 0755); err != nil {
+		slog.Error("Unable to create quarantine directory", "job_id", id, "error", err)
+		return
+	}
+	dst := freeName(filepath.Join(cfg.Quarantine.Path, filepath.Base(path)))
+	if err := moveFile(path, dst); err != nil {
+		slog.Error("Unable to move input to quarantine", "job_id", id, "file", path, "error", err)
+		return
+	}
+	if err := os.WriteFile(dst+".reason", []byte(reason.Error()+"\n"), 0644); err != nil {
+		slog.Warn("Unable to write quarantine reason", "job_id", id, "file", dst, "error", err)
+	}
+}
+
+// ignoredPath reports whether path is below ORIGINALS_PATH or
+// QUARANTINE_PATH, both of which may be inside the watched directory.
+func ignoredPath(cfg Config, path string) bool {
+	return isOriginal(cfg, path) || (cfg.Quarantine.Path != "" && within(cfg.Quarantine.Path, path))
+}
+
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,312 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+type queueItem struct {
+	name     string
+	priority int
+	added    time.Time
+	run      func()
+}
+
+// jobQueue runs queued work on a fixed number of workers, highest priority
+// first. Waiting items gain one priority point per aging interval so large
+// low priority documents aren't starved.
+type jobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []*queueItem
+	aging   time.Duration
+	paused  bool
+	stopped bool
+	running sync.WaitGroup
+}
+
+// queue runs the pipelines, uploads runs their upload step if separate
+// upload workers are configured.
+var queue, uploads *jobQueue
+
+func newJobQueue(workers int, aging time.Duration) *jobQueue {
+	q := &jobQueue{aging: aging}
+	q.cond = sync.NewCond(&q.mu)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+func (q *jobQueue) push(name string, priority int, run func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	slog.Debug("Queued", "name", name, "priority", priority)
+	q.items = append(q.items, &queueItem{name: name, priority: priority, added: time.Now(), run: run})
+	q.cond.Signal()
+}
+
+func (q *jobQueue) score(item *queueItem, now time.Time) int {
+	score := item.priority
+	if q.aging > 0 {
+		score += int(now.Sub(item.added) / q.aging)
+	}
+	return score
+}
+
+// pop waits for the next item. It returns nil once the queue is stopped.
+func (q *jobQueue) pop() *queueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for (len(q.items) == 0 || q.paused) && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped {
+		return nil
+	}
+
+	now := time.Now()
+	best := 0
+	for i, item := range q.items {
+		// on equal score the older item wins, it's first in the slice
+		if q.score(item, now) > q.score(q.items[best], now) {
+			best = i
+		}
+	}
+	item := q.items[best]
+	q.items = append(q.items[:best], q.items[best+1:]...)
+	q.running.Add(1)
+	return item
+}
+
+// stop makes the workers exit after their current item. Queued items are
+// left alone, their files are picked up again on the next start.
+func (q *jobQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+	q.cond.Broadcast()
+}
+
+// pause keeps the workers from starting new items. Running items go on.
+func (q *jobQueue) pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = true
+}
+
+func (q *jobQueue) resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = false
+	q.cond.Broadcast()
+}
+
+func (q *jobQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// wait blocks until the running items are finished.
+func (q *jobQueue) wait() {
+	q.running.Wait()
+}
+
+func (q *jobQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// list returns the waiting items, in no particular order.
+func (q *jobQueue) list() []queueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]queueItem, len(q.items))
+	for i, item := range q.items {
+		items[i] = *item
+	}
+	return items
+}
+
+func (q *jobQueue) worker() {
+	for {
+		item := q.pop()
+		if item == nil {
+			return
+		}
+		func() {
+			defer reportPanic()
+			item.run()
+		}()
+		q.running.Done()
+	}
+}
+
+// queueUpload continues job with its upload step, on the upload workers if
+// there are any.
+func queueUpload(job *Job) {
+	q := uploads
+	if q == nil {
+		q = queue
+	}
+	q.push("upload of "+job.Input, 0, func() {
+		job.uploading = true
+		runJob(job)
+	})
+}
+
+// priorityFor returns the base priority of a file: the priority of its
+// profile minus one point per QUEUE_SIZE_STEP bytes.
+func priorityFor(cfg Config, path string) int {
+	priority := 0
+	if p := profileFor(cfg, path); p != nil {
+		priority = p.Priority
+	}
+	if fi, err := os.Stat(path); err == nil && cfg.Queue.SizeStep > 0 {
+		priority -= int(fi.Size() / cfg.Queue.SizeStep)
+	}
+	return priority
+}
+
+// inflight holds the paths that are waiting, queued or being processed.
+var inflight = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// claim marks path as in flight. It returns false if it already was.
+func claim(path string) bool {
+	inflight.Lock()
+	defer inflight.Unlock()
+	if inflight.paths[path] {
+		return false
+	}
+	inflight.paths[path] = true
+	return true
+}
+
+func release(path string) {
+	unlockInput(path)
+	inflight.Lock()
+	defer inflight.Unlock()
+	delete(inflight.paths, path)
+}
+
+func releaseAll(paths []string) {
+	for _, path := range paths {
+		release(path)
+	}
+}
+
+// forgetQueued drops the items left in the stopped queues and the files in
+// flight, the next Run finds them again when it sweeps the watcher path.
+func forgetQueued() {
+	forQueues(func(q *jobQueue) {
+		q.mu.Lock()
+		q.items = nil
+		q.mu.Unlock()
+	})
+	inflight.Lock()
+	inflight.paths = map[string]bool{}
+	inflight.Unlock()
+	intake.Lock()
+	intake.paused, intake.since, intake.held = false, time.Time{}, nil
+	intake.Unlock()
+}
+
+// enqueue adds a file to the queue. Files reported by the watcher are
+// queued after a delay to make sure they are complete. The file stays in
+// flight until runJob is done with it.
+func enqueue(ctx context.Context, cfg Config, path string, wait bool) {
+	if isTicket(path) || ignoredPath(cfg, path) {
+		return
+	}
+	if hold(path, func() { enqueue(ctx, cfg, path, wait) }) {
+		slog.Debug("Holding file while paused", "file", path)
+		return
+	}
+	if !claim(path) {
+		slog.Debug("Ignoring event for file in progress", "file", path)
+		return
+	}
+	// the job ID is assigned here, so the log lines up to the start of
+	// the job can be attributed as well
+	id := newID()
+	slog.Info("New file detected", "job_id", id, "file", path)
+	filesDetected.inc("")
+	if wait {
+		// Wait 5 seconds to make sure file is complete
+		if !sleep(ctx, 5*time.Second) {
+			release(path)
+			return
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		slog.Info("File disappeared before processing, skipping", "job_id", id, "file", path)
+		release(path)
+		return
+	}
+	// scanners often create the file first and write it later, which
+	// brings another event
+	if fi.Size() == 0 {
+		slog.Info("Skipping empty file", "job_id", id, "file", path)
+		release(path)
+		return
+	}
+	if disposals.pending(path) {
+		slog.Info("Skipping processed file waiting to be disposed of", "job_id", id, "file", path)
+		release(path)
+		return
+	}
+	if e := failures.blocked(path, cfg.Retry.Attempts); e != nil {
+		slog.Warn("Skipping file that failed before", "job_id", id, "file", path, "attempts", e.Attempts, "reason", e.Reason)
+		release(path)
+		return
+	}
+	queue.push(path, priorityFor(cfg, path), func() {
+		processFile(ctx, cfg, id, path)
+	})
+}
+
+// enqueueGroup queues files, the originals of one document like the pages
+// of a batch, as a single job. They go through the same checks as single
+// files and the job only runs if all of them pass. merge combines them
+// into the working file of the job in its temp directory.
+func enqueueGroup(ctx context.Context, cfg Config, files []string, merge func(id string, tempDir string) (string, error)) {
+	if hold(files[0], func() { enqueueGroup(ctx, cfg, files, merge) }) {
+		slog.Debug("Holding files while paused", "files", files)
+		return
+	}
+	for i, path := range files {
+		if !claim(path) {
+			slog.Debug("Ignoring files in progress", "files", files)
+			releaseAll(files[:i])
+			return
+		}
+	}
+	id := newID()
+	for _, path := range files {
+		if disposals.pending(path) {
+			slog.Info("Skipping processed file waiting to be disposed of", "job_id", id, "file", path)
+			releaseAll(files)
+			return
+		}
+		if e := failures.blocked(path, cfg.Retry.Attempts); e != nil {
+			slog.Warn("Skipping file that failed before", "job_id", id, "file", path, "attempts", e.Attempts, "reason", e.Reason)
+			releaseAll(files)
+			return
+		}
+	}
+	queue.push(files[0], priorityFor(cfg, files[0]), func() {
+		processGroup(ctx, cfg, id, files, merge)
+	})
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+const quotaProps = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:quota-available-bytes/></d:prop></d:propfind>`
+
+// quotaShort is set while uploads are held for lack of quota, so the alert
+// goes out once.
+var quotaShort atomic.Bool
+
+// quota returns the quota-available-bytes of the collection at url, -1 if
+// the server doesn't tell or there is no limit.
+func quota(job *Job, s *storage.WebDAV, url string) (int64, error) {
+	var props []map[string]string
+	if err := s.Props(job.ctx, "PROPFIND", url, "0", quotaProps, &props); err != nil {
+		return 0, err
+	}
+	if len(props) == 0 || props[0]["quota-available-bytes"] == "" {
+		return -1, nil
+	}
+	// Nextcloud has negative values for unknown and unlimited
+	free, err := strconv.ParseInt(props[0]["quota-available-bytes"], 10, 64)
+	if err != nil || free < 0 {
+		return -1, nil
+	}
+	return free, nil
+}
+
+// waitForQuota holds the upload of the job with SERVER_QUOTA until the
+// server has room for its files, rather than letting it fail with 507
+// Insufficient Storage. The first job held sends an alert. A quota that
+// can't be queried lets the upload go ahead.
+func waitForQuota(job *Job, store storage.Storage) error {
+	if !job.Cfg.Server.Quota {
+		return nil
+	}
+	s, ok := store.(*storage.WebDAV)
+	if !ok {
+		return nil
+	}
+	var need int64
+	for _, f := range job.Files {
+		if fi, err := os.Stat(f); err == nil {
+			need += fi.Size()
+		}
+	}
+
+	wait := job.Cfg.Server.QuotaWait
+	warned := false
+	for {
+		free, err := quota(job, s, job.Cfg.Server.Url)
+		if err != nil {
+			job.Warn("Unable to query quota", "url", job.Cfg.Server.Url, "error", err)
+			return nil
+		}
+		if free < 0 || free >= need {
+			if quotaShort.CompareAndSwap(true, false) {
+				slog.Info("Quota available again, uploading")
+			}
+			return nil
+		}
+		if !warned {
+			job.Warn("Not enough quota on the server, holding upload", "free_mib", free>>20, "need_mib", need>>20, "retry_in", wait)
+			warned = true
+		}
+		if quotaShort.CompareAndSwap(false, true) {
+			reason := fmt.Sprintf("quota exceeded, %d MiB free, %d MiB needed", free>>20, need>>20)
+			text := reason + "\nUploads are held until there is room on " + job.Cfg.Server.Url + "."
+			al := alert{Type: "alert", Time: time.Now(), Reason: reason}
+			go broadcast(job.Cfg, "alert", pushNote{Title: "scan2webdav alert: " + reason, Text: text, Urgent: true}, al)
+		}
+		if !sleep(job.ctx, wait) {
+			return job.ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Receipt is what the receipts mode reads from the OCR text of a receipt.
+type Receipt struct {
+	Vendor   string `json:"vendor,omitempty"`
+	Date     string `json:"date,omitempty"`  // YYYY-MM-DD
+	Total    string `json:"total,omitempty"` // e.g. 12.50
+	Currency string `json:"currency,omitempty"`
+}
+
+var receiptHeader = []string{"date", "vendor", "total", "currency", "document", "time", "job"}
+
+var (
+	receiptDates = []struct {
+		re     *regexp.Regexp
+		layout string
+	}{
+		{regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`), "2006-01-02"},
+		{regexp.MustCompile(`\b(\d{1,2})[./](\d{1,2})[./](\d{4})\b`), "2.1.2006"},
+		{regexp.MustCompile(`\b(\d{1,2})[./](\d{1,2})[./](\d{2})\b`), "2.1.06"},
+	}
+	// amounts with two decimals, thousands separated or not
+	receiptAmount = regexp.MustCompile(`\d{1,3}(?:[.,' ]\d{3})*[.,]\d{2}\b|\d+[.,]\d{2}\b`)
+	// the words the total is printed with
+	receiptTotal = regexp.MustCompile(`(?i)\b(total|totale|totaal|summe|gesamt|betrag|zu zahlen|amount due|montant|importe)`)
+	currencies   = []struct{ mark, code string }{
+		{"€", "EUR"}, {"EUR", "EUR"}, {"CHF", "CHF"}, {"£", "GBP"}, {"GBP", "GBP"}, {"$", "USD"}, {"USD", "USD"},
+	}
+)
+
+// parseReceipt reads vendor, date, total and currency from the text of a
+// receipt. The vendor is the first line with words, the total the amount
+// on the last line naming it or else the largest amount. Unknown values
+// are empty.
+func parseReceipt(text string) Receipt {
+	var r Receipt
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if letters(line) >= 3 {
+			r.Vendor = line
+			break
+		}
+	}
+	for _, d := range receiptDates {
+		m := d.re.FindStringSubmatch(text)
+		if m == nil {
+			continue
+		}
+		s := strings.Join(m[1:], ".")
+		if d.layout == "2006-01-02" {
+			s = strings.Join(m[1:], "-")
+		}
+		if t, err := time.Parse(d.layout, s); err == nil {
+			r.Date = t.Format("2006-01-02")
+			break
+		}
+	}
+
+	largest, most := "", -1.0
+	for _, line := range strings.Split(text, "\n") {
+		amounts := receiptAmount.FindAllString(line, -1)
+		if len(amounts) == 0 {
+			continue
+		}
+		if receiptTotal.MatchString(line) {
+			r.Total = normalizeAmount(amounts[len(amounts)-1])
+			continue
+		}
+		for _, a := range amounts {
+			if v, err := strconv.ParseFloat(normalizeAmount(a), 64); err == nil && v > most {
+				largest, most = normalizeAmount(a), v
+			}
+		}
+	}
+	if r.Total == "" {
+		r.Total = largest
+	}
+	for _, c := range currencies {
+		if strings.Contains(text, c.mark) {
+			r.Currency = c.code
+			break
+		}
+	}
+	return r
+}
+
+func letters(s string) int {
+	n := 0
+	for _, c := range s {
+		if unicode.IsLetter(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// normalizeAmount turns 1.234,50 or 1,234.50 into 1234.50.
+func normalizeAmount(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case i == len(s)-3:
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+// receiptStep appends the receipt of the uploaded document to the CSV
+// files RECEIPTS_FILE and RECEIPTS_REMOTE, turning a folder of receipts
+// into an expense log. The document is stored already, so failures are
+// only logged.
+func receiptStep(job *Job) error {
+	cfg := job.Cfg.Receipts
+	if !cfg.Enabled || job.Doc.Receipt == nil {
+		return nil
+	}
+	r := job.Doc.Receipt
+	row := []string{r.Date, r.Vendor, r.Total, r.Currency, strings.Join(job.remotes, " "), job.Doc.Time.Format(time.RFC3339), job.ID}
+	if cfg.File != "" {
+		if err := appendReceiptFile(cfg.File, row); err != nil {
+			job.Warn("Unable to add the receipt", "file", cfg.File, "error", err)
+		}
+	}
+	if cfg.Remote != "" {
+		if err := appendReceiptRemote(job.ctx, job.Cfg, cfg.Remote, row); err != nil {
+			job.Warn("Unable to add the receipt", "file", cfg.Remote, "error", err)
+		}
+	}
+	job.Info("Recorded receipt", "vendor", r.Vendor, "date", r.Date, "total", r.Total, "currency", r.Currency)
+	return nil
+}
+
+// receiptFileMu serializes the appends of the workers.
+var receiptFileMu sync.Mutex
+
+func appendReceiptFile(file string, row []string) error {
+	receiptFileMu.Lock()
+	defer receiptFileMu.Unlock()
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w := csv.NewWriter(f)
+	if fi.Size() == 0 {
+		w.Write(receiptHeader)
+	}
+	w.Write(row)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// appendReceiptRemote adds row to the CSV file name on the server, other
+// instances may add theirs at the same time.
+func appendReceiptRemote(ctx context.Context, cfg Config, name string, row []string) error {
+	return updateRemote(ctx, cfg, name, "receipts", func(data []byte) ([]byte, bool, error) {
+		var buf bytes.Buffer
+		buf.Write(data)
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		w := csv.NewWriter(&buf)
+		if len(data) == 0 {
+			w.Write(receiptHeader)
+		}
+		w.Write(row)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, false, err
+		}
+		return buf.Bytes(), true, nil
+	})
+}
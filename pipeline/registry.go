@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// sharedAttempts bounds the retries of a registry update that lost the race
+// against another instance.
+const sharedAttempts = 10
+
+// claimShared looks the document up in the registry on the server at
+// DUPLICATES_SHARED and adds it if it is new, so instances watching
+// mirrored folders on different machines process every document once. It
+// returns the entry of a document processed before.
+func claimShared(ctx context.Context, cfg Config, e hashEntry, distance int) (*hashEntry, error) {
+	var dup *hashEntry
+	err := updateShared(ctx, cfg, func(entries []hashEntry) ([]hashEntry, bool) {
+		if d := findHash(entries, e.Sha256, e.Image, distance); d != nil {
+			dup = d
+			return nil, false
+		}
+		return append(entries, e), true
+	})
+	return dup, err
+}
+
+// releaseShared removes the claim of a failed document, so another
+// instance may try it.
+func releaseShared(ctx context.Context, cfg Config, sum string) error {
+	return updateShared(ctx, cfg, func(entries []hashEntry) ([]hashEntry, bool) {
+		i := slices.IndexFunc(entries, func(e hashEntry) bool { return e.Sha256 == sum })
+		if i < 0 {
+			return nil, false
+		}
+		return slices.Delete(entries, i, i+1), true
+	})
+}
+
+// updateShared applies fn to the registry and writes the result back if
+// fn reports a change.
+func updateShared(ctx context.Context, cfg Config, fn func([]hashEntry) ([]hashEntry, bool)) error {
+	return updateRemote(ctx, cfg, cfg.Duplicates.Shared, "registry", func(data []byte) ([]byte, bool, error) {
+		var entries []hashEntry
+		if data != nil {
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return nil, false, err
+			}
+		}
+		updated, changed := fn(entries)
+		if !changed {
+			return nil, false, nil
+		}
+		data, err := json.Marshal(updated)
+		return data, true, err
+	})
+}
+
+// updateRemote applies fn to the content of the file name on the server,
+// nil if it doesn't exist yet, and writes the result back if fn reports a
+// change. The write is conditional on the ETag that was read, a concurrent
+// update makes it start over. what names the file in errors.
+func updateRemote(ctx context.Context, cfg Config, name, what string, fn func([]byte) ([]byte, bool, error)) error {
+	s := newWebDAV(cfg)
+	u := s.URL(name)
+	for attempt := 0; attempt < sharedAttempts; attempt++ {
+		data, etag, err := readRemote(ctx, s, u, what)
+		if err != nil {
+			return err
+		}
+		data, changed, err := fn(data)
+		if err != nil || !changed {
+			return err
+		}
+		header := http.Header{}
+		if etag == "" {
+			header.Set("If-None-Match", "*")
+		} else {
+			header.Set("If-Match", etag)
+		}
+		res, err := s.Do(ctx, http.MethodPut, u, bytes.NewReader(data), int64(len(data)), header)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		switch {
+		case res.StatusCode == http.StatusPreconditionFailed:
+			// another instance was faster
+			if !sleep(ctx, time.Duration(attempt+1)*100*time.Millisecond) {
+				return ctx.Err()
+			}
+			continue
+		case res.StatusCode == http.StatusConflict:
+			if err := s.Mkdir(ctx, path.Dir(name)); err != nil {
+				return err
+			}
+			continue
+		case res.StatusCode < 200 || res.StatusCode >= 300:
+			return &storage.StatusError{Op: "updating " + what, Path: u, Status: res.Status, Code: res.StatusCode}
+		}
+		return nil
+	}
+	return fmt.Errorf("the %s kept changing, giving up", what)
+}
+
+// readRemote returns the content of the file at u and its ETag, nil and
+// no ETag if it doesn't exist yet.
+func readRemote(ctx context.Context, s *storage.WebDAV, u, what string) ([]byte, string, error) {
+	res, err := s.Do(ctx, http.MethodGet, u, nil, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return nil, "", nil
+	case http.StatusOK:
+	default:
+		return nil, "", &storage.StatusError{Op: "reading " + what, Path: u, Status: res.Status, Code: res.StatusCode}
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		return nil, "", fmt.Errorf("the server sends no ETag, the %s can't be updated safely", what)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, etag, nil
+}
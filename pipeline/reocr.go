@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// reocrCommand adds a text layer to the PDFs below a collection that were
+// uploaded without one, e.g. before scan2webdav was used. The documents go
+// through the ocr step and replace the originals on the server.
+func reocrCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("reocr", flag.ContinueOnError)
+	recursive := flags.Bool("recursive", false, "include subcollections")
+	dryRun := flags.Bool("dry-run", false, "only list the documents without text")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	collection := strings.Trim(flags.Arg(0), "/")
+
+	if err := setupUploads(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !strings.HasPrefix(cfg.Server.Url, "http://") && !strings.HasPrefix(cfg.Server.Url, "https://") {
+		fmt.Fprintln(os.Stderr, "reocr needs a WebDAV server")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	store := newWebDAV(cfg)
+	failed := 0
+	err := reocrCollection(ctx, store, collection, *recursive, func(remotePath string, file string) {
+		if *dryRun {
+			fmt.Println(remotePath)
+			return
+		}
+		if err := reocr(ctx, cfg, store, remotePath, file); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", remotePath, err)
+			failed++
+			return
+		}
+		fmt.Println(remotePath)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// reocrCollection calls fn for every PDF without text below collection,
+// with the downloaded file.
+func reocrCollection(ctx context.Context, store *storage.WebDAV, collection string, recursive bool, fn func(remotePath string, file string)) error {
+	entries, err := store.List(ctx, collection)
+	if err != nil {
+		return err
+	}
+	tempDir, err := os.MkdirTemp(tempRoot, "reocr-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.Collection {
+			if recursive {
+				if err := reocrCollection(ctx, store, e.Path, recursive, fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.EqualFold(path.Ext(e.Path), ".pdf") {
+			continue
+		}
+		file := filepath.Join(tempDir, path.Base(e.Path))
+		if err := store.Get(ctx, e.Path, file); err != nil {
+			return err
+		}
+		text, err := hasText(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: skipping unreadable PDF: %v\n", e.Path, err)
+			continue
+		}
+		if !text {
+			fn(e.Path, file)
+		}
+		os.Remove(file)
+	}
+	return nil
+}
+
+// reocr runs file, downloaded from remotePath, through the ocr step and
+// overwrites the original with the result.
+func reocr(ctx context.Context, cfg Config, store storage.Storage, remotePath string, file string) error {
+	tempDir, err := newTempDir(cfg)
+	if err != nil {
+		return err
+	}
+	defer removeTempDir(tempDir)
+	in := filepath.Join(tempDir, "in", path.Base(remotePath))
+	if err := os.MkdirAll(filepath.Dir(in), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(file, in); err != nil {
+		return err
+	}
+
+	cfg.Pipeline.Steps = []string{"ocr"}
+	job := newJob(ctx, cfg, newID(), in, tempDir)
+	err = job.run()
+	endSpan(job.span, err)
+	if err != nil {
+		return err
+	}
+	// the name is kept as it is, unlike in the rename step
+	return retryUpload(ctx, cfg, store, job.Files[0], remotePath)
+}
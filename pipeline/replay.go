@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// replayResult compares the recorded outcome of a job with the outcome of
+// the current configuration.
+type replayResult struct {
+	ID     string   `json:"id"` // of the recorded job
+	Input  string   `json:"input"`
+	Source string   `json:"source,omitempty"` // the file that was replayed
+	Before []string `json:"before"`
+	After  []string `json:"after"` // planned with -dry-run
+	Error  string   `json:"error,omitempty"`
+}
+
+// replayCommand runs the pipeline with the current configuration over
+// documents from the job history, e.g. to try new naming rules or OCR
+// arguments on past documents. The inputs are looked up where they were
+// and among the moved and trashed originals. With -dry-run nothing is
+// uploaded or archived, the destinations are only printed.
+func replayCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	status := flags.String("status", "done", "only jobs with `status`, all if empty")
+	since := flags.Duration("since", 0, "only jobs finished within `duration`")
+	limit := flags.Int("limit", 20, "replay at most `n` jobs")
+	dryRun := flags.Bool("dry-run", false, "don't upload or archive, only show the destinations")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	h, err := openHistory(cfg.State.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// job IDs as arguments select the jobs
+	var entries []historyEntry
+	queries := []historyQuery{{Status: *status, Limit: *limit}}
+	if flags.NArg() > 0 {
+		queries = nil
+		for _, id := range flags.Args() {
+			queries = append(queries, historyQuery{ID: id, Limit: 1})
+		}
+	} else if *since > 0 {
+		queries[0].Since = time.Now().Add(-*since)
+	}
+	for _, q := range queries {
+		found, err := h.query(q)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		entries = append(entries, found...)
+	}
+
+	cfg.Originals.Mode = "keep"
+	if *dryRun {
+		cfg.Pipeline.Steps = slices.DeleteFunc(slices.Clone(cfg.Pipeline.Steps), func(name string) bool {
+			return name == "upload" || name == "archive"
+		})
+	}
+	if err := setupUploads(&cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	originals := &originalsIndex{dirs: []string{cfg.Originals.Path, cfg.Originals.Trash}}
+	results := []replayResult{}
+	failed := false
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		r := replayJob(ctx, cfg, e, originals, *dryRun)
+		failed = failed || r.Error != ""
+		results = append(results, r)
+	}
+
+	code := 0
+	if failed {
+		code = 1
+	}
+	if *asJSON {
+		return max(code, printJSON(results))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tINPUT\tBEFORE\tAFTER")
+	for _, r := range results {
+		after := r.Error
+		if after == "" {
+			after = fmt.Sprint(r.After)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", r.ID, r.Input, r.Before, after)
+	}
+	w.Flush()
+	return code
+}
+
+// replayJob runs the input of e through the pipeline again. The job gets
+// the profile and the time of the recorded one.
+func replayJob(ctx context.Context, cfg Config, e historyEntry, originals *originalsIndex, dryRun bool) replayResult {
+	r := replayResult{ID: e.ID, Input: e.Input, Before: e.Uploads, After: []string{}}
+	if len(r.Before) == 0 {
+		r.Before = []string{e.URL}
+	}
+	r.Source = originals.find(e)
+	if r.Source == "" {
+		r.Error = "input not found"
+		return r
+	}
+
+	tempDir, err := newTempDir(cfg)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer removeTempDir(tempDir)
+
+	job := newJob(ctx, cfg, newID(), e.Input, tempDir)
+	job.Doc.Time = inZone(e.Started)
+	job.Sources = []string{r.Source}
+	in := filepath.Join(tempDir, "in", filepath.Base(e.Input))
+	err = os.MkdirAll(filepath.Dir(in), 0700)
+	if err == nil {
+		err = copyFile(r.Source, in)
+	}
+	if err == nil {
+		job.Files = []string{in}
+		err = job.run()
+		endSpan(job.span, err)
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if !dryRun {
+		r.After = append(r.After, job.uploaded...)
+		return r
+	}
+	for _, f := range job.Files {
+		r.After = append(r.After, job.URL+"/"+filepath.Base(f))
+	}
+	return r
+}
+
+// originalsIndex finds the inputs of past jobs by their hash.
+type originalsIndex struct {
+	dirs   []string
+	hashes map[string]string // of the files in dirs, read on first use
+}
+
+func (o *originalsIndex) find(e historyEntry) string {
+	if sum, err := fileHash(e.Input); err == nil && (e.Hash == "" || sum == e.Hash) {
+		return e.Input
+	}
+	if e.Hash == "" {
+		return ""
+	}
+	if o.hashes == nil {
+		o.hashes = map[string]string{}
+		for _, dir := range o.dirs {
+			if dir == "" {
+				continue
+			}
+			filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || !d.Type().IsRegular() {
+					return nil
+				}
+				if sum, err := fileHash(path); err == nil {
+					o.hashes[sum] = path
+				}
+				return nil
+			})
+		}
+	}
+	return o.hashes[e.Hash]
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// summary covers the jobs finished in a report period.
+type summary struct {
+	Type       string     `json:"type"` // always report
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	Processed  int        `json:"processed"`
+	Failed     int        `json:"failed"`
+	Pages      int        `json:"pages"`
+	Bytes      int64      `json:"bytes"`
+	Queued     int        `json:"queued"`
+	LastUpload *time.Time `json:"last_upload,omitempty"`
+	// the biggest documents of the period
+	Largest []docSize `json:"largest,omitempty"`
+}
+
+func (h *jobHistory) summary(from, to time.Time) (summary, error) {
+	s := summary{Type: "report", From: from, To: to}
+	rows, err := h.db.Query(`SELECT status, COUNT(*), SUM(pages), SUM(bytes) FROM jobs
+		WHERE finished >= ? AND finished < ? GROUP BY status`, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count, pages int
+		var bytes int64
+		if err := rows.Scan(&status, &count, &pages, &bytes); err != nil {
+			return s, err
+		}
+		if status == "failed" {
+			s.Failed += count
+		} else {
+			s.Processed += count
+		}
+		s.Pages += pages
+		s.Bytes += bytes
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+	if s.Largest, err = h.largest(from, to, 3); err != nil {
+		return s, err
+	}
+
+	health.Lock()
+	if !health.lastUpload.IsZero() {
+		last := health.lastUpload
+		s.LastUpload = &last
+	}
+	health.Unlock()
+	forQueues(func(q *jobQueue) { s.Queued += q.len() })
+	return s, nil
+}
+
+func (s summary) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Period:    %s - %s\n", s.From.Format(time.DateTime), s.To.Format(time.DateTime))
+	fmt.Fprintf(&b, "Processed: %d\n", s.Processed)
+	fmt.Fprintf(&b, "Failed:    %d\n", s.Failed)
+	fmt.Fprintf(&b, "Pages:     %d\n", s.Pages)
+	fmt.Fprintf(&b, "Uploaded:  %.1f MiB\n", float64(s.Bytes)/(1<<20))
+	fmt.Fprintf(&b, "Queued:    %d\n", s.Queued)
+	if s.LastUpload != nil {
+		fmt.Fprintf(&b, "Last upload: %s\n", s.LastUpload.Format(time.DateTime))
+	} else {
+		b.WriteString("Last upload: never\n")
+	}
+	if len(s.Largest) > 0 {
+		b.WriteString("\nLargest documents:\n")
+		for _, e := range s.Largest {
+			fmt.Fprintf(&b, "  %s (%d pages, %.1f MiB)\n", e.Input, e.Pages, float64(e.Bytes)/(1<<20))
+		}
+	}
+	return b.String()
+}
+
+// nextReport returns the time of the next report after now. Weekly reports
+// go out on Mondays.
+func nextReport(interval string, at time.Duration, now time.Time) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	if interval == "weekly" {
+		next = next.AddDate(0, 0, -int((next.Weekday()+6)%7))
+	}
+	for !next.After(now) {
+		if interval == "weekly" {
+			next = next.AddDate(0, 0, 7)
+		} else {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// parseReportTime parses REPORT_AT as the time of day, e.g. 07:30.
+func parseReportTime(at string) (time.Duration, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// startReports sends a summary of the job history daily or weekly.
+func startReports(ctx context.Context, cfg Config) {
+	if cfg.Report.Interval == "" {
+		return
+	}
+	if history == nil {
+		slog.Warn("Reports need the job history")
+		return
+	}
+	at, _ := parseReportTime(cfg.Report.At)
+	days := 1
+	if cfg.Report.Interval == "weekly" {
+		days = 7
+	}
+	go func() {
+		for {
+			next := nextReport(cfg.Report.Interval, at, time.Now())
+			if !sleep(ctx, time.Until(next)) {
+				return
+			}
+			s, err := history.summary(next.AddDate(0, 0, -days), next)
+			if err != nil {
+				slog.Warn("Unable to create report", "error", err)
+				continue
+			}
+			sendReport(cfg, s)
+		}
+	}()
+}
+
+// sendReport delivers a summary to every configured notification target.
+func sendReport(cfg Config, s summary) {
+	title := fmt.Sprintf("scan2webdav %s report: %d processed, %d failed", cfg.Report.Interval, s.Processed, s.Failed)
+	broadcast(cfg, "report", pushNote{Title: title, Text: s.text(), Urgent: s.Failed > 0}, s)
+}
@@ -0,0 +1,322 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+)
+
+// a4Inches is the longer edge of the page assumed for image files, which
+// don't tell their page size.
+const a4Inches = 11.69
+
+// resampleStep scales scans with a resolution far above RESAMPLE_DPI,
+// like the 1200 DPI images of some phones, down to it before OCR, and
+// converts them to RESAMPLE_COLOR, gray or bitonal, as text scanned in
+// color only costs storage and OCR time. PDFs are rebuilt from their page
+// images, which is only done for pure scans: PDFs with text or pages
+// without a decodable image are left alone.
+func resampleStep(job *Job) error {
+	r := job.Cfg.Resample
+	if r.Dpi <= 0 && r.Color == "" {
+		return nil
+	}
+	dir := filepath.Join(job.TempDir, "resample")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, in := range job.Files {
+		out := filepath.Join(dir, filepath.Base(in))
+		var err error
+		switch ext := fileType(in); {
+		case imageExts[ext]:
+			out, err = resampleImage(job, in, out)
+		case ext == ".pdf":
+			err = resamplePDF(job, in, out)
+		default:
+			err = copyFile(in, out)
+		}
+		if err != nil {
+			return err
+		}
+		job.Files[i] = out
+	}
+	return nil
+}
+
+// tooFine tells if dpi is more than a quarter above target, less isn't
+// worth losing quality over.
+func tooFine(dpi float64, target int) bool {
+	return target > 0 && dpi > float64(target)*1.25
+}
+
+// resample scales img scanned at dpi down to RESAMPLE_DPI and converts it
+// to RESAMPLE_COLOR. It returns nil if there's nothing to do.
+func resample(cfg Config, img image.Image, dpi float64) image.Image {
+	changed := false
+	if target := cfg.Resample.Dpi; tooFine(dpi, target) {
+		img = scaleImage(img, float64(target)/dpi)
+		changed = true
+	}
+	switch cfg.Resample.Color {
+	case "gray":
+		if _, ok := img.(*image.Gray); !ok && !bitonal(img) {
+			img = toGray(img)
+			changed = true
+		}
+	case "bitonal":
+		if !bitonal(img) {
+			img = toBitonal(img, cfg.Resample.Threshold)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return img
+}
+
+// resampleImage writes the resampled image file in to out, returning the
+// name actually written: bitonal JPEGs become PNGs, JPEG artifacts would
+// only blur the edges.
+func resampleImage(job *Job, in, out string) (string, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return "", err
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	b := img.Bounds()
+	dpi := float64(max(b.Dx(), b.Dy())) / a4Inches
+	res := resample(job.Cfg, img, dpi)
+	if res == nil {
+		return out, copyFile(in, out)
+	}
+	if bitonal(res) && format == "jpeg" {
+		format = "png"
+		out = strings.TrimSuffix(out, filepath.Ext(out)) + ".png"
+	}
+	job.Info("Resampling image", "file", in, "dpi", int(dpi), "target", job.Cfg.Resample.Dpi, "color", job.Cfg.Resample.Color)
+	return out, writeImage(out, format, res)
+}
+
+func resamplePDF(job *Job, in, out string) error {
+	changed, err := rebuildPDF(job, in, out, func(p page) []page {
+		b := p.img.Bounds()
+		dpi := float64(max(b.Dx(), b.Dy())) / (max(p.dim.Width, p.dim.Height) / 72)
+		if img := resample(job.Cfg, p.img, dpi); img != nil {
+			return []page{{img, p.dim}}
+		}
+		return nil
+	})
+	if changed {
+		job.Info("Resampled PDF", "file", in, "target", job.Cfg.Resample.Dpi, "color", job.Cfg.Resample.Color)
+	}
+	return err
+}
+
+// page is the image of a PDF page with its size in points.
+type page struct {
+	img image.Image
+	dim types.Dim
+}
+
+// pageFunc returns the pages replacing p, nil if there's nothing to do.
+type pageFunc func(p page) []page
+
+// rebuildPDF writes in to out with fn applied to its pages and tells if
+// any changed. Only pure scans are rebuilt, PDFs with text or pages
+// without a decodable image are copied.
+func rebuildPDF(job *Job, in, out string, fn pageFunc) (bool, error) {
+	if text, err := hasText(in); err != nil || text {
+		return false, copyFile(in, out)
+	}
+	dims, err := api.PageDimsFile(in)
+	if err != nil {
+		return false, err
+	}
+
+	// one page at a time, the images can be huge
+	dir, err := os.MkdirTemp(job.TempDir, "pages")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	changed := false
+	for i, dim := range dims {
+		images, err := pageImages(in, []string{strconv.Itoa(i + 1)})
+		if err != nil || len(images) != 1 || images[0] == nil {
+			return false, copyFile(in, out)
+		}
+		pages := []page{{images[0], dim}}
+		if res := fn(pages[0]); res != nil {
+			pages = res
+			changed = true
+		}
+		for j, p := range pages {
+			name := filepath.Join(dir, fmt.Sprintf("page%d-%d", i+1, j+1))
+			if err := writePage(name, p); err != nil {
+				return false, err
+			}
+			files = append(files, name+".pdf")
+		}
+	}
+	if !changed {
+		return false, copyFile(in, out)
+	}
+	return true, api.MergeCreateFile(files, out, false, pdfConf())
+}
+
+// writePage writes p as the single page PDF name.pdf.
+func writePage(name string, p page) error {
+	format := "jpeg"
+	if _, ok := p.img.(*image.Paletted); ok {
+		format = "png"
+	}
+	if err := writeImage(name+"."+format, format, p.img); err != nil {
+		return err
+	}
+	// scaled to fit the original page, Full would size the page by the pixels
+	imp := &pdfcpu.Import{PageDim: &p.dim, UserDim: true, Pos: types.Center, Scale: 1, InpUnit: types.POINTS}
+	return api.ImportImagesFile([]string{name + "." + format}, name+".pdf", imp, pdfConf())
+}
+
+// scaleImage resizes img by factor.
+func scaleImage(img image.Image, factor float64) image.Image {
+	b := img.Bounds()
+	r := image.Rect(0, 0, max(1, int(float64(b.Dx())*factor)), max(1, int(float64(b.Dy())*factor)))
+	var dst draw.Image
+	switch img.(type) {
+	case *image.Gray, *image.Paletted:
+		dst = image.NewGray(r)
+	default:
+		dst = image.NewRGBA(r)
+	}
+	draw.BiLinear.Scale(dst, r, img, b, draw.Src, nil)
+	return dst
+}
+
+// writeImage encodes img in format, as image.Decode names them.
+func writeImage(file string, format string, img image.Image) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "png":
+		err = png.Encode(f, img)
+	case "tiff":
+		err = tiff.Encode(f, img, &tiff.Options{Compression: tiff.Deflate})
+	default:
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// bitonal tells if img has only black and white, like toBitonal makes
+// them. Bitonal pages come out of PDFs as gray or RGBA images.
+func bitonal(img image.Image) bool {
+	switch img := img.(type) {
+	case *image.Paletted:
+		return len(img.Palette) <= 2
+	case *image.Gray:
+		for _, v := range img.Pix {
+			if v != 0 && v != 0xff {
+				return false
+			}
+		}
+		return true
+	case *image.RGBA:
+		for i := 0; i+3 < len(img.Pix); i += 4 {
+			v := img.Pix[i]
+			if v != 0 && v != 0xff || img.Pix[i+1] != v || img.Pix[i+2] != v {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok {
+		return g
+	}
+	b := img.Bounds()
+	g := image.NewGray(b)
+	draw.Draw(g, b, img, b.Min, draw.Src)
+	return g
+}
+
+// toBitonal turns img black and white at the gray level threshold, 1 to
+// 255, or the one Otsu's method finds for 0. The two color palette is
+// encoded with one bit per pixel.
+func toBitonal(img image.Image, threshold int) *image.Paletted {
+	g := toGray(img)
+	if threshold <= 0 {
+		threshold = otsuThreshold(g)
+	}
+	b := g.Bounds()
+	p := image.NewPaletted(b, color.Palette{color.Black, color.White})
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if int(g.Pix[g.PixOffset(x, y)]) >= threshold {
+				p.Pix[p.PixOffset(x, y)] = 1
+			}
+		}
+	}
+	return p
+}
+
+// otsuThreshold returns the gray level that separates the histogram of g
+// best into ink and paper.
+func otsuThreshold(g *image.Gray) int {
+	var hist [256]float64
+	b := g.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[g.Pix[g.PixOffset(x, y)]]++
+		}
+	}
+	total, sum := float64(b.Dx()*b.Dy()), 0.0
+	for i, n := range hist {
+		sum += float64(i) * n
+	}
+	best, threshold := 0.0, 128
+	dark, darkSum := 0.0, 0.0
+	for i, n := range hist {
+		dark += n
+		if dark == 0 {
+			continue
+		}
+		light := total - dark
+		if light == 0 {
+			break
+		}
+		darkSum += float64(i) * n
+		d := darkSum/dark - (sum-darkSum)/light
+		if v := dark * light * d * d; v > best {
+			best, threshold = v, i+1
+		}
+	}
+	return threshold
+}
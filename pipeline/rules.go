@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Rule routes a document whose OCR text contains one of the keywords or
+// matches the regular expression.
+type Rule struct {
+	Name     string
+	Keywords []string
+	Regex    string
+	Target   string // collection template relative to the server URL
+	Prefix   string // prepended to the uploaded filename
+	Tags     []string
+	Favorite bool // mark uploads as Nextcloud favorite
+
+	re *regexp.Regexp
+}
+
+func compileRules(rules []Rule) error {
+	for i := range rules {
+		if rules[i].Regex == "" {
+			continue
+		}
+		re, err := regexp.Compile(rules[i].Regex)
+		if err != nil {
+			return err
+		}
+		rules[i].re = re
+	}
+	return nil
+}
+
+func (r *Rule) match(text string) bool {
+	if r.re != nil && r.re.MatchString(text) {
+		return true
+	}
+	lower := strings.ToLower(text)
+	for _, k := range r.Keywords {
+		if k != "" && strings.Contains(lower, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRules returns the first rule matching text or nil.
+func matchRules(rules []Rule, text string) *Rule {
+	for i := range rules {
+		if rules[i].match(text) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// verifyRemote confirms that the upload of local to remotePath arrived
+// complete before the originals are removed. paperless consumes uploads
+// asynchronously, so there is nothing to look at.
+func verifyRemote(ctx context.Context, store storage.Storage, local string, remotePath string) error {
+	if _, ok := store.(*storage.Paperless); ok {
+		return nil
+	}
+	if s, ok := store.(storage.Sizer); ok {
+		fi, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+		size, err := s.Size(ctx, remotePath)
+		if err != nil {
+			return fmt.Errorf("unable to verify %s: %w", remotePath, err)
+		}
+		if size >= 0 && size != fi.Size() {
+			return fmt.Errorf("size mismatch for %s: %d bytes on the server, expected %d", remotePath, size, fi.Size())
+		}
+		return nil
+	}
+	found, err := store.Exists(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("unable to verify %s: %w", remotePath, err)
+	}
+	if !found {
+		return fmt.Errorf("%s not found after the upload", remotePath)
+	}
+	return nil
+}
+
+// consistencyAge is how long deleted originals are checked for.
+const consistencyAge = 30 * 24 * time.Hour
+
+// startConsistencyCheck periodically looks for the remote copies of
+// originals that were deleted and flags those that have disappeared.
+func startConsistencyCheck(ctx context.Context, cfg Config) {
+	if cfg.Originals.Check <= 0 || history == nil {
+		return
+	}
+	go func() {
+		for sleep(ctx, cfg.Originals.Check) {
+			if err := checkDeletions(ctx, cfg); err != nil {
+				slog.Warn("Unable to check deleted originals", "error", err)
+			}
+		}
+	}()
+}
+
+// missingCopy is an original that was deleted but whose remote copy is
+// gone.
+type missingCopy struct {
+	ID     string `json:"id"`
+	Input  string `json:"input"`
+	Remote string `json:"remote"`
+}
+
+func checkDeletions(ctx context.Context, cfg Config) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	rows, err := history.db.QueryContext(ctx, `SELECT id, input, server, remotes FROM deletions
+		WHERE missing = '' AND deleted >= ?`, time.Now().Add(-consistencyAge).UnixMilli())
+	if err != nil {
+		return err
+	}
+	type deletion struct {
+		id, input, server string
+		remotes           []string
+	}
+	var deletions []deletion
+	for rows.Next() {
+		var d deletion
+		var remotes string
+		if err := rows.Scan(&d.id, &d.input, &d.server, &remotes); err != nil {
+			rows.Close()
+			return err
+		}
+		json.Unmarshal([]byte(remotes), &d.remotes)
+		deletions = append(deletions, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []missingCopy
+	for _, d := range deletions {
+		c := cfg
+		c.Server.Url = d.server
+		store, err := newStorage(c, nil)
+		if err != nil {
+			return err
+		}
+		if _, ok := store.(*storage.Paperless); ok {
+			continue
+		}
+		for _, remote := range d.remotes {
+			found, err := store.Exists(ctx, remote)
+			if err != nil {
+				return err
+			}
+			if found {
+				continue
+			}
+			slog.Error("Remote copy of a deleted original is missing", "job_id", d.id, "file", d.input, "remote", remote)
+			missing = append(missing, missingCopy{ID: d.id, Input: d.input, Remote: strings.TrimSuffix(d.server, "/") + "/" + remote})
+			if _, err := history.db.ExecContext(ctx, `UPDATE deletions SET missing = ? WHERE id = ?`, remote, d.id); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var text strings.Builder
+	for _, m := range missing {
+		fmt.Fprintf(&text, "%s: %s\n", m.Input, m.Remote)
+	}
+	al := alert{
+		Type:   "alert",
+		Time:   time.Now(),
+		Reason: fmt.Sprintf("%d deleted originals have no remote copy anymore", len(missing)),
+	}
+	broadcast(cfg, "alert", pushNote{Title: "scan2webdav alert: " + al.Reason, Text: text.String(), Urgent: true},
+		struct {
+			alert
+			Missing []missingCopy `json:"missing"`
+		}{al, missing})
+	return nil
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// errScanning is returned while a scan is running already.
+var errScanning = errors.New("a scan is running already")
+
+// scanning is held while SCAN_COMMAND runs, the scanner can only do one
+// scan at a time.
+var scanning sync.Mutex
+
+// scan runs SCAN_COMMAND, e.g. scanimage or scanadf, in an empty directory
+// and drops what it produced into the watcher path as one document. Pages
+// written as separate images are combined into a PDF first.
+func scan(cfg Config) (string, error) {
+	if !scanning.TryLock() {
+		return "", errScanning
+	}
+	defer scanning.Unlock()
+
+	args, err := shlex.Split(cfg.Scan.Command)
+	if err != nil || len(args) == 0 {
+		return "", fmt.Errorf("parsing SCAN_COMMAND: %v", err)
+	}
+	dir, err := os.MkdirTemp(tempRoot, "scan-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	slog.Info("Scanning", "command", args)
+	cmd := exec.CommandContext(jobCtx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.WaitDelay = 10 * time.Second
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+
+	return dropScan(cfg, dir)
+}
+
+// dropScan moves the files a scan left in dir into the watcher path as one
+// document. Several images are combined into a PDF, several PDFs merged.
+func dropScan(cfg Config, dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", errors.New("the scan produced no files")
+	}
+	// scanadf numbers the pages
+	sort.Strings(files)
+
+	name := "scan-" + inZone(time.Now()).Format("20060102-150405")
+	doc := files[0]
+	if len(files) > 1 {
+		doc = filepath.Join(dir, name+".pdf")
+		if strings.EqualFold(filepath.Ext(files[0]), ".pdf") {
+			err = api.MergeCreateFile(files, doc, false, pdfConf())
+		} else {
+			err = api.ImportImagesFile(files, doc, nil, pdfConf())
+		}
+		if err != nil {
+			return "", fmt.Errorf("combining %d pages: %w", len(files), err)
+		}
+	}
+	dst := freeName(filepath.Join(cfg.Watcher.Path, name+filepath.Ext(doc)))
+	if err := moveFile(doc, dst); err != nil {
+		return "", err
+	}
+	slog.Info("Scan finished", "file", dst, "files", len(files))
+	return dst, nil
+}
+
+// scanHandler starts a scan for POST /api/scan and answers once the
+// document is in the watcher path.
+func scanHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := scan(cfg)
+		switch {
+		case errors.Is(err, errScanning):
+			apiError(w, http.StatusConflict, err)
+		case err != nil:
+			slog.Error("Scan failed", "error", err)
+			apiError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSONResponse(w, http.StatusOK, map[string]string{"path": path})
+		}
+	}
+}
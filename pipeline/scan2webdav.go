@@ -0,0 +1,1216 @@
+// Package pipeline is the scan2webdav daemon: it takes the scans found by
+// the watcher through the configured steps, OCR among them, and uploads
+// the results to the storage backends. It also serves the HTTP and gRPC
+// APIs and implements the commands of the binary.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/ocr"
+	"github.com/chbmuc/scan2webdav/watcher"
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	Server struct {
+		Url  string `envconfig:"SERVER_URL"`
+		User string `envconfig:"SERVER_USER"`
+		Pass string `envconfig:"SERVER_PASS"`
+		// bearer token instead of basic auth
+		Token string `envconfig:"SERVER_TOKEN"`
+		Proxy string `envconfig:"SERVER_PROXY"` // overrides HTTP(S)_PROXY
+		Path  string `envconfig:"SERVER_PATH"`  // collection template
+		Name  string `envconfig:"SERVER_NAME"`  // filename template
+		Form  bool   `envconfig:"SERVER_FORM"`  // multipart form instead of a plain PUT
+		// overwrite, skip, rename or timestamp
+		Conflict string `envconfig:"SERVER_CONFLICT"`
+		// name template for rename, e.g. scan-{{.Time.Format "2006-01-02T15-04-05"}}-{{printf "%03d" .N}}
+		ConflictName string `envconfig:"SERVER_CONFLICT_NAME" yaml:"conflict_name"`
+		// files above the threshold use Nextcloud chunked uploads
+		ChunkThreshold int64 `envconfig:"SERVER_CHUNK_THRESHOLD" yaml:"chunk_threshold"`
+		ChunkSize      int64 `envconfig:"SERVER_CHUNK_SIZE" yaml:"chunk_size"`
+		// verify uploads: etag or head, empty to trust the status code
+		Checksum string `envconfig:"SERVER_CHECKSUM"`
+		Verify   bool   `envconfig:"SERVER_VERIFY"` // check size and mtime with HEAD
+		Lock     bool   `envconfig:"SERVER_LOCK"`   // LOCK the target during the upload
+		// hold uploads while the quota-available-bytes of the server can't
+		// fit them, checking again every QuotaWait
+		Quota     bool          `envconfig:"SERVER_QUOTA"`
+		QuotaWait time.Duration `envconfig:"SERVER_QUOTA_WAIT" yaml:"quota_wait"`
+		// concurrent requests per host, 0 for no limit; waiting counts
+		// towards SERVER_TIMEOUT
+		MaxRequests int `envconfig:"SERVER_MAX_REQUESTS" yaml:"max_requests"`
+		// transport timeouts, 0 for none
+		ConnectTimeout  time.Duration `envconfig:"SERVER_CONNECT_TIMEOUT" yaml:"connect_timeout"`
+		TlsTimeout      time.Duration `envconfig:"SERVER_TLS_TIMEOUT" yaml:"tls_timeout"`
+		ResponseTimeout time.Duration `envconfig:"SERVER_RESPONSE_TIMEOUT" yaml:"response_timeout"` // after the request was sent
+		IdleTimeout     time.Duration `envconfig:"SERVER_IDLE_TIMEOUT" yaml:"idle_timeout"`         // of kept-alive connections
+		Timeout         time.Duration `envconfig:"SERVER_TIMEOUT"`                                  // whole request including the body
+		// use HTTP/2 with servers offering it, uploads then share a
+		// connection
+		Http2 bool `envconfig:"SERVER_HTTP2" yaml:"http2"`
+	} `yaml:"server"`
+	Heic struct {
+		Exec string `envconfig:"HEIC_EXEC"` // heif-convert of libheif
+	} `yaml:"heic"`
+	Rclone struct {
+		Exec   string `envconfig:"RCLONE_EXEC"`
+		Config string `envconfig:"RCLONE_CONFIG"` // rclone.conf, rclone's default if empty
+	} `yaml:"rclone"`
+	Nextcloud struct {
+		Tags     bool `envconfig:"NEXTCLOUD_TAGS"`     // apply document tags as system tags
+		Favorite bool `envconfig:"NEXTCLOUD_FAVORITE"` // mark every upload as favorite
+		// create a public share link of every upload, sent along with the
+		// notifications
+		Share         bool          `envconfig:"NEXTCLOUD_SHARE"`
+		SharePassword string        `envconfig:"NEXTCLOUD_SHARE_PASSWORD" yaml:"share_password"`
+		ShareExpire   time.Duration `envconfig:"NEXTCLOUD_SHARE_EXPIRE" yaml:"share_expire"` // never expires if 0
+	} `yaml:"nextcloud"`
+	Tls struct {
+		Ca       string `envconfig:"TLS_CA"`   // additional CA bundle
+		Cert     string `envconfig:"TLS_CERT"` // client certificate
+		Key      string `envconfig:"TLS_KEY"`
+		Insecure bool   `envconfig:"TLS_INSECURE"` // skip certificate verification
+	} `yaml:"tls"`
+	OAuth struct {
+		TokenUrl     string   `envconfig:"OAUTH_TOKEN_URL" yaml:"token_url"`
+		DeviceUrl    string   `envconfig:"OAUTH_DEVICE_URL" yaml:"device_url"` // device authorization endpoint of the login command
+		ClientId     string   `envconfig:"OAUTH_CLIENT_ID" yaml:"client_id"`
+		ClientSecret string   `envconfig:"OAUTH_CLIENT_SECRET" yaml:"client_secret"`
+		RefreshToken string   `envconfig:"OAUTH_REFRESH_TOKEN" yaml:"refresh_token"`
+		Scopes       []string `envconfig:"OAUTH_SCOPES"`
+	} `yaml:"oauth"`
+	Names struct {
+		Transliterate bool   `envconfig:"NAMES_TRANSLITERATE"` // reduce remote names to ASCII
+		Replace       string `envconfig:"NAMES_REPLACE"`       // replacement for invalid characters
+		Invalid       string `envconfig:"NAMES_INVALID"`       // characters not allowed in names
+	} `yaml:"names"`
+	Watcher struct {
+		Path    string   `envconfig:"WATCHER_PATH"`
+		Include []string `envconfig:"WATCHER_INCLUDE"` // name patterns, all files if empty
+		Exclude []string `envconfig:"WATCHER_EXCLUDE"`
+		Events  []string `envconfig:"WATCHER_EVENTS"` // close-write, moved-to, create, write or rename; per system if empty
+		// how long to wait for a missing path at the start
+		Wait     time.Duration `envconfig:"WATCHER_WAIT"`
+		Profiles []Profile     `yaml:"profiles" ignored:"true"`
+		// directory below which watches may be added through the API, none
+		// if empty
+		AddRoot string `envconfig:"WATCHER_ADD_ROOT" yaml:"add_root"`
+	} `yaml:"watcher"`
+	Pipeline struct {
+		Steps   []string      `envconfig:"PIPELINE_STEPS"`
+		Timeout time.Duration `envconfig:"PIPELINE_TIMEOUT"` // per step, 0 for none
+	} `yaml:"pipeline"`
+	Resample struct {
+		Dpi   int    `envconfig:"RESAMPLE_DPI"`   // scans far above are scaled down, 0 disables
+		Color string `envconfig:"RESAMPLE_COLOR"` // gray or bitonal, kept if empty
+		// gray level from which bitonal pixels are white, 0 finds it per page
+		Threshold int `envconfig:"RESAMPLE_THRESHOLD"`
+	} `yaml:"resample"`
+	Crop struct {
+		Enabled bool `envconfig:"CROP_ENABLED"` // cut dark scanner borders off the pages
+	} `yaml:"crop"`
+	// double-wide scans, two pages captured as one image
+	Spread struct {
+		Enabled bool   `envconfig:"SPREAD_ENABLED"` // split them into their pages
+		Gutter  string `envconfig:"SPREAD_GUTTER"`  // center, shadow or gap
+		Range   int    `envconfig:"SPREAD_RANGE"`   // percent of the width around the center searched for the gutter
+	} `yaml:"spread"`
+	Split struct {
+		Pages int   `envconfig:"SPLIT_PAGES"` // per part
+		Size  int64 `envconfig:"SPLIT_SIZE"`  // bytes per part
+	} `yaml:"split"`
+	Archive struct {
+		Path string `envconfig:"ARCHIVE_PATH"`
+		Dirs string `envconfig:"ARCHIVE_DIRS"` // folder template below the path
+		// purge copies older than Retention and the oldest above MaxSize
+		// bytes, 0 for no limit
+		Retention time.Duration `envconfig:"ARCHIVE_RETENTION"`
+		MaxSize   int64         `envconfig:"ARCHIVE_MAX_SIZE" yaml:"max_size"`
+	} `yaml:"archive"`
+	Preview struct {
+		Enabled bool   `envconfig:"PREVIEW_ENABLED"` // upload a thumbnail of the first page
+		Format  string `envconfig:"PREVIEW_FORMAT"`  // jpeg or png
+		Size    int    `envconfig:"PREVIEW_SIZE"`    // pixels on the longer edge
+		Path    string `envconfig:"PREVIEW_PATH"`    // collection template, next to the document if empty
+	} `yaml:"preview"`
+	// receipts mode, vendor, date and total of every document are added
+	// to a CSV file
+	Receipts struct {
+		Enabled bool   `envconfig:"RECEIPTS_ENABLED"`
+		File    string `envconfig:"RECEIPTS_FILE"`   // local CSV file
+		Remote  string `envconfig:"RECEIPTS_REMOTE"` // CSV file on the server, relative to the server URL
+	} `yaml:"receipts"`
+	Sidecar struct {
+		Enabled bool `envconfig:"SIDECAR_ENABLED"` // upload <name>.json with the metadata
+		// also upload the words and their positions on the pages, hocr as
+		// <name>.hocr or alto as <name>.xml; alto needs tesseract 4.1
+		Layout string `envconfig:"SIDECAR_LAYOUT" yaml:"layout"`
+	} `yaml:"sidecar"`
+	Barcode struct {
+		Enabled bool   `envconfig:"BARCODE_ENABLED"` // read barcodes on the first page
+		Pattern string `envconfig:"BARCODE_PATTERN"` // only matching values, the first group if any
+	} `yaml:"barcode"`
+	// marks of documents flagged for review, e.g. for a low OCR confidence
+	Review struct {
+		Suffix string `envconfig:"REVIEW_SUFFIX"` // appended to the filename
+		Tag    string `envconfig:"REVIEW_TAG"`    // added to the tags
+	} `yaml:"review"`
+	Stamp struct {
+		Text  string `envconfig:"STAMP_TEXT"`  // template, %p and %P are the page number and count
+		Image string `envconfig:"STAMP_IMAGE"` // image file stamped instead of the text
+		Desc  string `envconfig:"STAMP_DESC"`  // pdfcpu stamp description: position, font, color, ...
+		Pages string `envconfig:"STAMP_PAGES"` // pdfcpu page selection, e.g. 1 or 1-3, all if empty
+	} `yaml:"stamp"`
+	Quarantine struct {
+		// broken inputs are moved here, without they are just skipped
+		Path string `envconfig:"QUARANTINE_PATH"`
+	} `yaml:"quarantine"`
+	Clamav struct {
+		// clamd to scan the inputs with, host:port or the path of its socket
+		Address string `envconfig:"CLAMAV_ADDRESS"`
+	} `yaml:"clamav"`
+	Originals struct {
+		Mode  string `envconfig:"ORIGINALS_MODE"`  // delete, keep, move or trash
+		Path  string `envconfig:"ORIGINALS_PATH"`  // target of move
+		Dirs  string `envconfig:"ORIGINALS_DIRS"`  // folder template below the path
+		Trash string `envconfig:"ORIGINALS_TRASH"` // trash directory, below the state dir if empty
+		// how long trashed originals are kept
+		Retention time.Duration `envconfig:"ORIGINALS_RETENTION"`
+		// check the remote copies before the originals are removed
+		Verify bool `envconfig:"ORIGINALS_VERIFY"`
+		// interval for checking that deleted originals still have a
+		// remote copy, 0 disables
+		Check time.Duration `envconfig:"ORIGINALS_CHECK"`
+		// interval for trying again to dispose of originals that were
+		// locked or not writable
+		Retry time.Duration `envconfig:"ORIGINALS_RETRY"`
+	} `yaml:"originals"`
+	Ocr struct {
+		Exec     string `envconfig:"OCR_EXEC"`
+		Engine   string `envconfig:"OCR_ENGINE"` // local or docker
+		Image    string `envconfig:"OCR_IMAGE"`  // image used by the docker engine
+		Args     string `envconfig:"OCR_ARGS"`
+		Output   string `envconfig:"OCR_OUTPUT"`   // pdf, pdfa-1, pdfa-2, pdfa-3 or text, replaces --output-type
+		Language string `envconfig:"OCR_LANGUAGE"` // replaces -l in OCR_ARGS
+		Nice     int    `envconfig:"OCR_NICE"`
+		IoClass  int    `envconfig:"OCR_IONICE_CLASS" yaml:"ionice_class"` // 1 realtime, 2 best-effort, 3 idle
+		IoPrio   int    `envconfig:"OCR_IONICE_LEVEL" yaml:"ionice_level"`
+		CpuQuota string `envconfig:"OCR_CPU_QUOTA" yaml:"cpu_quota"` // e.g. 50%, needs systemd
+		// unprivileged user the OCR runs as, name or uid[:gid], needs root
+		// and a TMPDIR the user can enter; it only gets the variables of Env
+		// besides PATH, locale and tesseract ones
+		User string   `envconfig:"OCR_USER"`
+		Env  []string `envconfig:"OCR_ENV"`
+		// mean word confidence of tesseract, 0 to 100, below which documents
+		// are flagged for review; 0 doesn't measure it
+		MinConfidence float64 `envconfig:"OCR_MIN_CONFIDENCE" yaml:"min_confidence"`
+		// PDFs with more pages are OCRed in ranges of this many pages,
+		// checkpointed in the state directory; 0 OCRs them in one go
+		ChunkPages int `envconfig:"OCR_CHUNK_PAGES" yaml:"chunk_pages"`
+		// page ranges of a PDF OCRed at once, in ranges of OCR_CHUNK_PAGES
+		// or split evenly; 1 OCRs them one after the other
+		Parallel int `envconfig:"OCR_PARALLEL"`
+		// OCR processes running at once over all jobs, 0 for no limit
+		Processes int `envconfig:"OCR_PROCESSES"`
+	} `yaml:"ocr"`
+	Llm struct {
+		Url   string `envconfig:"LLM_URL"`
+		Key   string `envconfig:"LLM_KEY"`
+		Model string `envconfig:"LLM_MODEL"`
+	} `yaml:"llm"`
+	Queue struct {
+		Workers int `envconfig:"QUEUE_WORKERS"`
+		// separate workers for uploads, 0 uploads on the OCR workers
+		UploadWorkers int           `envconfig:"QUEUE_UPLOAD_WORKERS" yaml:"upload_workers"`
+		Aging         time.Duration `envconfig:"QUEUE_AGING"`
+		SizeStep      int64         `envconfig:"QUEUE_SIZE_STEP" yaml:"size_step"` // bytes per priority point
+	} `yaml:"queue"`
+	Limit struct {
+		Documents int   `envconfig:"LIMIT_DOCUMENTS"` // uploaded per hour, 0 for no limit
+		Bytes     int64 `envconfig:"LIMIT_BYTES"`     // uploaded per hour, 0 for no limit
+	} `yaml:"limit"`
+	Scan struct {
+		// scanner command run by POST /api/scan in an empty directory,
+		// e.g. scanimage --format=png -o page.png or
+		// scanadf -o page-%03d.pnm
+		Command string `envconfig:"SCAN_COMMAND"`
+	} `yaml:"scan"`
+	Escl struct {
+		// base URL of an eSCL (AirScan) scanner to scan from, e.g.
+		// https://scanner.local
+		Url        string        `envconfig:"ESCL_URL"`
+		Interval   time.Duration `envconfig:"ESCL_INTERVAL"` // for polling the feeder
+		Resolution int           `envconfig:"ESCL_RESOLUTION"`
+		ColorMode  string        `envconfig:"ESCL_COLOR_MODE" yaml:"color_mode"` // RGB24, Grayscale8 or BlackAndWhite1
+		Insecure   bool          `envconfig:"ESCL_INSECURE"`                     // skip certificate verification
+	} `yaml:"escl"`
+	Staple struct {
+		Marker string        `envconfig:"STAPLE_MARKER"`
+		Idle   time.Duration `envconfig:"STAPLE_IDLE"`
+	} `yaml:"staple"`
+	Duplex struct {
+		// time for scanning the backs after the fronts, 0 disables
+		Window time.Duration `envconfig:"DUPLEX_WINDOW"`
+	} `yaml:"duplex"`
+	Batch struct {
+		// regular expression of the names of page images forming one
+		// document, its first group is the page number and the rest of the
+		// name tells batches apart, e.g. ^IMG_(\d+)\.jpe?g$
+		Pattern string `envconfig:"BATCH_PATTERN"`
+		// most time between the pages of a batch, the batch is processed
+		// when this long no page came
+		Gap time.Duration `envconfig:"BATCH_GAP"`
+	} `yaml:"batch"`
+	Templates struct {
+		Timezone string `envconfig:"TEMPLATES_TIMEZONE"` // e.g. Europe/Berlin, the host's if empty
+		Locale   string `envconfig:"TEMPLATES_LOCALE"`   // month and weekday names of the date function
+	} `yaml:"templates"`
+	Hooks struct {
+		PreOcr     string `envconfig:"HOOK_PRE_OCR" yaml:"pre_ocr"`
+		PostOcr    string `envconfig:"HOOK_POST_OCR" yaml:"post_ocr"`
+		PostUpload string `envconfig:"HOOK_POST_UPLOAD" yaml:"post_upload"`
+	} `yaml:"hooks"`
+	Metadata struct {
+		Title    string `envconfig:"METADATA_TITLE"`
+		Author   string `envconfig:"METADATA_AUTHOR"`
+		Subject  string `envconfig:"METADATA_SUBJECT"`
+		Keywords string `envconfig:"METADATA_KEYWORDS"`
+		Device   string `envconfig:"METADATA_DEVICE"`
+	} `yaml:"metadata"`
+	Decrypt struct {
+		// passwords tried on encrypted input PDFs, comma separated
+		Passwords []string `envconfig:"DECRYPT_PASSWORDS"`
+	} `yaml:"decrypt"`
+	Encrypt struct {
+		User  string `envconfig:"ENCRYPT_USER_PASS" yaml:"user_pass"`
+		Owner string `envconfig:"ENCRYPT_OWNER_PASS" yaml:"owner_pass"`
+	} `yaml:"encrypt"`
+	Seal struct {
+		Age     []string `envconfig:"SEAL_AGE"`     // age recipients
+		Gpg     string   `envconfig:"SEAL_GPG"`     // file with armored GPG public keys
+		Sidecar bool     `envconfig:"SEAL_SIDECAR"` // upload unencrypted metadata JSON
+	} `yaml:"seal"`
+	Duplicates struct {
+		Mode     string `envconfig:"DUPLICATES_MODE"`     // skip or flag
+		Distance int    `envconfig:"DUPLICATES_DISTANCE"` // max image hash distance, 0 disables
+		// registry on the WebDAV server shared by instances watching
+		// mirrored folders, e.g. .scan2webdav/hashes.json
+		Shared string `envconfig:"DUPLICATES_SHARED"`
+	} `yaml:"duplicates"`
+	Coordination struct {
+		// collection on the WebDAV server for the locks of instances
+		// watching the same share, e.g. .scan2webdav/locks
+		Path    string        `envconfig:"COORDINATION_PATH"`
+		Timeout time.Duration `envconfig:"COORDINATION_TIMEOUT"` // refreshed while processing
+	} `yaml:"coordination"`
+	State struct {
+		Path string `envconfig:"STATE_PATH"`
+	} `yaml:"state"`
+	History struct {
+		Enabled bool `envconfig:"HISTORY_ENABLED"` // job history database in the state directory
+	} `yaml:"history"`
+	Search struct {
+		Enabled bool `envconfig:"SEARCH_ENABLED"` // full-text index in the state directory
+	} `yaml:"search"`
+	Elastic struct {
+		Url    string `envconfig:"ELASTIC_URL"` // Elasticsearch or OpenSearch
+		Index  string `envconfig:"ELASTIC_INDEX"`
+		User   string `envconfig:"ELASTIC_USER"`
+		Pass   string `envconfig:"ELASTIC_PASS"`
+		ApiKey string `envconfig:"ELASTIC_API_KEY" yaml:"api_key"`
+	} `yaml:"elastic"`
+	Retry struct {
+		Count   int           `envconfig:"RETRY_COUNT"`
+		Backoff time.Duration `envconfig:"RETRY_BACKOFF"` // doubled after every attempt
+		Status  []int         `envconfig:"RETRY_STATUS"`  // network errors are always retried
+		// interval for retrying uploads that still fail, 0 to give up
+		Pending time.Duration `envconfig:"RETRY_PENDING"`
+		// failed jobs per unchanged input before it is skipped, 0 for no limit
+		Attempts int `envconfig:"RETRY_ATTEMPTS"`
+	} `yaml:"retry"`
+	Http struct {
+		Listen    string `envconfig:"HTTP_LISTEN"`
+		Dashboard bool   `envconfig:"HTTP_DASHBOARD"`
+		Api       bool   `envconfig:"HTTP_API"`
+		Pprof     bool   `envconfig:"HTTP_PPROF"` // net/http/pprof below /debug/pprof/
+		Token     string `envconfig:"HTTP_TOKEN"`
+	} `yaml:"http"`
+	// remote collection or file share polled for new files, for scanners
+	// that can only write to a cloud folder or a share
+	Inbox struct {
+		Url      string        `envconfig:"INBOX_URL"`  // WebDAV collection or smb://host[:port]/share[/path]
+		User     string        `envconfig:"INBOX_USER"` // the SERVER_ credentials apply if empty, DOMAIN\user for SMB
+		Pass     string        `envconfig:"INBOX_PASS"`
+		Interval time.Duration `envconfig:"INBOX_INTERVAL"`
+		Move     string        `envconfig:"INBOX_MOVE"` // collection below the inbox the processed originals go to, deleted if empty
+	} `yaml:"inbox"`
+	// distributed mode, watcher instances hand their files to worker
+	// instances over a Redis list
+	Dispatch struct {
+		Role  string `envconfig:"DISPATCH_ROLE"`  // watcher or worker, empty processes locally
+		Redis string `envconfig:"DISPATCH_REDIS"` // redis://[user:pass@]host:port[/db], rediss:// for TLS
+		Queue string `envconfig:"DISPATCH_QUEUE"` // key of the list
+	} `yaml:"dispatch"`
+	Grpc struct {
+		Listen string `envconfig:"GRPC_LISTEN"` // e.g. :9090, HTTP_TOKEN applies
+	} `yaml:"grpc"`
+	Notify struct {
+		Webhook string `envconfig:"NOTIFY_WEBHOOK"`
+	} `yaml:"notify"`
+	// push providers, On is failed (default) or all
+	Ntfy struct {
+		Url   string `envconfig:"NTFY_URL"` // topic URL
+		Token string `envconfig:"NTFY_TOKEN"`
+		On    string `envconfig:"NTFY_ON"`
+	} `yaml:"ntfy"`
+	Gotify struct {
+		Url   string `envconfig:"GOTIFY_URL"`
+		Token string `envconfig:"GOTIFY_TOKEN"` // application token
+		On    string `envconfig:"GOTIFY_ON"`
+	} `yaml:"gotify"`
+	Telegram struct {
+		Token string `envconfig:"TELEGRAM_TOKEN"` // bot token
+		Chat  string `envconfig:"TELEGRAM_CHAT"`
+		On    string `envconfig:"TELEGRAM_ON"`
+	} `yaml:"telegram"`
+	Mqtt struct {
+		Broker    string `envconfig:"MQTT_BROKER"` // e.g. tcp://localhost:1883
+		User      string `envconfig:"MQTT_USER"`
+		Pass      string `envconfig:"MQTT_PASS"`
+		ClientId  string `envconfig:"MQTT_CLIENT_ID" yaml:"client_id"`
+		Topic     string `envconfig:"MQTT_TOPIC"`     // topic prefix
+		Discovery string `envconfig:"MQTT_DISCOVERY"` // Home Assistant discovery prefix, empty disables
+	} `yaml:"mqtt"`
+	Trace struct {
+		Endpoint string  `envconfig:"TRACE_ENDPOINT"` // OTLP/HTTP collector, empty disables tracing
+		Service  string  `envconfig:"TRACE_SERVICE"`
+		Ratio    float64 `envconfig:"TRACE_RATIO"` // share of jobs traced
+	} `yaml:"trace"`
+	Circuit struct {
+		// connection errors in a row after which uploads are held until the
+		// server answers again, 0 disables
+		Failures int           `envconfig:"CIRCUIT_FAILURES"`
+		Backoff  time.Duration `envconfig:"CIRCUIT_BACKOFF"` // interval for probing the server
+		Pause    bool          `envconfig:"CIRCUIT_PAUSE"`   // pause the pipeline too
+	} `yaml:"circuit"`
+	Alert struct {
+		Consecutive int     `envconfig:"ALERT_CONSECUTIVE"` // failures in a row, 0 disables
+		Rate        float64 `envconfig:"ALERT_RATE"`        // share of failures in the window, 0 disables
+		Window      int     `envconfig:"ALERT_WINDOW"`      // number of jobs the rate is computed over
+		Pause       bool    `envconfig:"ALERT_PAUSE"`       // pause the pipeline on alerts
+	} `yaml:"alert"`
+	Sentry struct {
+		Dsn         string `envconfig:"SENTRY_DSN"`
+		Environment string `envconfig:"SENTRY_ENVIRONMENT"`
+	} `yaml:"sentry"`
+	Report struct {
+		Interval string `envconfig:"REPORT_INTERVAL"` // daily or weekly, empty disables
+		At       string `envconfig:"REPORT_AT"`       // time of day
+	} `yaml:"report"`
+	// monthly export of the job history, for record keeping
+	Audit struct {
+		Path   string `envconfig:"AUDIT_PATH"`   // collection the exports are uploaded to, empty disables
+		Format string `envconfig:"AUDIT_FORMAT"` // csv or xlsx
+	} `yaml:"audit"`
+	Smtp struct {
+		Host string   `envconfig:"SMTP_HOST"` // host:port, mails are sent on failures if set
+		User string   `envconfig:"SMTP_USER"`
+		Pass string   `envconfig:"SMTP_PASS"`
+		From string   `envconfig:"SMTP_FROM"`
+		To   []string `envconfig:"SMTP_TO"`
+	} `yaml:"smtp"`
+	Disk struct {
+		// free space needed in the temp root and the watcher path before a job
+		// starts: Factor times the input size plus Reserve bytes
+		Factor  float64       `envconfig:"DISK_FACTOR"`
+		Reserve int64         `envconfig:"DISK_RESERVE"`
+		Wait    time.Duration `envconfig:"DISK_WAIT"` // interval for checking again
+	} `yaml:"disk"`
+	Shutdown struct {
+		Grace time.Duration `envconfig:"SHUTDOWN_GRACE"`
+	} `yaml:"shutdown"`
+	Log struct {
+		Level   string        `envconfig:"LOG_LEVEL"`
+		Format  string        `envconfig:"LOG_FORMAT"`
+		File    string        `envconfig:"LOG_FILE"`
+		MaxSize int64         `envconfig:"LOG_MAX_SIZE" yaml:"max_size"`
+		MaxAge  time.Duration `envconfig:"LOG_MAX_AGE" yaml:"max_age"`
+		Backups int           `envconfig:"LOG_BACKUPS"`
+		// local or a udp:// or tcp:// URL of a syslog server
+		Syslog   string `envconfig:"LOG_SYSLOG"`
+		Facility string `envconfig:"LOG_SYSLOG_FACILITY" yaml:"facility"`
+		JobPath  string `envconfig:"LOG_JOB_PATH" yaml:"job_path"`
+	} `yaml:"log"`
+	Rules   []Rule   `yaml:"rules" ignored:"true"`
+	Plugins []Plugin `yaml:"plugins" ignored:"true"` // external pipeline steps
+}
+
+func readFile(cfg *Config, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("unable to open config file: %w", err)
+	}
+	defer f.Close()
+
+	if err := yaml.NewDecoder(f).Decode(cfg); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %w", filename, err)
+	}
+	return nil
+}
+
+func readEnv(cfg *Config) error {
+	if err := envconfig.Process("", cfg); err != nil {
+		return fmt.Errorf("unable to read environment: %w", err)
+	}
+	return nil
+}
+
+// admit checks the originals of job id before it starts: they must still
+// be there and be what their names claim, no other instance may have them
+// and there must be disk space for them. If one of them fails, all are
+// released. It returns the types found by checkInput.
+func admit(ctx context.Context, cfg Config, id string, files []string) ([]string, bool) {
+	kinds := make([]string, len(files))
+	for i, inFile := range files {
+		if !exists(inFile) {
+			slog.Info("File disappeared before processing, skipping", "job_id", id, "file", inFile)
+			releaseAll(files)
+			return nil, false
+		}
+		kind, err := checkInput(inFile)
+		if err != nil {
+			quarantine(cfg, id, inFile, err)
+			releaseAll(files)
+			return nil, false
+		}
+		kinds[i] = kind
+	}
+	if cfg.Coordination.Path != "" {
+		for _, inFile := range files {
+			if err := lockInput(ctx, cfg, inFile); err != nil {
+				if errors.Is(err, errLockedElsewhere) {
+					slog.Info("File is processed by another instance, skipping", "job_id", id, "file", inFile)
+				} else {
+					slog.Error("Unable to lock file, skipping", "job_id", id, "file", inFile, "error", err)
+				}
+				releaseAll(files)
+				return nil, false
+			}
+		}
+	}
+	for _, inFile := range files {
+		slog.Info("Processing file", "job_id", id, "file", inFile)
+		if !waitForSpace(ctx, cfg, id, inFile) {
+			releaseAll(files)
+			return nil, false
+		}
+	}
+	return kinds, true
+}
+
+func processFile(ctx context.Context, cfg Config, id string, inFile string) {
+	kinds, ok := admit(ctx, cfg, id, []string{inFile})
+	if !ok {
+		return
+	}
+
+	// Create temp dir & file
+	tempDir, err := newTempDir(cfg)
+	if err != nil {
+		slog.Error("Unable to create temp directory, skipping", "job_id", id, "file", inFile, "error", err)
+		release(inFile)
+		return
+	}
+	slog.Debug("Temp directory created", "job_id", id, "file", inFile, "path", tempDir)
+
+	job := newJob(ctx, cfg, id, inFile, tempDir)
+	if err := job.retype(kinds[0]); err != nil {
+		job.Warn("Unable to copy misnamed file, processing it as it is", "error", err)
+	}
+	runJob(job)
+}
+
+// processGroup runs the files of enqueueGroup as one job. The originals
+// are removed together after the upload.
+func processGroup(ctx context.Context, cfg Config, id string, files []string, merge func(id string, tempDir string) (string, error)) {
+	if _, ok := admit(ctx, cfg, id, files); !ok {
+		return
+	}
+	tempDir, err := newTempDir(cfg)
+	if err != nil {
+		slog.Error("Unable to create temp directory, skipping", "job_id", id, "files", files, "error", err)
+		releaseAll(files)
+		return
+	}
+	slog.Debug("Temp directory created", "job_id", id, "path", tempDir)
+
+	out, err := merge(id, tempDir)
+	if err != nil {
+		slog.Error("Unable to combine the files, skipping", "job_id", id, "files", files, "error", err)
+		os.RemoveAll(tempDir)
+		releaseAll(files)
+		return
+	}
+	job := newJob(ctx, cfg, id, files[0], tempDir)
+	job.Files = []string{out}
+	job.Sources = files
+	runJob(job)
+}
+
+func runJob(job *Job) {
+	tempDir := job.TempDir
+	job.openLog()
+	defer job.closeLog()
+
+	if history != nil && job.Doc.Hash == "" {
+		if sum, err := fileHash(job.Input); err == nil {
+			job.Doc.Hash = sum
+		}
+	}
+
+	// cancelled, handed off and pending jobs keep their journal entry and
+	// temp files, so they can be resumed on the next start
+	err := job.run()
+	if errors.Is(err, errHandoff) {
+		queueUpload(job)
+		return
+	}
+	if job.ctx.Err() != nil {
+		if journal == nil {
+			job.Warn("Job cancelled")
+			job.finish("cancelled", err)
+			releaseAll(job.Sources)
+			removeTempDir(tempDir)
+			return
+		}
+		job.Warn("Job cancelled, keeping temp directory to resume later", "path", tempDir)
+		endSpan(job.span, err)
+		return
+	}
+	if job.State == stateUploading && job.Cfg.Retry.Pending > 0 && retryable(job.ctx, job.Cfg, err) {
+		job.Warn("Upload failed, trying again later", "error", err, "path", tempDir, "retry_in", job.Cfg.Retry.Pending)
+		job.State = stateUploadPending
+		job.checkpoint()
+		observeFailure(job.Cfg, true, err)
+		time.AfterFunc(job.Cfg.Retry.Pending, func() {
+			// after a shutdown the job is resumed from the journal
+			if job.ctx.Err() == nil {
+				queueUpload(job)
+			}
+		})
+		return
+	}
+	defer releaseAll(job.Sources)
+	journal.remove(job)
+	if errors.Is(err, errVanished) {
+		job.Warn("Input disappeared during processing, skipping", "status", "skipped")
+		jobResults.inc("skipped")
+		job.finish("skipped", nil)
+		job.Debug("Removing temp directory", "path", tempDir)
+		removeTempDir(tempDir)
+		return
+	}
+	if errors.Is(err, errInfected) || errors.Is(err, errEncrypted) {
+		for _, src := range job.Sources {
+			quarantine(job.Cfg, job.ID, src, err)
+		}
+		job.finish("quarantined", err)
+		if err := history.record(job, "quarantined", err); err != nil {
+			job.Warn("Unable to record job history", "error", err)
+		}
+		removeTempDir(tempDir)
+		return
+	}
+	status := "done"
+	if err != nil {
+		status = "failed"
+		job.Error("Job failed", "error", err, "status", status)
+		reportJobError(job, err)
+		if err := job.rememberFailure(err); err != nil {
+			job.Warn("Unable to remember failed file", "error", err)
+		}
+		if job.claimed {
+			if err := releaseShared(context.WithoutCancel(job.ctx), job.Cfg, job.Doc.Hash); err != nil {
+				job.Warn("Unable to release the document in the shared registry", "error", err)
+			}
+		}
+	} else {
+		job.Info("Job finished successfully", "status", status)
+		failures.forget(job.Input)
+		disposeInbox(job)
+		indexJob(job)
+		shipToElastic(job)
+	}
+	jobResults.inc(status)
+	observeFailure(job.Cfg, err != nil, err)
+	job.finish(status, err)
+	if err := history.record(job, status, err); err != nil {
+		job.Warn("Unable to record job history", "error", err)
+	}
+	notifyJob(job, status, err)
+	job.Debug("Removing temp directory", "path", tempDir)
+	removeTempDir(tempDir)
+}
+
+// LoadConfig returns the defaults overridden by CONFIG_FILE and the
+// environment.
+func LoadConfig() (Config, error) {
+	cfg := defaultConfig()
+	if filename := os.Getenv("CONFIG_FILE"); filename != "" {
+		if err := readFile(&cfg, filename); err != nil {
+			return cfg, err
+		}
+	}
+	if err := readEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func defaultConfig() Config {
+	var cfg Config
+	// ocrmypdf defaults
+	cfg.Ocr.Exec, _ = exec.LookPath("ocrmypdf")
+	cfg.Ocr.Args = "--pdf-renderer sandwich --tesseract-timeout 1800 --rotate-pages -l eng+deu --deskew --clean --skip-text"
+	cfg.Ocr.Engine = "local"
+	cfg.Ocr.Image = "jbarlow83/ocrmypdf"
+	cfg.Pipeline.Steps = defaultSteps
+	cfg.Watcher.Wait = 10 * time.Minute
+	cfg.Escl.Interval = 5 * time.Second
+	cfg.Escl.Resolution = 300
+	cfg.Escl.ColorMode = "RGB24"
+	cfg.Archive.Dirs = `{{.Time.Format "2006/01"}}`
+	cfg.Stamp.Desc = "font:Helvetica, points:10, pos:tr, off:-20 -20, scale:1 abs, rot:0, fillc:#C00000"
+	cfg.Spread.Gutter = "center"
+	cfg.Spread.Range = 10
+	cfg.Preview.Format = "jpeg"
+	cfg.Review.Suffix = "_review"
+	cfg.Review.Tag = "needs review"
+	cfg.Preview.Size = 400
+	cfg.Originals.Mode = "delete"
+	cfg.Originals.Dirs = `{{.Time.Format "2006/01"}}`
+	cfg.Originals.Verify = true
+	cfg.Originals.Check = 24 * time.Hour
+	cfg.Originals.Retention = 7 * 24 * time.Hour
+	cfg.Originals.Retry = 5 * time.Minute
+	cfg.Coordination.Timeout = 10 * time.Minute
+	cfg.Batch.Gap = 30 * time.Second
+	cfg.State.Path = defaultStateDir()
+	cfg.Ocr.Parallel = 1
+	cfg.Ocr.Processes = runtime.NumCPU()
+	cfg.Queue.Workers = 2
+	cfg.Queue.UploadWorkers = 4
+	cfg.Queue.Aging = time.Minute
+	cfg.Queue.SizeStep = 10 << 20
+	cfg.Shutdown.Grace = 30 * time.Second
+	cfg.Disk.Factor = 4
+	cfg.Disk.Reserve = 100 << 20
+	cfg.Disk.Wait = time.Minute
+	cfg.Server.Conflict = "overwrite"
+	cfg.Server.ConflictName = "{{.Name}}-{{.N}}"
+	cfg.Names.Replace = "_"
+	cfg.Names.Invalid = `\:*?"<>|`
+	cfg.Rclone.Exec = "rclone"
+	cfg.Heic.Exec = "heif-convert"
+	cfg.Server.ChunkSize = 10 << 20
+	cfg.Server.QuotaWait = 5 * time.Minute
+	cfg.Server.ConnectTimeout = 30 * time.Second
+	cfg.Server.TlsTimeout = 10 * time.Second
+	cfg.Server.ResponseTimeout = 2 * time.Minute
+	cfg.Server.IdleTimeout = 90 * time.Second
+	cfg.Server.Timeout = 30 * time.Minute
+	cfg.Server.Http2 = true
+	cfg.Retry.Count = 3
+	cfg.Retry.Backoff = 5 * time.Second
+	cfg.Retry.Pending = 5 * time.Minute
+	cfg.Retry.Attempts = 3
+	cfg.Retry.Status = []int{423, 500, 502, 503, 504}
+	cfg.Circuit.Failures = 5
+	cfg.Circuit.Backoff = time.Minute
+	cfg.Ntfy.On = "failed"
+	cfg.Gotify.On = "failed"
+	cfg.Telegram.On = "failed"
+	cfg.Mqtt.ClientId = defaultClientId()
+	cfg.Mqtt.Topic = "scan2webdav"
+	cfg.Trace.Service = "scan2webdav"
+	cfg.Trace.Ratio = 1
+	cfg.Log.Level = "info"
+	cfg.Log.Format = "text"
+	cfg.Log.MaxSize = 10 << 20
+	cfg.Log.Backups = 5
+	cfg.Log.Facility = "daemon"
+	cfg.History.Enabled = true
+	cfg.Elastic.Index = "scan2webdav"
+	cfg.Report.At = "07:00"
+	cfg.Audit.Format = "csv"
+	cfg.Dispatch.Queue = "scan2webdav:jobs"
+	cfg.Inbox.Interval = time.Minute
+	cfg.Alert.Consecutive = 5
+	cfg.Alert.Window = 20
+	return cfg
+}
+
+// defaultStateDir follows the conventions of the system.
+func defaultStateDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			dir = `C:\ProgramData`
+		}
+		return filepath.Join(dir, "scan2webdav")
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Application Support", "scan2webdav")
+		}
+	}
+	return "/var/lib/scan2webdav"
+}
+
+// checkConfig validates cfg and fills in the defaults that depend on other
+// settings.
+func checkConfig(cfg *Config) error {
+	if cfg.Ocr.Engine != "local" && cfg.Ocr.Engine != "docker" {
+		return fmt.Errorf("invalid OCR engine %q", cfg.Ocr.Engine)
+	}
+	if err := ocr.CheckUser(ocrOptions(*cfg)); err != nil {
+		return fmt.Errorf("invalid OCR user %q: %w", cfg.Ocr.User, err)
+	}
+	switch cfg.Ocr.Output {
+	case "", "pdf", "pdfa-1", "pdfa-2", "pdfa-3", "text":
+	default:
+		return fmt.Errorf("invalid OCR output %q", cfg.Ocr.Output)
+	}
+	if _, ok := layoutExts[cfg.Sidecar.Layout]; cfg.Sidecar.Layout != "" && !ok {
+		return fmt.Errorf("invalid sidecar layout %q", cfg.Sidecar.Layout)
+	}
+	if cfg.Ocr.ChunkPages < 0 {
+		return fmt.Errorf("invalid OCR chunk pages %d", cfg.Ocr.ChunkPages)
+	}
+	if cfg.Ocr.Parallel < 1 {
+		return fmt.Errorf("invalid OCR parallel %d", cfg.Ocr.Parallel)
+	}
+	if cfg.Ocr.Processes < 0 {
+		return fmt.Errorf("invalid OCR processes %d", cfg.Ocr.Processes)
+	}
+	switch cfg.Originals.Mode {
+	case "delete":
+	case "keep":
+		if cfg.Duplicates.Mode != "skip" {
+			slog.Warn("Kept originals are processed again on restart unless DUPLICATES_MODE is skip")
+		}
+	case "move":
+		if cfg.Originals.Path == "" {
+			return errors.New("ORIGINALS_PATH is required to move originals")
+		}
+	case "trash":
+		if cfg.Originals.Trash == "" {
+			cfg.Originals.Trash = filepath.Join(cfg.State.Path, "trash")
+		}
+	default:
+		return fmt.Errorf("invalid originals mode %q", cfg.Originals.Mode)
+	}
+	if cfg.Archive.Retention < 0 || cfg.Archive.MaxSize < 0 {
+		return errors.New("invalid archive retention")
+	}
+	if cfg.Originals.Retry <= 0 {
+		return fmt.Errorf("invalid originals retry interval %s", cfg.Originals.Retry)
+	}
+	switch cfg.Server.Conflict {
+	case "overwrite", "skip", "rename", "timestamp":
+	default:
+		return fmt.Errorf("invalid conflict strategy %q", cfg.Server.Conflict)
+	}
+	if _, err := watcher.Events(cfg.Watcher.Events); err != nil {
+		return fmt.Errorf("invalid watcher events: %w", err)
+	}
+	if _, err := regexp.Compile(cfg.Barcode.Pattern); err != nil {
+		return fmt.Errorf("invalid barcode pattern: %w", err)
+	}
+	if err := validateStamp(*cfg); err != nil {
+		return fmt.Errorf("invalid stamp: %w", err)
+	}
+	if len(cfg.Seal.Age) > 0 || cfg.Seal.Gpg != "" {
+		if _, _, err := sealer(*cfg); err != nil {
+			return fmt.Errorf("invalid seal keys: %w", err)
+		}
+	}
+	if cfg.Preview.Format != "jpeg" && cfg.Preview.Format != "png" {
+		return fmt.Errorf("invalid preview format %q", cfg.Preview.Format)
+	}
+	if cfg.Preview.Enabled && cfg.Preview.Size <= 0 {
+		return fmt.Errorf("invalid preview size %d", cfg.Preview.Size)
+	}
+	switch cfg.Server.Checksum {
+	case "", "etag", "head":
+	default:
+		return fmt.Errorf("invalid checksum mode %q", cfg.Server.Checksum)
+	}
+	if cfg.Smtp.Host != "" && len(cfg.Smtp.To) == 0 {
+		return errors.New("SMTP_TO is required to send mails")
+	}
+	for _, on := range []string{cfg.Ntfy.On, cfg.Gotify.On, cfg.Telegram.On} {
+		if on != "failed" && on != "all" {
+			return fmt.Errorf("invalid notification events %q", on)
+		}
+	}
+	if cfg.Disk.Wait <= 0 {
+		return fmt.Errorf("invalid disk space check interval %s", cfg.Disk.Wait)
+	}
+	if cfg.Circuit.Failures > 0 && cfg.Circuit.Backoff <= 0 {
+		return fmt.Errorf("invalid circuit backoff %s", cfg.Circuit.Backoff)
+	}
+	if cfg.Server.Quota && cfg.Server.QuotaWait <= 0 {
+		return fmt.Errorf("invalid quota check interval %s", cfg.Server.QuotaWait)
+	}
+	if cfg.Escl.Url != "" && cfg.Escl.Interval <= 0 {
+		return fmt.Errorf("invalid scanner polling interval %s", cfg.Escl.Interval)
+	}
+	if cfg.Alert.Rate > 0 && cfg.Alert.Window <= 0 {
+		return fmt.Errorf("invalid alert window %d", cfg.Alert.Window)
+	}
+	switch cfg.Report.Interval {
+	case "", "daily", "weekly":
+	default:
+		return fmt.Errorf("invalid report interval %q", cfg.Report.Interval)
+	}
+	if _, err := parseReportTime(cfg.Report.At); err != nil {
+		return fmt.Errorf("invalid report time %q", cfg.Report.At)
+	}
+	if cfg.Inbox.Url != "" {
+		if !strings.HasPrefix(cfg.Inbox.Url, "http://") && !strings.HasPrefix(cfg.Inbox.Url, "https://") && !strings.HasPrefix(cfg.Inbox.Url, "smb://") {
+			return fmt.Errorf("invalid inbox URL %q", cfg.Inbox.Url)
+		}
+		if _, err := newInboxSource(*cfg); err != nil {
+			return fmt.Errorf("invalid inbox URL: %w", err)
+		}
+		if cfg.Inbox.Interval < time.Second {
+			return fmt.Errorf("invalid inbox interval %s", cfg.Inbox.Interval)
+		}
+	}
+	switch cfg.Dispatch.Role {
+	case "":
+	case "watcher", "worker":
+		if cfg.Dispatch.Redis == "" {
+			return fmt.Errorf("DISPATCH_ROLE %s requires DISPATCH_REDIS", cfg.Dispatch.Role)
+		}
+	default:
+		return fmt.Errorf("invalid dispatch role %q", cfg.Dispatch.Role)
+	}
+	if cfg.Audit.Format != "csv" && cfg.Audit.Format != "xlsx" {
+		return fmt.Errorf("invalid audit format %q", cfg.Audit.Format)
+	}
+	if cfg.Server.ChunkThreshold > 0 && cfg.Server.ChunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", cfg.Server.ChunkSize)
+	}
+	if err := registerPlugins(cfg.Plugins); err != nil {
+		return err
+	}
+	if err := validateSteps(cfg.Pipeline.Steps); err != nil {
+		return fmt.Errorf("invalid pipeline: %w", err)
+	}
+	if err := validateOcrOutput(*cfg, cfg.Pipeline.Steps); err != nil {
+		return fmt.Errorf("invalid pipeline: %w", err)
+	}
+	for _, p := range append(cfg.Watcher.Include, cfg.Watcher.Exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid watcher pattern %q: %w", p, err)
+		}
+	}
+	for _, p := range cfg.Watcher.Profiles {
+		if err := validateSteps(p.Steps); err != nil {
+			return fmt.Errorf("invalid pipeline in profile %s: %w", p.Name, err)
+		}
+		if err := validateOcrOutput(*cfg, p.Steps); err != nil {
+			return fmt.Errorf("invalid pipeline in profile %s: %w", p.Name, err)
+		}
+	}
+	if err := compileProfiles(cfg.Watcher.Profiles); err != nil {
+		return fmt.Errorf("invalid profile pattern: %w", err)
+	}
+	if err := setupTemplates(*cfg); err != nil {
+		return err
+	}
+	if err := compileRules(cfg.Rules); err != nil {
+		return fmt.Errorf("unable to parse rules: %w", err)
+	}
+	switch cfg.Duplicates.Mode {
+	case "", "skip", "flag":
+	default:
+		return fmt.Errorf("invalid duplicates mode %q", cfg.Duplicates.Mode)
+	}
+	colors := []string{cfg.Resample.Color}
+	for _, p := range cfg.Watcher.Profiles {
+		colors = append(colors, p.Color)
+	}
+	for _, c := range colors {
+		switch c {
+		case "", "gray", "bitonal":
+		default:
+			return fmt.Errorf("invalid color %q", c)
+		}
+	}
+	if cfg.Resample.Threshold < 0 || cfg.Resample.Threshold > 255 {
+		return fmt.Errorf("invalid resample threshold %d", cfg.Resample.Threshold)
+	}
+	if cfg.Receipts.Remote != "" && !strings.HasPrefix(cfg.Server.Url, "http://") && !strings.HasPrefix(cfg.Server.Url, "https://") {
+		return errors.New("RECEIPTS_REMOTE requires a WebDAV server")
+	}
+	switch cfg.Spread.Gutter {
+	case "center", "shadow", "gap":
+	default:
+		return fmt.Errorf("invalid spread gutter %q", cfg.Spread.Gutter)
+	}
+	if cfg.Spread.Range < 0 || cfg.Spread.Range > 40 {
+		return fmt.Errorf("invalid spread range %d", cfg.Spread.Range)
+	}
+	if cfg.Duplex.Window > 0 && stapleEnabled(*cfg) {
+		return errors.New("DUPLEX_WINDOW can't be combined with stapling")
+	}
+	if cfg.Batch.Pattern != "" {
+		re, err := regexp.Compile(cfg.Batch.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid batch pattern: %w", err)
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("invalid batch pattern %q: no group for the page number", cfg.Batch.Pattern)
+		}
+		if cfg.Batch.Gap <= 0 {
+			return fmt.Errorf("invalid batch gap %s", cfg.Batch.Gap)
+		}
+		if cfg.Duplex.Window > 0 || stapleEnabled(*cfg) {
+			return errors.New("BATCH_PATTERN can't be combined with stapling or DUPLEX_WINDOW")
+		}
+	}
+	if cfg.Coordination.Path != "" {
+		if !strings.HasPrefix(cfg.Server.Url, "http://") && !strings.HasPrefix(cfg.Server.Url, "https://") {
+			return errors.New("COORDINATION_PATH requires a WebDAV server")
+		}
+		if cfg.Coordination.Timeout < 10*time.Second {
+			return fmt.Errorf("invalid coordination timeout %s", cfg.Coordination.Timeout)
+		}
+	}
+	if cfg.Duplicates.Shared != "" {
+		if cfg.Duplicates.Mode == "" {
+			return errors.New("DUPLICATES_SHARED requires DUPLICATES_MODE")
+		}
+		if !strings.HasPrefix(cfg.Server.Url, "http://") && !strings.HasPrefix(cfg.Server.Url, "https://") {
+			return errors.New("DUPLICATES_SHARED requires a WebDAV server")
+		}
+	}
+	return nil
+}
+
+// Run runs the daemon until ctx is done, then it stops watching and waits
+// for the running jobs as long as SHUTDOWN_GRACE allows, see Abort. Logging
+// is left to the caller, see SetupLogging. The daemon keeps its state in
+// package variables, so only one Run can be active at a time; once it
+// returned, Run can be called again.
+func Run(ctx context.Context, cfg Config) error {
+	if err := checkConfig(&cfg); err != nil {
+		return err
+	}
+
+	if cfg.Duplicates.Mode != "" {
+		if err := os.MkdirAll(cfg.State.Path, 0700); err != nil {
+			return fmt.Errorf("unable to create state directory: %w", err)
+		}
+		store, err := loadHashStore(cfg.State.Path)
+		if err != nil {
+			return fmt.Errorf("unable to load document hashes: %w", err)
+		}
+		hashes = store
+	}
+
+	// replace template patterns ( {{.User}} ) in URL
+	url, err := expand(cfg.Server.Url, cfg.Server)
+	if err != nil {
+		return fmt.Errorf("unable to parse url: %w", err)
+	}
+	cfg.Server.Url = url
+	slog.Info("Upload target", "url", cfg.Server.Url)
+	if err := setupClient(cfg); err != nil {
+		return fmt.Errorf("unable to set up the HTTP client: %w", err)
+	}
+	if cfg.Tls.Insecure {
+		slog.Warn("TLS certificate verification is disabled")
+	}
+	setupOAuth(cfg)
+	if cfg.Ocr.Processes > 0 {
+		ocrSlots = make(chan struct{}, cfg.Ocr.Processes)
+	}
+
+	// Every way out of Run goes through this, so Run can start over. New
+	// events are refused first, then the running jobs are waited for.
+	var w *watcher.Watcher
+	started := false
+	defer func() {
+		if w != nil {
+			w.Stop()
+		}
+		stopAddedWatches()
+		setWatching(false)
+		if started {
+			shutdown(cfg)
+		}
+		closeGRPC()
+		closeHTTP()
+		closeMQTT(cfg)
+		closeSearchIndex()
+		closeHistory()
+		journal = nil
+		if instanceLock != nil {
+			instanceLock.Close()
+			instanceLock = nil
+		}
+		cancelJobs()
+		forgetQueued()
+		closeTracing()
+		closeSentry()
+	}()
+	if err := setupTracing(cfg); err != nil {
+		return fmt.Errorf("unable to set up tracing: %w", err)
+	}
+	if err := setupSentry(cfg); err != nil {
+		return fmt.Errorf("unable to set up Sentry: %w", err)
+	}
+	defer reportPanic()
+	if err := checkOcrOutput(ctx, cfg); err != nil {
+		return err
+	}
+
+	watched, err := waitForDir(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err := lockWatchPath(cfg.Watcher.Path); err != nil {
+		return fmt.Errorf("unable to lock watcher path: %w", err)
+	}
+	jobCtx, cancelJobs = context.WithCancel(context.WithoutCancel(ctx))
+
+	queue, uploads = newJobQueue(cfg.Queue.Workers, cfg.Queue.Aging), nil
+	if cfg.Queue.UploadWorkers > 0 {
+		uploads = newJobQueue(cfg.Queue.UploadWorkers, cfg.Queue.Aging)
+	}
+	started = true
+	handle := func(path string) {
+		go enqueue(jobCtx, cfg, path, true)
+	}
+	sweep := func(path string) {
+		enqueue(jobCtx, cfg, path, false)
+	}
+	if stapleEnabled(cfg) {
+		s := newStapler(jobCtx, cfg)
+		handle, sweep = s.add, s.add
+	}
+	if cfg.Duplex.Window > 0 {
+		d := newDuplexer(jobCtx, cfg)
+		handle, sweep = d.add, d.add
+	}
+	if cfg.Batch.Pattern != "" {
+		b := newBatcher(jobCtx, cfg)
+		handle, sweep = b.add, b.add
+	}
+	// the sweep filters itself while walking the directory
+	live := handle
+	handle = func(path string) {
+		if watchedFile(cfg, path) {
+			live(path)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.State.Path, 0700); err != nil {
+		slog.Warn("Crash recovery disabled, unable to create state directory", "error", err)
+	} else {
+		if journal, err = openJournal(cfg.State.Path); err != nil {
+			slog.Warn("Crash recovery disabled", "error", err)
+		}
+		if failures, err = loadFailureStore(cfg.State.Path); err != nil {
+			slog.Warn("Unable to load failed files", "error", err)
+		}
+		if disposals, err = loadDisposalStore(cfg.State.Path); err != nil {
+			slog.Warn("Unable to load inputs to dispose of", "error", err)
+		}
+		if counters, err = loadCounterStore(cfg.State.Path); err != nil {
+			slog.Warn("Unable to load template counters", "error", err)
+		}
+		if cfg.History.Enabled {
+			if history, err = openHistory(cfg.State.Path); err != nil {
+				slog.Warn("Job history disabled", "error", err)
+			}
+		}
+		if cfg.Search.Enabled {
+			if searchIndex, err = openSearchIndex(cfg.State.Path); err != nil {
+				slog.Warn("Search index disabled", "error", err)
+			}
+		}
+	}
+	if err := setupHTTP(cfg); err != nil {
+		return err
+	}
+	if err := setupGRPC(cfg); err != nil {
+		return err
+	}
+	setupMQTT(cfg)
+	startReports(jobCtx, cfg)
+	startAudits(jobCtx, cfg)
+	startDispatchWorker(jobCtx, cfg)
+	startInbox(jobCtx, cfg)
+	startConsistencyCheck(jobCtx, cfg)
+	startTrashPurge(jobCtx, cfg)
+	startArchivePurge(jobCtx, cfg)
+	startDisposalRetry(jobCtx, cfg)
+	startEscl(jobCtx, cfg)
+	resumeJobs(jobCtx, cfg)
+	cleanTempDirs(jobCtx, cfg)
+
+	// Process existing files first
+	slog.Info("Processing old files first")
+	processDir(cfg, sweep)
+
+	slog.Info("Watching", "path", cfg.Watcher.Path)
+	if w, err = watcher.Watch(cfg.Watcher.Path, watchRecursive(cfg), cfg.Watcher.Events); err != nil {
+		return fmt.Errorf("unable to watch %s: %w", cfg.Watcher.Path, err)
+	}
+	setWatching(true)
+	startAddedWatches(cfg, handle)
+
+	check := time.NewTicker(mountCheck)
+	defer check.Stop()
+	for {
+		select {
+		case path := <-w.Events:
+			handle(path)
+		case <-check.C:
+			if !dirReplaced(cfg, watched) {
+				continue
+			}
+			slog.Warn("Watcher path was replaced, watching it again", "path", cfg.Watcher.Path)
+			w.Stop()
+			setWatching(false)
+			if w, watched, err = rewatch(ctx, cfg); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			setWatching(true)
+			processDir(cfg, sweep)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// sealMetadata is the part of the document that is uploaded unencrypted
+// with SEAL_SIDECAR, nothing of its content.
+type sealMetadata struct {
+	Filename string    `json:"filename"`
+	Time     time.Time `json:"time"`
+	Date     string    `json:"date,omitempty"`
+	Category string    `json:"category,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+// sealStep encrypts the documents for the SEAL_AGE recipients or the
+// SEAL_GPG public keys, so that the storage only ever sees ciphertext.
+// The files get a .age or .gpg extension.
+func sealStep(job *Job) error {
+	cfg := job.Cfg.Seal
+	if len(cfg.Age) == 0 && cfg.Gpg == "" {
+		return nil
+	}
+	seal, ext, err := sealer(job.Cfg)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(job.TempDir, "seal")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	var sidecars []string
+	for i, in := range job.Files {
+		out := filepath.Join(dir, filepath.Base(in)+ext)
+		if err := sealFile(seal, in, out); err != nil {
+			return err
+		}
+		job.Files[i] = out
+		if cfg.Sidecar {
+			name, err := writeSealMetadata(job, strings.TrimSuffix(out, ext))
+			if err != nil {
+				return err
+			}
+			sidecars = append(sidecars, name)
+		}
+	}
+	job.Files = append(job.Files, sidecars...)
+	return nil
+}
+
+// sealer returns the encrypting writer for the configured keys and the
+// extension of the encrypted files. age takes precedence.
+func sealer(cfg Config) (func(io.Writer) (io.WriteCloser, error), string, error) {
+	if len(cfg.Seal.Age) > 0 {
+		recipients, err := age.ParseRecipients(strings.NewReader(strings.Join(cfg.Seal.Age, "\n")))
+		if err != nil {
+			return nil, "", err
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return age.Encrypt(w, recipients...)
+		}, ".age", nil
+	}
+
+	f, err := os.Open(cfg.Seal.Gpg)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	keys, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(keys) == 0 {
+		return nil, "", errors.New("no public keys in " + cfg.Seal.Gpg)
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return openpgp.Encrypt(w, keys, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	}, ".gpg", nil
+}
+
+func sealFile(seal func(io.Writer) (io.WriteCloser, error), src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w, err := seal(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// writeSealMetadata writes the sidecar of the document next to the sealed
+// file, name.pdf gets name.json.
+func writeSealMetadata(job *Job, file string) (string, error) {
+	name := strings.TrimSuffix(file, filepath.Ext(file)) + ".json"
+	data, err := json.MarshalIndent(sealMetadata{
+		Filename: job.Doc.Filename,
+		Time:     job.Doc.Time,
+		Date:     job.Doc.Date,
+		Category: job.Doc.Category,
+		Tags:     job.Doc.Tags,
+		Hash:     job.Doc.Hash,
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return name, os.WriteFile(name, data, 0600)
+}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// searchIndex holds the OCR text of processed documents, nil unless
+// SEARCH_ENABLED is set.
+var searchIndex bleve.Index
+
+var errSearchDisabled = errors.New("search index is disabled")
+
+// indexedDoc is what gets indexed per document. The field names are the
+// ones used in queries, e.g. title:invoice.
+type indexedDoc struct {
+	Input         string   `json:"input"`
+	Filename      string   `json:"filename"`
+	Title         string   `json:"title,omitempty"`
+	Correspondent string   `json:"correspondent,omitempty"`
+	Category      string   `json:"category,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Date          string   `json:"date,omitempty"`
+	Time          string   `json:"time"`
+	URL           string   `json:"url,omitempty"` // target collection
+	Uploads       []string `json:"uploads,omitempty"`
+	Text          string   `json:"text"`
+}
+
+// searchHit is a matching document with the highlighted text fragments.
+type searchHit struct {
+	ID        string    `json:"id"`
+	Score     float64   `json:"score"`
+	Filename  string    `json:"filename"`
+	Title     string    `json:"title,omitempty"`
+	Time      time.Time `json:"time"`
+	Uploads   []string  `json:"uploads,omitempty"`
+	Fragments []string  `json:"fragments,omitempty"`
+}
+
+func searchIndexPath(stateDir string) string {
+	return filepath.Join(stateDir, "search.bleve")
+}
+
+func openSearchIndex(stateDir string) (bleve.Index, error) {
+	path := searchIndexPath(stateDir)
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		return bleve.New(path, bleve.NewIndexMapping())
+	}
+	return index, err
+}
+
+func closeSearchIndex() {
+	if searchIndex != nil {
+		searchIndex.Close()
+		searchIndex = nil
+	}
+}
+
+// indexJob adds the document of a finished job.
+func indexJob(job *Job) {
+	if searchIndex == nil {
+		return
+	}
+	id, doc := jobDocument(job)
+	if err := searchIndex.Index(id, doc); err != nil {
+		job.Warn("Unable to index document", "error", err)
+	}
+}
+
+// jobDocument returns the indexed form of the document of job. It is keyed
+// by the input hash, so processing a file again replaces its entry.
+func jobDocument(job *Job) (string, indexedDoc) {
+	id := job.Doc.Hash
+	if id == "" {
+		id = job.ID
+	}
+	return id, indexedDoc{
+		Input:         job.Input,
+		Filename:      job.Doc.Filename,
+		Title:         job.Doc.Title,
+		Correspondent: job.Doc.Correspondent,
+		Category:      job.Doc.Category,
+		Tags:          job.Doc.Tags,
+		Date:          job.Doc.Date,
+		Time:          job.Doc.Time.Format(time.RFC3339),
+		URL:           job.URL,
+		Uploads:       job.uploaded,
+		Text:          job.Doc.Text,
+	}
+}
+
+// search runs a query string query, see
+// https://blevesearch.com/docs/Query-String-Query/
+func search(index bleve.Index, q string, limit int) ([]searchHit, error) {
+	if index == nil {
+		return nil, errSearchDisabled
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewQueryStringQuery(q), limit, 0, false)
+	req.Fields = []string{"filename", "title", "time", "uploads"}
+	req.Highlight = bleve.NewHighlight()
+	req.Highlight.AddField("text")
+	res, err := index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]searchHit, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		hit := searchHit{
+			ID:        h.ID,
+			Score:     h.Score,
+			Filename:  fieldString(h.Fields["filename"]),
+			Title:     fieldString(h.Fields["title"]),
+			Uploads:   fieldStrings(h.Fields["uploads"]),
+			Fragments: h.Fragments["text"],
+		}
+		hit.Time, _ = time.Parse(time.RFC3339, fieldString(h.Fields["time"]))
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+func fieldString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// fieldStrings returns a stored array field, which comes back as a plain
+// string if it has one element.
+func fieldStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		s := make([]string, 0, len(v))
+		for _, e := range v {
+			s = append(s, fmt.Sprint(e))
+		}
+		return s
+	}
+	return nil
+}
+
+// searchHandler serves the q and limit parameters.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.FormValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	hits, err := search(searchIndex, r.FormValue("q"), limit)
+	switch {
+	case errors.Is(err, errSearchDisabled):
+		apiError(w, http.StatusNotFound, err)
+	case err != nil:
+		apiError(w, http.StatusBadRequest, err)
+	default:
+		writeJSONResponse(w, http.StatusOK, hits)
+	}
+}
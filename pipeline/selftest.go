@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"golang.org/x/net/webdav"
+)
+
+// stubOcr stands in for ocrmypdf: it copies the input, the second to last
+// argument, to the output.
+const stubOcr = `#!/bin/sh
+for arg; do in=$out; out=$arg; done
+exec cp "$in" "$out"
+`
+
+// selftestCommand pushes a generated scan through the default pipeline
+// into an in-process WebDAV server and checks the upload. Unless -ocr is
+// given, a stub replaces the OCR engine.
+func selftestCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	realOcr := flags.Bool("ocr", false, "use the configured OCR engine")
+	timeout := flags.Duration("timeout", 5*time.Minute, "give up after `duration`")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := selftest(cfg, *realOcr, *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, "selftest failed:", err)
+		return 1
+	}
+	fmt.Println("selftest passed")
+	return 0
+}
+
+func selftest(cfg Config, realOcr bool, timeout time.Duration) error {
+	dir, err := os.MkdirTemp(tempRoot, "scan2webdav-selftest-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	remote := webdav.NewMemFS()
+	srv := httptest.NewServer(&webdav.Handler{FileSystem: remote, LockSystem: webdav.NewMemLS()})
+	defer srv.Close()
+
+	test := defaultConfig()
+	test.Log = cfg.Log
+	test.Watcher.Path = filepath.Join(dir, "watch")
+	test.State.Path = filepath.Join(dir, "state")
+	test.Server.Url = srv.URL
+	if realOcr {
+		test.Ocr = cfg.Ocr
+	} else if runtime.GOOS == "windows" {
+		return errors.New("the stub OCR engine is a shell script, use -ocr")
+	} else {
+		test.Ocr.Exec = filepath.Join(dir, "ocrmypdf")
+		if err := os.WriteFile(test.Ocr.Exec, []byte(stubOcr), 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(test.Watcher.Path, 0700); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, test) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// moved in, so the daemon only sees the finished file
+	input := filepath.Join(test.Watcher.Path, "selftest.pdf")
+	sample := filepath.Join(dir, "sample.pdf")
+	if err := writeSample(sample); err != nil {
+		return fmt.Errorf("unable to create the sample scan: %w", err)
+	}
+	if err := os.Rename(sample, input); err != nil {
+		return fmt.Errorf("unable to create the sample scan: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-done:
+			done <- err
+			return fmt.Errorf("daemon stopped: %v", err)
+		case <-time.After(time.Second):
+		}
+		uploaded, err := findUpload(remote)
+		if err != nil {
+			return err
+		}
+		if uploaded == nil || exists(input) {
+			continue
+		}
+		if err := api.Validate(bytes.NewReader(uploaded), pdfConf()); err != nil {
+			return fmt.Errorf("uploaded file is no valid PDF: %w", err)
+		}
+		return nil
+	}
+	return errors.New("no upload within the timeout")
+}
+
+// writeSample writes a single page PDF with a blank image as the page.
+func writeSample(file string) error {
+	img := image.NewGray(image.Rect(0, 0, 850, 1100))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := api.ImportImages(nil, out, []io.Reader{&buf}, nil, pdfConf()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// findUpload returns the content of the first PDF on the test server.
+func findUpload(fsys webdav.FileSystem) ([]byte, error) {
+	ctx := context.Background()
+	var walk func(dir string) ([]byte, error)
+	walk = func(dir string) ([]byte, error) {
+		d, err := fsys.OpenFile(ctx, dir, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := d.Readdir(-1)
+		d.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range entries {
+			name := path.Join(dir, fi.Name())
+			if fi.IsDir() {
+				if data, err := walk(name); data != nil || err != nil {
+					return data, err
+				}
+				continue
+			}
+			if strings.EqualFold(path.Ext(name), ".pdf") {
+				return readAll(fsys, name)
+			}
+		}
+		return nil, nil
+	}
+	return walk("/")
+}
+
+func readAll(fsys webdav.FileSystem, name string) ([]byte, error) {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
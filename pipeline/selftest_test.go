@@ -0,0 +1,18 @@
+package pipeline
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestSelftest pushes a scan through the whole pipeline, as the selftest
+// command does, with the stub OCR engine.
+func TestSelftest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub OCR engine is a shell script")
+	}
+	if err := selftest(defaultConfig(), false, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// sentryEnabled is set if failures are reported to SENTRY_DSN.
+var sentryEnabled bool
+
+// Version is set at build time with -ldflags
+// "-X github.com/chbmuc/scan2webdav.Version=...".
+var Version = "dev"
+
+func setupSentry(cfg Config) error {
+	if cfg.Sentry.Dsn == "" {
+		return nil
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         cfg.Sentry.Dsn,
+		Environment: cfg.Sentry.Environment,
+		Release:     "scan2webdav@" + Version,
+		// the upload client knows about proxies and custom CAs
+		HTTPClient: client,
+	})
+	if err != nil {
+		return err
+	}
+	sentryEnabled = true
+	slog.Info("Reporting errors to Sentry")
+	return nil
+}
+
+// closeSentry sends the events that are still buffered.
+func closeSentry() {
+	if sentryEnabled {
+		sentry.Flush(5 * time.Second)
+		sentryEnabled = false
+	}
+}
+
+// reportPanic reports a panic to Sentry and panics again. It has to be
+// deferred.
+func reportPanic() {
+	if !sentryEnabled {
+		return
+	}
+	if r := recover(); r != nil {
+		sentry.CurrentHub().Recover(r)
+		sentry.Flush(5 * time.Second)
+		panic(r)
+	}
+}
+
+// reportJobError sends a job failure to Sentry along with what is known
+// about the job.
+func reportJobError(job *Job, err error) {
+	if !sentryEnabled {
+		return
+	}
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("job_id", job.ID)
+		scope.SetTag("state", job.State)
+		if job.next < len(job.Cfg.Pipeline.Steps) {
+			scope.SetTag("step", job.Cfg.Pipeline.Steps[job.next])
+		}
+		scope.SetTag("ocr_engine", ocrEngine(job.Cfg))
+		scope.SetContext("job", sentry.Context{
+			"file":   filepath.Base(job.Input),
+			"target": job.URL,
+			"output": job.lastOutput,
+		})
+		sentry.CaptureException(err)
+	})
+}
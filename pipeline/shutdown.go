@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// jobCtx is the context of the jobs. Run derives it from its own, without
+// the cancellation: it is cancelled by cancelJobs when running jobs have
+// to be aborted on shutdown, and when Run returns.
+var jobCtx, cancelJobs = context.WithCancel(context.Background())
+
+// sleep waits for d and reports false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tempDirs holds the temp directories of the jobs that aren't finished.
+var tempDirs = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+func trackTempDir(dir string) {
+	tempDirs.Lock()
+	defer tempDirs.Unlock()
+	tempDirs.paths[dir] = true
+}
+
+func removeTempDir(dir string) {
+	tempDirs.Lock()
+	defer tempDirs.Unlock()
+	delete(tempDirs.paths, dir)
+	os.RemoveAll(dir)
+}
+
+// Abort cancels the running jobs during a shutdown instead of waiting for
+// the rest of the grace period, e.g. on a second signal.
+func Abort() {
+	cancelJobs()
+}
+
+// shutdown stops the queue and waits for the running jobs. After the grace
+// period, or on Abort, they are cancelled. Cancelled jobs keep
+// their journal entry and temp files and are resumed on the next start;
+// without a journal their temp files are removed.
+func shutdown(cfg Config) {
+	slog.Info("Shutting down, waiting for running jobs", "grace", cfg.Shutdown.Grace)
+	queue.stop()
+
+	done := make(chan struct{})
+	go func() {
+		queue.wait()
+		// jobs may have been handed to the upload workers until now
+		if uploads != nil {
+			uploads.stop()
+			uploads.wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(cfg.Shutdown.Grace):
+		slog.Warn("Grace period expired, cancelling running jobs")
+		cancelJobs()
+		<-done
+	case <-jobCtx.Done():
+		slog.Warn("Shutdown aborted, cancelling running jobs")
+		<-done
+	}
+
+	if journal == nil {
+		tempDirs.Lock()
+		dirs := make([]string, 0, len(tempDirs.paths))
+		for dir := range tempDirs.paths {
+			dirs = append(dirs, dir)
+		}
+		tempDirs.Unlock()
+		for _, dir := range dirs {
+			slog.Debug("Removing temp directory", "path", dir)
+			removeTempDir(dir)
+		}
+	}
+	slog.Info("Shutdown complete")
+}
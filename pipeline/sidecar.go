@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+)
+
+// sidecar is the metadata uploaded next to a document for downstream
+// tooling.
+type sidecar struct {
+	Source        string    `json:"source"` // base name of the input file
+	Scanned       time.Time `json:"scanned"`
+	OcrEngine     string    `json:"ocr_engine,omitempty"`
+	OcrDuration   float64   `json:"ocr_duration,omitempty"` // seconds
+	Language      string    `json:"language,omitempty"`
+	Confidence    float64   `json:"confidence,omitempty"`
+	Sha256        string    `json:"sha256"` // of the uploaded file
+	Pages         int       `json:"pages"`
+	Title         string    `json:"title,omitempty"`
+	Correspondent string    `json:"correspondent,omitempty"`
+	Date          string    `json:"date,omitempty"`
+	Category      string    `json:"category,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	Barcodes      []string  `json:"barcodes,omitempty"`
+	Review        bool      `json:"review,omitempty"`
+}
+
+// uploadSidecar uploads <name>.json next to target, which file was
+// uploaded to. Like previews, failing sidecars are only logged.
+func uploadSidecar(job *Job, store storage.Storage, file string, target string) {
+	if !job.Cfg.Sidecar.Enabled || strings.EqualFold(filepath.Ext(file), ".json") {
+		return
+	}
+	if _, ok := store.(*storage.Paperless); ok {
+		return
+	}
+	sum, err := fileHash(file)
+	if err != nil {
+		job.Warn("Unable to create sidecar", "path", file, "error", err)
+		return
+	}
+	doc := job.Doc
+	s := sidecar{
+		Source:        doc.Filename,
+		Scanned:       doc.Time,
+		Language:      doc.Language,
+		Confidence:    doc.Confidence,
+		Sha256:        sum,
+		Pages:         pageCount(file),
+		Title:         doc.Title,
+		Correspondent: doc.Correspondent,
+		Date:          doc.Date,
+		Category:      doc.Category,
+		Tags:          doc.Tags,
+		Barcodes:      doc.Barcodes,
+		Review:        doc.Review,
+	}
+	if d, ok := job.timings["ocr"]; ok {
+		s.OcrEngine = job.Cfg.Ocr.Engine
+		if s.OcrEngine == "docker" {
+			s.OcrEngine += " " + job.Cfg.Ocr.Image
+		}
+		s.OcrDuration = d.Seconds()
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		job.Warn("Unable to create sidecar", "path", file, "error", err)
+		return
+	}
+	local := filepath.Join(job.TempDir, "sidecar.json")
+	if err := os.WriteFile(local, data, 0600); err != nil {
+		job.Warn("Unable to create sidecar", "path", file, "error", err)
+		return
+	}
+	defer os.Remove(local)
+
+	name := path.Base(target)
+	remote := path.Join(path.Dir(target), strings.TrimSuffix(name, path.Ext(name))+".json")
+	if err := retryUpload(job.ctx, job.Cfg, store, local, remote); err != nil {
+		job.Warn("Unable to upload sidecar", "target", remote, "error", err)
+		return
+	}
+	job.Info("Uploaded sidecar", "target", remote)
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+	"github.com/hirochachacha/go-smb2"
+)
+
+// smbInbox reads the inbox from a Windows file share, without a kernel
+// mount whose changes inotify doesn't see. INBOX_URL has the form
+// smb://host[:port]/share[/path], INBOX_USER may be DOMAIN\user.
+type smbInbox struct {
+	*storage.Share
+}
+
+func newSmbInbox(cfg Config) (*smbInbox, error) {
+	user, pass := cfg.Inbox.User, cfg.Inbox.Pass
+	if user == "" {
+		user, pass = cfg.Server.User, cfg.Server.Pass
+	}
+	share, err := storage.NewShare(cfg.Inbox.Url, user, pass)
+	if err != nil {
+		return nil, err
+	}
+	return &smbInbox{share}, nil
+}
+
+// list returns the entries of the directory remotePath, the modification
+// time and size stand in for the ETag.
+func (s *smbInbox) list(ctx context.Context, remotePath string) ([]storage.Entry, error) {
+	var entries []storage.Entry
+	err := s.Mount(ctx, func(fs *smb2.Share) error {
+		infos, err := fs.ReadDir(s.Path(remotePath))
+		if err != nil {
+			return err
+		}
+		for _, fi := range infos {
+			entries = append(entries, storage.Entry{
+				Path:       path.Join(remotePath, fi.Name()),
+				Collection: fi.IsDir(),
+				ETag:       fi.ModTime().UTC().Format(time.RFC3339Nano),
+				Size:       fi.Size(),
+			})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// get downloads remotePath to filename.
+func (s *smbInbox) get(ctx context.Context, remotePath string, filename string) error {
+	return s.Mount(ctx, func(fs *smb2.Share) error {
+		in, err := fs.Open(s.Path(remotePath))
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, in); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+func (s *smbInbox) dispose(ctx context.Context, remotePath string, move string) error {
+	return s.Mount(ctx, func(fs *smb2.Share) error {
+		if move == "" {
+			return fs.Remove(s.Path(remotePath))
+		}
+		if err := fs.MkdirAll(s.Path(move), 0755); err != nil {
+			return fmt.Errorf("creating the inbox archive: %w", err)
+		}
+		return fs.Rename(s.Path(remotePath), s.Path(path.Join(move, movedName(path.Base(remotePath)))))
+	})
+}
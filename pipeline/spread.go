@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// spreadStep splits double-wide scans with SPREAD_ENABLED, two A4 pages
+// of a book or a folded document captured as one A3 image, into separate
+// pages before OCR. Images become PDFs of their halves, PDFs are rebuilt
+// like in the resample step.
+func spreadStep(job *Job) error {
+	if !job.Cfg.Spread.Enabled {
+		return nil
+	}
+	dir := filepath.Join(job.TempDir, "spread")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, in := range job.Files {
+		out := filepath.Join(dir, filepath.Base(in))
+		var err error
+		switch ext := fileType(in); {
+		case imageExts[ext]:
+			out, err = spreadImage(job, in, out)
+		case ext == ".pdf":
+			err = spreadPDF(job, in, out)
+		default:
+			err = copyFile(in, out)
+		}
+		if err != nil {
+			return err
+		}
+		job.Files[i] = out
+	}
+	return nil
+}
+
+// spreadImage writes the halves of a double-wide image file in as the
+// two page PDF out.pdf. Other images are copied to out.
+func spreadImage(job *Job, in, out string) (string, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return "", err
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if !doubleWide(img) {
+		return out, copyFile(in, out)
+	}
+	x := gutter(job.Cfg, img)
+	job.Info("Splitting double-wide image", "file", in, "gutter", x)
+
+	base := strings.TrimSuffix(out, filepath.Ext(out))
+	var halves []string
+	for i, r := range splitAt(img.Bounds(), x) {
+		name := fmt.Sprintf("%s-%d.%s", base, i+1, format)
+		if err := writeImage(name, format, subImage(img, r)); err != nil {
+			return "", err
+		}
+		halves = append(halves, name)
+	}
+	out = base + ".pdf"
+	return out, api.ImportImagesFile(halves, out, nil, pdfConf())
+}
+
+func spreadPDF(job *Job, in, out string) error {
+	changed, err := rebuildPDF(job, in, out, func(p page) []page {
+		if !doubleWide(p.img) {
+			return nil
+		}
+		var pages []page
+		for _, r := range splitAt(p.img.Bounds(), gutter(job.Cfg, p.img)) {
+			pages = append(pages, cropPage(p, r))
+		}
+		return pages
+	})
+	if changed {
+		job.Info("Split double-wide pages", "file", in)
+	}
+	return err
+}
+
+// doubleWide tells if img is landscape enough to hold two portrait pages.
+func doubleWide(img image.Image) bool {
+	b := img.Bounds()
+	return b.Dx()*5 > b.Dy()*6
+}
+
+// splitAt returns the left and right part of b at x.
+func splitAt(b image.Rectangle, x int) []image.Rectangle {
+	return []image.Rectangle{
+		image.Rect(b.Min.X, b.Min.Y, x, b.Max.Y),
+		image.Rect(x, b.Min.Y, b.Max.X, b.Max.Y),
+	}
+}
+
+// gutter finds the column between the pages of img with SPREAD_GUTTER:
+// center takes the middle, shadow the darkest band within SPREAD_RANGE
+// percent of the width around it, as books have, and gap the lightest, the
+// blank margins of a folded sheet.
+func gutter(cfg Config, img image.Image) int {
+	b := img.Bounds()
+	center := b.Min.X + b.Dx()/2
+	reach := b.Dx() * cfg.Spread.Range / 100
+	if cfg.Spread.Gutter == "center" || reach == 0 {
+		return center
+	}
+
+	g := toGray(img)
+	means := make([]int, 2*reach+1)
+	for i := range means {
+		x, sum := center-reach+i, 0
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			sum += int(g.Pix[g.PixOffset(x, y)])
+		}
+		means[i] = sum / b.Dy()
+	}
+	// over a band, single columns are noise
+	width := max(1, b.Dx()/200)
+	best, at := 0, center
+	for i := range means {
+		sum := 0
+		for j := max(0, i-width); j <= min(len(means)-1, i+width); j++ {
+			sum += means[j]
+		}
+		if cfg.Spread.Gutter == "shadow" {
+			sum = -sum
+		}
+		if i == 0 || sum > best {
+			best, at = sum, center-reach+i
+		}
+	}
+	return at
+}
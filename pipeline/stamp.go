@@ -0,0 +1,52 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// stampStep puts STAMP_TEXT or STAMP_IMAGE on the pages of the PDFs. The
+// text is a template of the document; %p and %P are replaced with the page
+// number and count, e.g. "ARCHIVED {{.Time.Format "2006-01-02"}}, page %p/%P".
+func stampStep(job *Job) error {
+	cfg := job.Cfg.Stamp
+	if cfg.Text == "" && cfg.Image == "" {
+		return nil
+	}
+	text := ""
+	if cfg.Text != "" {
+		var err error
+		if text, err = expand(cfg.Text, &job.Doc); err != nil {
+			return err
+		}
+	}
+	var pages []string
+	if cfg.Pages != "" {
+		pages = strings.Split(cfg.Pages, ",")
+	}
+	return job.each("stamp", func(in, out string) error {
+		if !strings.EqualFold(filepath.Ext(in), ".pdf") {
+			return copyFile(in, out)
+		}
+		if cfg.Image != "" {
+			return api.AddImageWatermarksFile(in, out, pages, true, cfg.Image, cfg.Desc, pdfConf())
+		}
+		return api.AddTextWatermarksFile(in, out, pages, true, text, cfg.Desc, pdfConf())
+	})
+}
+
+// validateStamp checks the description of the stamp, pdfcpu only parses it
+// when stamping.
+func validateStamp(cfg Config) error {
+	var err error
+	switch {
+	case cfg.Stamp.Image != "":
+		_, err = api.ImageWatermark(cfg.Stamp.Image, cfg.Stamp.Desc, true, false, types.POINTS)
+	case cfg.Stamp.Text != "":
+		_, err = api.TextWatermark(cfg.Stamp.Text, cfg.Stamp.Desc, true, false, types.POINTS)
+	}
+	return err
+}
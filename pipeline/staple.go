@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+func stapleEnabled(cfg Config) bool {
+	return cfg.Staple.Marker != "" || cfg.Staple.Idle > 0
+}
+
+// stapler collects incoming PDFs and merges them into one document when the
+// marker file shows up or no new file arrived within the idle window.
+type stapler struct {
+	ctx   context.Context
+	cfg   Config
+	mu    sync.Mutex
+	files []string
+	timer *time.Timer
+}
+
+func newStapler(ctx context.Context, cfg Config) *stapler {
+	return &stapler{ctx: ctx, cfg: cfg}
+}
+
+func (s *stapler) add(path string) {
+	if ignoredPath(s.cfg, path) {
+		return
+	}
+	if s.cfg.Staple.Marker != "" && filepath.Base(path) == s.cfg.Staple.Marker {
+		slog.Info("Staple marker detected", "file", path)
+		os.Remove(path)
+		s.flush()
+		return
+	}
+	if strings.ToLower(filepath.Ext(path)) != ".pdf" {
+		go enqueue(s.ctx, s.cfg, path, true)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		if f == path {
+			return
+		}
+	}
+	slog.Info("Stapling", "file", path)
+	s.files = append(s.files, path)
+	if s.cfg.Staple.Idle > 0 {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.timer = time.AfterFunc(s.cfg.Staple.Idle, s.flush)
+	}
+}
+
+func (s *stapler) flush() {
+	s.mu.Lock()
+	files := s.files
+	s.files = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+
+	if len(files) > 0 {
+		go func() {
+			// Wait 5 seconds to make sure the last file is complete
+			if !sleep(s.ctx, 5*time.Second) {
+				return
+			}
+			sort.Strings(files)
+			enqueueGroup(s.ctx, s.cfg, files, func(id string, tempDir string) (string, error) {
+				return staple(id, tempDir, files)
+			})
+		}()
+	}
+}
+
+// staple merges files in name order into one PDF in tempDir.
+func staple(id string, tempDir string, files []string) (string, error) {
+	slog.Info("Merging files", "job_id", id, "count", len(files), "files", files)
+	merged := filepath.Join(tempDir, filepath.Base(files[0]))
+	return merged, api.MergeCreateFile(files, merged, false, pdfConf())
+}
@@ -0,0 +1,422 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chbmuc/scan2webdav/storage"
+	"github.com/google/shlex"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".tif":  true,
+	".tiff": true,
+}
+
+// convertStep turns image files into single page PDFs and copies the other
+// files. It doesn't use each, as the PDFs need the .pdf extension, and so
+// does the file name of the document.
+func convertStep(job *Job) error {
+	dir := filepath.Join(job.TempDir, "convert")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, in := range job.Files {
+		out := filepath.Join(dir, filepath.Base(in))
+		ext := filepath.Ext(in)
+		if !imageExts[strings.ToLower(ext)] {
+			if err := copyFile(in, out); err != nil {
+				return err
+			}
+			job.Files[i] = out
+			continue
+		}
+		out = strings.TrimSuffix(out, ext) + ".pdf"
+		if err := api.ImportImagesFile([]string{in}, out, nil, pdfConf()); err != nil {
+			return err
+		}
+		job.Files[i] = out
+		if name := job.Doc.Filename; imageExts[strings.ToLower(filepath.Ext(name))] {
+			job.Doc.Filename = strings.TrimSuffix(name, filepath.Ext(name)) + ".pdf"
+		}
+	}
+	return nil
+}
+
+// deskewStep straightens pages without running text recognition.
+func deskewStep(job *Job) error {
+	return job.each("deskew", func(in, out string) error {
+		return runOcr(job, []string{"--deskew", "--skip-text", "--tesseract-timeout", "0", in, out})
+	})
+}
+
+func ocrStep(job *Job) error {
+	textOnly := job.Cfg.Ocr.Output == "text"
+	err := job.each("ocr", func(in, out string) error {
+		args, err := shlex.Split(job.Cfg.Ocr.Args)
+		if err != nil {
+			return fmt.Errorf("parsing arguments: %w", err)
+		}
+		if job.Cfg.Ocr.Language != "" {
+			args = setLanguage(args, job.Cfg.Ocr.Language)
+		}
+		if job.Cfg.Ocr.Output != "" {
+			args = setOutputType(args, ocrOutputType(job.Cfg.Ocr.Output))
+		}
+		textFile := out + ".txt"
+		if textOnly {
+			textFile = strings.TrimSuffix(out, filepath.Ext(out)) + ".txt"
+		}
+		if n := pageCount(in); chunkSize(job.Cfg, n) < n {
+			err = ocrChunks(job, args, in, out, textFile, n)
+		} else {
+			err = runOcr(job, ocrFiles(job.Cfg, args, in, out, textFile))
+		}
+		if err != nil {
+			return err
+		}
+		job.Doc.Language = ocrLanguage(args)
+		checkConfidence(job, in, job.Doc.Language)
+
+		if needsText(job.Cfg) {
+			text, err := os.ReadFile(textFile)
+			if err != nil {
+				job.Warn("Unable to read OCR text", "error", err)
+			}
+			job.Doc.Text += string(text)
+		}
+		return nil
+	})
+	if err != nil || !textOnly {
+		return err
+	}
+	for i, f := range job.Files {
+		job.Files[i] = strings.TrimSuffix(f, filepath.Ext(f)) + ".txt"
+	}
+	return nil
+}
+
+// ocrFiles appends the input, the output and the sidecar of a run to args.
+func ocrFiles(cfg Config, args []string, in, out, textFile string) []string {
+	args = slices.Clip(args)
+	switch {
+	case cfg.Ocr.Output == "text":
+		// the sidecar is the output, no PDF is written
+		return append(args, "--sidecar", textFile, in, "-")
+	case needsText(cfg):
+		return append(args, "--sidecar", textFile, in, out)
+	}
+	return append(args, in, out)
+}
+
+func needsText(cfg Config) bool {
+	return len(cfg.Rules) > 0 || cfg.Llm.Url != "" || cfg.Search.Enabled || cfg.Elastic.Url != "" || cfg.Receipts.Enabled
+}
+
+func classifyStep(job *Job) error {
+	if job.Cfg.Llm.Url != "" {
+		if err := extractMetadata(job.ctx, job.Cfg, &job.Doc); err != nil {
+			job.Warn("LLM classification failed", "error", err)
+		}
+	}
+	if job.Cfg.Receipts.Enabled {
+		r := parseReceipt(job.Doc.Text)
+		if job.Doc.Date == "" {
+			job.Doc.Date = r.Date
+		}
+		if job.Doc.Correspondent == "" {
+			job.Doc.Correspondent = r.Vendor
+		}
+		job.Doc.Receipt = &r
+	}
+	if rule := matchRules(job.Cfg.Rules, job.Doc.Text); rule != nil {
+		job.Info("Matched rule", "rule", rule.Name, "tags", rule.Tags)
+		job.Doc.Tags = append(job.Doc.Tags, rule.Tags...)
+		job.Doc.Favorite = job.Doc.Favorite || rule.Favorite
+		job.Rule = rule
+	}
+	return nil
+}
+
+func metadataStep(job *Job) error {
+	if !metadataEnabled(job.Cfg) {
+		return nil
+	}
+	for _, f := range job.Files {
+		if err := writeMetadata(job.Cfg, &job.Doc, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func optimizeStep(job *Job) error {
+	return job.each("optimize", func(in, out string) error {
+		return api.OptimizeFile(in, out, pdfConf())
+	})
+}
+
+// splitStep splits documents into parts of at most SPLIT_PAGES pages and
+// at most SPLIT_SIZE bytes, for destinations with hard size limits.
+func splitStep(job *Job) error {
+	cfg := job.Cfg.Split
+	if cfg.Pages <= 0 && cfg.Size <= 0 {
+		return nil
+	}
+	dir := filepath.Join(job.TempDir, "split")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	var files []string
+	for _, in := range job.Files {
+		if !strings.EqualFold(filepath.Ext(in), ".pdf") {
+			files = append(files, in)
+			continue
+		}
+		var parts []string
+		var err error
+		if cfg.Size > 0 {
+			parts, err = splitBySize(job, in, dir, cfg.Pages, cfg.Size)
+		} else {
+			parts, err = splitFile(in, dir, cfg.Pages)
+		}
+		if err != nil {
+			return err
+		}
+		job.Info("Split document", "file", in, "parts", len(parts))
+		files = append(files, parts...)
+	}
+	job.Files = files
+	return nil
+}
+
+// splitBySize splits in into parts of at most size bytes and, if pages is
+// set, at most pages pages. The parts don't share fonts and images like the
+// whole document, so the pages per part are lowered until every part
+// fits. Single pages that are still too large are kept as they are.
+func splitBySize(job *Job, in string, dir string, pages int, size int64) ([]string, error) {
+	fi, err := os.Stat(in)
+	if err != nil {
+		return nil, err
+	}
+	total := pageCount(in)
+	n := total
+	if fi.Size() > size {
+		n = max(1, int(int64(total)*size/fi.Size()))
+	}
+	if pages > 0 {
+		n = min(n, pages)
+	}
+	for {
+		parts, err := splitFile(in, dir, n)
+		if err != nil {
+			return nil, err
+		}
+		largest, err := largestFile(parts)
+		if err != nil {
+			return nil, err
+		}
+		if largest <= size {
+			return parts, nil
+		}
+		if n == 1 {
+			job.Warn("Pages exceed the split size", "file", in, "size", largest, "max", size)
+			return parts, nil
+		}
+		n = min(n-1, max(1, int(int64(n)*size/largest)))
+	}
+}
+
+func largestFile(files []string) (int64, error) {
+	var largest int64
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return 0, err
+		}
+		largest = max(largest, fi.Size())
+	}
+	return largest, nil
+}
+
+func splitFile(in string, dir string, pages int) ([]string, error) {
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spans, err := api.SplitRaw(f, pages, pdfConf())
+	if err != nil {
+		return nil, err
+	}
+	if len(spans) <= 1 {
+		return []string{in}, nil
+	}
+
+	base := filepath.Base(in)
+	ext := filepath.Ext(base)
+	var parts []string
+	for i, span := range spans {
+		part := filepath.Join(dir, fmt.Sprintf("%s-part%d%s", strings.TrimSuffix(base, ext), i+1, ext))
+		out, err := os.Create(part)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, span.Reader)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// renameStep moves the working files to their remote names.
+func renameStep(job *Job) error {
+	rule := job.Rule
+	if rule != nil && job.Ticket != nil && job.Ticket.Path != "" {
+		// the ticket destination wins over the rules
+		r := *rule
+		r.Target = ""
+		rule = &r
+	}
+	remoteDir, name := destination(job.ctx, job.Cfg, rule, &job.Doc)
+	job.Path = remoteDir
+	job.URL = job.Cfg.Server.Url
+	if remoteDir != "" {
+		job.URL = job.URL + "/" + remoteDir
+	}
+
+	dir := filepath.Join(job.TempDir, "out")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, f := range job.Files {
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		if ext != "" && !strings.EqualFold(ext, filepath.Ext(f)) {
+			// e.g. text only OCR output
+			ext = filepath.Ext(f)
+		}
+		if job.Doc.Review {
+			base += job.Cfg.Review.Suffix
+		}
+		if len(job.Files) > 1 {
+			base = fmt.Sprintf("%s-part%d", base, i+1)
+		}
+		remote := base + ext
+		renamed := filepath.Join(dir, remote)
+		if err := os.Rename(f, renamed); err != nil {
+			return err
+		}
+		job.Files[i] = renamed
+	}
+	return nil
+}
+
+func uploadStep(job *Job) error {
+	store, err := newStorage(job.Cfg, &job.Doc)
+	if err != nil {
+		return err
+	}
+	if err := waitForQuota(job, store); err != nil {
+		return err
+	}
+	for _, f := range job.Files {
+		target, err := resolveConflict(job.ctx, job.Cfg, store, path.Join(job.Path, filepath.Base(f)), &job.Doc)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			job.Info("Skipping upload, target exists already", "path", f)
+			continue
+		}
+
+		err = retryUpload(job.ctx, job.Cfg, store, f, target)
+		var se *storage.StatusError
+		if errors.As(err, &se) && se.Code == http.StatusConflict {
+			job.Info("Creating missing collections", "url", job.URL)
+			if err := store.Mkdir(job.ctx, job.Path); err != nil {
+				return err
+			}
+			err = retryUpload(job.ctx, job.Cfg, store, f, target)
+		}
+		if err != nil {
+			return fmt.Errorf("upload of %s failed: %w", f, err)
+		}
+		job.Info("Uploaded", "path", f, "target", target, "status", "uploaded")
+		job.uploaded = append(job.uploaded, strings.TrimSuffix(job.Cfg.Server.Url, "/")+"/"+target)
+		job.remotes = append(job.remotes, target)
+		job.count(f)
+		uploadSucceeded()
+		uploadPreview(job, store, f, target)
+		uploadSidecar(job, store, f, target)
+		uploadLayout(job, store, f, target)
+		shareUpload(job, store, target)
+		if job.Cfg.Originals.Verify && job.Cfg.Originals.Mode != "keep" {
+			if err := verifyRemote(job.ctx, store, f, target); err != nil {
+				return fmt.Errorf("keeping originals, %w", err)
+			}
+		}
+	}
+	return job.disposeSources()
+}
+
+// archiveStep keeps a local copy of the working files.
+// The copies are sorted into date folders below ARCHIVE_PATH, nothing is
+// archived if it isn't set.
+func archiveStep(job *Job) error {
+	if job.Cfg.Archive.Path == "" {
+		return nil
+	}
+	sub, err := expand(job.Cfg.Archive.Dirs, &job.Doc)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(job.Cfg.Archive.Path, filepath.Clean("/"+sub))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range job.Files {
+		dst := filepath.Join(dir, filepath.Base(f))
+		job.Info("Archiving", "path", f, "target", dst)
+		if err := copyFile(f, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptStep protects the documents with AES-256 if a password is set.
+func encryptStep(job *Job) error {
+	pass := job.Cfg.Encrypt
+	if pass.User == "" && pass.Owner == "" {
+		return nil
+	}
+	owner := pass.Owner
+	if owner == "" {
+		owner = pass.User
+	}
+	return job.each("encrypt", func(in, out string) error {
+		conf := model.NewAESConfiguration(pass.User, owner, 256)
+		conf.ValidationMode = model.ValidationRelaxed
+		return api.EncryptFile(in, out, conf)
+	})
+}
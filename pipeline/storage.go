@@ -0,0 +1,266 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chbmuc/scan2webdav/storage"
+	"golang.org/x/oauth2"
+)
+
+// paperlessPrefix marks a paperless-ngx server URL, e.g.
+// paperless+https://paperless.example.com
+const paperlessPrefix = "paperless+"
+
+// newStorage returns the backend for the server URL. doc is the document
+// being uploaded, for backends that store metadata along with the file.
+func newStorage(cfg Config, doc *Document) (storage.Storage, error) {
+	var meta *storage.Metadata
+	if doc != nil {
+		meta = &storage.Metadata{
+			Title:         doc.Title,
+			Date:          doc.Date,
+			Correspondent: doc.Correspondent,
+			Category:      doc.Category,
+			Tags:          doc.Tags,
+			Favorite:      doc.Favorite,
+		}
+	}
+	if base, ok := strings.CutPrefix(cfg.Server.Url, paperlessPrefix); ok {
+		return &storage.Paperless{
+			Base:   base,
+			Token:  cfg.Server.Token,
+			User:   cfg.Server.User,
+			Pass:   cfg.Server.Pass,
+			Client: client,
+			Meta:   meta,
+		}, nil
+	}
+	u, err := url.Parse(cfg.Server.Url)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		s := newWebDAV(cfg)
+		s.Meta = meta
+		return s, nil
+	case "file":
+		return &storage.Local{Root: u.Path}, nil
+	case "smb":
+		share, err := storage.NewShare(cfg.Server.Url, cfg.Server.User, cfg.Server.Pass)
+		if err != nil {
+			return nil, err
+		}
+		return &storage.SMB{Share: share}, nil
+	case "rclone":
+		s := &storage.Rclone{Exec: cfg.Rclone.Exec, Config: cfg.Rclone.Config, Root: u.Opaque}
+		if tokens != nil && cfg.OAuth.TokenUrl != "" {
+			s.Tokens = tokens
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported upload target %s", cfg.Server.Url)
+}
+
+// newWebDAV returns the WebDAV server of cfg, reached with the shared
+// client and credentials.
+func newWebDAV(cfg Config) *storage.WebDAV {
+	return &storage.WebDAV{
+		Root:           cfg.Server.Url,
+		Client:         client,
+		Authorize:      func(req *http.Request) error { return authorize(cfg, req) },
+		Form:           cfg.Server.Form,
+		Checksum:       cfg.Server.Checksum,
+		Verify:         cfg.Server.Verify,
+		Lock:           cfg.Server.Lock,
+		ChunkThreshold: cfg.Server.ChunkThreshold,
+		ChunkSize:      cfg.Server.ChunkSize,
+		Favorite:       cfg.Nextcloud.Favorite,
+		Tags:           cfg.Nextcloud.Tags,
+	}
+}
+
+// putFile uploads filename to remotePath.
+func putFile(ctx context.Context, store storage.Storage, filename string, remotePath string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := store.Put(ctx, remotePath, file, fi.Size()); err != nil {
+		return err
+	}
+	uploadedBytes.add("", float64(fi.Size()))
+	return nil
+}
+
+// retryUpload calls putFile until it succeeds, fails with an error that
+// isn't worth retrying or the configured number of retries is used up.
+func retryUpload(ctx context.Context, cfg Config, store storage.Storage, filename string, remotePath string) error {
+	backoff := cfg.Retry.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := waitCircuit(ctx); err != nil {
+			return err
+		}
+		err := putFile(ctx, store, filename, remotePath)
+		observeUpload(cfg, err)
+		if err == nil {
+			return nil
+		}
+		class := classify(err)
+		uploadErrors.inc(string(class))
+		if attempt >= cfg.Retry.Count || !retryable(ctx, cfg, err) {
+			return &uploadError{Class: class, Err: err}
+		}
+
+		uploadRetries.inc("")
+		slog.WarnContext(ctx, "Upload failed, retrying", "path", filename, "error", err, "class", class, "retry_in", backoff, "attempt", attempt+1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// errorClass tells how an upload failed.
+type errorClass string
+
+const (
+	classTransient errorClass = "transient" // network errors, overloaded servers
+	classAuth      errorClass = "auth"      // rejected or missing credentials
+	classConflict  errorClass = "conflict"  // locked or changed targets, missing collections
+	classPermanent errorClass = "permanent" // everything else, like bad requests or certificates
+	classCancelled errorClass = "cancelled"
+)
+
+// classify sorts an upload error into an errorClass.
+func classify(err error) errorClass {
+	var se *storage.StatusError
+	var re *oauth2.RetrieveError
+	var ce *tls.CertificateVerificationError
+	var ue x509.UnknownAuthorityError
+	var he x509.HostnameError
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return classCancelled
+	case errors.As(err, &se):
+		switch {
+		case se.Code == http.StatusUnauthorized || se.Code == http.StatusForbidden:
+			return classAuth
+		case se.Code == http.StatusConflict || se.Code == http.StatusPreconditionFailed || se.Code == http.StatusLocked:
+			return classConflict
+		case se.Code == http.StatusRequestTimeout || se.Code == http.StatusTooManyRequests || se.Code >= 500:
+			return classTransient
+		}
+		return classPermanent
+	case errors.As(err, &re):
+		return classAuth
+	case errors.As(err, &ce), errors.As(err, &ue), errors.As(err, &he):
+		return classPermanent
+	case errors.As(err, &ne), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return classTransient
+	}
+	return classPermanent
+}
+
+// uploadError is a failed upload along with its errorClass.
+type uploadError struct {
+	Class errorClass
+	Err   error
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Err, e.Class)
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.Err
+}
+
+// retryable reports whether an upload that failed with err should be tried
+// again. Failures with a status are retried if it is in RETRY_STATUS, the
+// others if they are transient, like network errors.
+func retryable(ctx context.Context, cfg Config, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *storage.StatusError
+	if errors.As(err, &se) {
+		return slices.Contains(cfg.Retry.Status, se.Code)
+	}
+	return classify(err) == classTransient
+}
+
+// conflictName is the data of the SERVER_CONFLICT_NAME template.
+type conflictName struct {
+	Document
+	Name string // the taken name without extension
+	N    int    // number of the try, from 1
+}
+
+// resolveConflict applies the conflict strategy to remotePath. It returns
+// the path to upload to, or an empty string if the upload should be
+// skipped.
+func resolveConflict(ctx context.Context, cfg Config, store storage.Storage, remotePath string, doc *Document) (string, error) {
+	if cfg.Server.Conflict == "overwrite" {
+		return remotePath, nil
+	}
+	found, err := store.Exists(ctx, remotePath)
+	if err != nil || !found {
+		return remotePath, err
+	}
+
+	ext := path.Ext(remotePath)
+	switch cfg.Server.Conflict {
+	case "skip":
+		return "", nil
+	case "timestamp":
+		return strings.TrimSuffix(remotePath, ext) + inZone(time.Now()).Format("-20060102-150405") + ext, nil
+	}
+
+	// rename: count up until a free name is found
+	dir, base := path.Split(strings.TrimSuffix(remotePath, ext))
+	first := ""
+	for i := 1; ; i++ {
+		name, err := expand(cfg.Server.ConflictName, conflictName{Document: *doc, Name: base, N: i})
+		if err != nil {
+			return "", err
+		}
+		name = sanitize(cfg, name)
+		// templates without .N still need a counter once their name is taken
+		if i == 1 {
+			first = name
+		} else if name == first {
+			name = fmt.Sprintf("%s-%d", name, i-1)
+		}
+		candidate := dir + name + ext
+		found, err := store.Exists(ctx, candidate)
+		if err != nil || !found {
+			return candidate, err
+		}
+	}
+}
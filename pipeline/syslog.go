@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogWriter sends RFC 5424 messages to the local syslog socket or a
+// remote server over UDP or TCP.
+type syslogWriter struct {
+	network, addr string
+	facility      int
+	hostname      string
+	app           string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newSyslogWriter connects to target, which is local or a udp:// or tcp://
+// URL.
+func newSyslogWriter(target, facility string) (*syslogWriter, error) {
+	f, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	w := &syslogWriter{facility: f, app: filepath.Base(os.Args[0])}
+	w.hostname, _ = os.Hostname()
+	if target == "local" {
+		w.network = "unixgram"
+	} else {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "udp" && u.Scheme != "tcp" {
+			return nil, fmt.Errorf("unsupported syslog target %q", target)
+		}
+		w.network, w.addr = u.Scheme, u.Host
+		if u.Port() == "" {
+			w.addr = net.JoinHostPort(u.Hostname(), "514")
+		}
+	}
+	return w, w.connect()
+}
+
+func (w *syslogWriter) connect() error {
+	if w.network != "unixgram" {
+		conn, err := net.DialTimeout(w.network, w.addr, 5*time.Second)
+		w.conn = conn
+		return err
+	}
+	var err error
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		var conn net.Conn
+		if conn, err = net.Dial("unixgram", path); err == nil {
+			w.conn = conn
+			return nil
+		}
+	}
+	return err
+}
+
+// send writes one message. Messages over TCP are framed by octet counting
+// as in RFC 6587. A broken connection is opened again once.
+func (w *syslogWriter) send(level slog.Level, t time.Time, msg []byte) error {
+	severity := 6
+	switch {
+	case level >= slog.LevelError:
+		severity = 3
+	case level >= slog.LevelWarn:
+		severity = 4
+	case level < slog.LevelInfo:
+		severity = 7
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - ", w.facility*8+severity,
+		t.Format(time.RFC3339Nano), nilValue(w.hostname), nilValue(w.app), os.Getpid())
+	b.Write(msg)
+	frame := b.Bytes()
+	if w.network == "tcp" {
+		frame = append([]byte(fmt.Sprintf("%d ", b.Len())), frame...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		if _, err := w.conn.Write(frame); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(frame)
+	return err
+}
+
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogHandler formats records like the text handler, without time and
+// level which are part of the syslog header.
+type syslogHandler struct {
+	w     *syslogWriter
+	level slog.Leveler
+	ops   []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	var th slog.Handler = slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: h.level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	for _, op := range h.ops {
+		th = op(th)
+	}
+	if err := th.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.w.send(r.Level, r.Time, bytes.TrimRight(buf.Bytes(), "\n"))
+}
+
+func (h *syslogHandler) with(op func(slog.Handler) slog.Handler) *syslogHandler {
+	ops := append(h.ops[:len(h.ops):len(h.ops)], op)
+	return &syslogHandler{w: h.w, level: h.level, ops: ops}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(th slog.Handler) slog.Handler { return th.WithAttrs(attrs) })
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(th slog.Handler) slog.Handler { return th.WithGroup(name) })
+}
+
+// teeHandler passes records to several handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var first error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
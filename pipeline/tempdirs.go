@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// tempRoot holds the temp directories of the jobs, /tmp or %TEMP%.
+var tempRoot = os.TempDir()
+
+// ownerFile in a temp directory names the watcher path of the instance
+// that created it.
+const ownerFile = ".owner"
+
+// staleAge is the age after which temp directories without an owner are
+// considered abandoned.
+const staleAge = 24 * time.Hour
+
+// newTempDir creates the temp directory of a job.
+func newTempDir(cfg Config) (string, error) {
+	dir, err := os.MkdirTemp(tempRoot, "ocrmypdf-*")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.WriteFile(filepath.Join(dir, ownerFile), []byte(cfg.Watcher.Path), 0600)
+}
+
+// cleanTempDirs deals with the temp directories left behind by crashed
+// runs. Directories of resumed jobs are in use and skipped, as are those of
+// other instances. Completed step outputs are salvaged into a job that
+// continues after that step, the rest is removed.
+func cleanTempDirs(ctx context.Context, cfg Config) {
+	dirs, _ := filepath.Glob(filepath.Join(tempRoot, "ocrmypdf-*"))
+	for _, dir := range dirs {
+		tempDirs.Lock()
+		inUse := tempDirs.paths[dir]
+		tempDirs.Unlock()
+		if inUse || !ownTempDir(cfg, dir) {
+			continue
+		}
+		if job := salvage(ctx, cfg, dir); job != nil {
+			job.Info("Salvaging output of an interrupted run", "path", dir, "files", job.Files)
+			queue.push(job.Input, 0, func() { runJob(job) })
+			continue
+		}
+		slog.Info("Removing stale temp directory", "path", dir)
+		os.RemoveAll(dir)
+	}
+}
+
+// ownTempDir tells if dir was left by an instance on the same watcher
+// path. Old directories without an owner are claimed as well.
+func ownTempDir(cfg Config, dir string) bool {
+	owner, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err == nil {
+		return string(owner) == cfg.Watcher.Path
+	}
+	fi, err := os.Stat(dir)
+	return err == nil && time.Since(fi.ModTime()) > staleAge
+}
+
+// salvage returns a job for the output of the last step in dir that
+// produced valid PDFs. Inputs that are still in the watcher path are
+// processed again by the sweep instead.
+func salvage(ctx context.Context, cfg Config, dir string) *Job {
+	steps := cfg.Pipeline.Steps
+	for i := len(steps) - 1; i >= 0; i-- {
+		files, _ := filepath.Glob(filepath.Join(dir, steps[i], "*"))
+		if len(files) == 0 {
+			continue
+		}
+		for _, f := range files {
+			if strings.ToLower(filepath.Ext(f)) != ".pdf" || api.ValidateFile(f, pdfConf()) != nil {
+				return nil
+			}
+			if exists(filepath.Join(cfg.Watcher.Path, filepath.Base(f))) {
+				return nil
+			}
+		}
+		if i == len(steps)-1 {
+			// the job got through, just not cleaned up
+			return nil
+		}
+		input := filepath.Join(cfg.Watcher.Path, filepath.Base(files[0]))
+		if !claim(input) {
+			return nil
+		}
+		job := newJob(ctx, cfg, newID(), input, dir)
+		job.Files = files
+		job.Sources = nil // the originals are gone
+		job.next = i + 1
+		return job
+	}
+	return nil
+}
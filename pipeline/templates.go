@@ -0,0 +1,230 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// templateZone and templateNames are set from TEMPLATES_TIMEZONE and
+// TEMPLATES_LOCALE by setupTemplates. Document times are in that zone, so
+// date folders don't depend on the time zone of the host or container.
+var (
+	templateZone  = time.Local
+	templateNames *localeNames
+)
+
+// localeNames are the month and weekday names of a locale, January and
+// Sunday first.
+type localeNames struct {
+	months   [12]string
+	weekdays [7]string
+}
+
+var locales = map[string]*localeNames{
+	"de": {
+		months:   [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		weekdays: [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+	},
+	"fr": {
+		months:   [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		weekdays: [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+	},
+	"es": {
+		months:   [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		weekdays: [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+	},
+	"it": {
+		months:   [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		weekdays: [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+	},
+	"nl": {
+		months:   [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		weekdays: [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+	},
+}
+
+func setupTemplates(cfg Config) error {
+	templateZone = time.Local
+	if tz := cfg.Templates.Timezone; tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return fmt.Errorf("invalid template timezone %q", tz)
+		}
+		templateZone = loc
+	}
+	templateNames = nil
+	if l := cfg.Templates.Locale; l != "" && l != "en" {
+		// de_DE.UTF-8 selects de
+		lang, _, _ := strings.Cut(strings.ToLower(l), "_")
+		lang, _, _ = strings.Cut(lang, "-")
+		if templateNames = locales[lang]; templateNames == nil {
+			return fmt.Errorf("invalid template locale %q", l)
+		}
+	}
+	return nil
+}
+
+// inZone returns t in the template time zone.
+func inZone(t time.Time) time.Time {
+	return t.In(templateZone)
+}
+
+var templateFuncs = template.FuncMap{
+	"date":     formatDate,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"trim":     strings.TrimSpace,
+	"replace":  func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"join":     func(sep string, list []string) string { return strings.Join(list, sep) },
+	"default":  orDefault,
+	"slugify":  slugify,
+	"truncate": truncate,
+	"hash":     hashPrefix,
+}
+
+// orDefault returns s, or def if s is empty, e.g. {{.Correspondent | default "unknown"}}.
+func orDefault(def, s string) string {
+	if strings.TrimSpace(s) == "" {
+		return def
+	}
+	return s
+}
+
+// transliterations are spelled out by slugify rather than losing their
+// marks.
+var transliterations = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "Ä", "Ae", "Ö", "Oe", "Ü", "Ue", "ß", "ss", "æ", "ae", "ø", "o", "Æ", "Ae", "Ø", "O")
+
+// slugify turns s into lower case ASCII letters and digits separated by
+// single dashes, e.g. "Rechnung Müller & Co." into rechnung-mueller-co.
+func slugify(s string) string {
+	s = norm.NFD.String(transliterations.Replace(s))
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// accents left over from the decomposition
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// truncate shortens s to n characters, e.g. {{.Title | truncate 40}}.
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n]))
+}
+
+// hashPrefix returns the first n hex digits of the SHA-256 of s, a short
+// name that only changes with s, e.g. {{hash 8 .Text}}. For the hash of
+// the input {{.Hash | truncate 8}} does.
+func hashPrefix(n int, s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return truncate(n, hex.EncodeToString(sum[:]))
+}
+
+// counterState is a named sequence of the counter store. The document
+// that got the last number keeps it, so a retried job isn't numbered
+// twice.
+type counterState struct {
+	Value int    `json:"value"`
+	Hash  string `json:"hash,omitempty"` // of the document that got Value
+}
+
+// counterStore keeps the sequences of the Counter template method in the
+// state dir, so they go on after a restart.
+type counterStore struct {
+	mu     sync.Mutex
+	path   string
+	states map[string]*counterState
+}
+
+var counters *counterStore
+
+func loadCounterStore(dir string) (*counterStore, error) {
+	s := &counterStore{path: filepath.Join(dir, "counters.json"), states: map[string]*counterState{}}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.states); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// next returns the next number of the sequence name for the document with
+// hash, starting at 1.
+func (s *counterStore) next(name string, hash string) (int, error) {
+	if s == nil {
+		return 0, errors.New("counters need the state directory")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.states[name]
+	if st == nil {
+		st = &counterState{}
+		s.states[name] = st
+	}
+	if hash != "" && st.Hash == hash {
+		return st.Value, nil
+	}
+	st.Value++
+	st.Hash = hash
+	return st.Value, writeJSON(s.path, s.states)
+}
+
+// Counter returns the next number of the sequence name, persisted across
+// restarts, e.g. {{printf "INV-%05d" (.Counter "invoices")}}.
+func (d Document) Counter(name string) (int, error) {
+	return counters.next(name, d.Hash)
+}
+
+// formatDate formats t in the template time zone with the month and
+// weekday names of the template locale, e.g. {{date "2006/01 January" .Time}}.
+func formatDate(layout string, t time.Time) string {
+	t = inZone(t)
+	s := t.Format(layout)
+	if templateNames == nil {
+		return s
+	}
+	// the long names first, the short ones are their prefixes
+	month, weekday := t.Month().String(), t.Weekday().String()
+	s = strings.ReplaceAll(s, month, templateNames.months[t.Month()-1])
+	s = strings.ReplaceAll(s, weekday, templateNames.weekdays[t.Weekday()])
+	s = strings.ReplaceAll(s, month[:3], short(templateNames.months[t.Month()-1]))
+	s = strings.ReplaceAll(s, weekday[:3], short(templateNames.weekdays[t.Weekday()]))
+	return s
+}
+
+// short abbreviates a month or weekday name to three letters.
+func short(name string) string {
+	r := []rune(name)
+	return string(r[:min(3, len(r))])
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// throttle keeps the uploads of the last hour for LIMIT_DOCUMENTS and
+// LIMIT_BYTES.
+var throttle struct {
+	sync.Mutex
+	uploads []throttled // oldest first
+}
+
+type throttled struct {
+	time  time.Time
+	bytes int64
+}
+
+// waitForThrottle blocks until the upload of job fits into the hourly
+// limits and reserves it. A single document above LIMIT_BYTES still goes
+// through once nothing else was uploaded within the hour.
+func waitForThrottle(job *Job) error {
+	limit := job.Cfg.Limit
+	if limit.Documents <= 0 && limit.Bytes <= 0 {
+		return nil
+	}
+	var size int64
+	for _, f := range job.Files {
+		if fi, err := os.Stat(f); err == nil {
+			size += fi.Size()
+		}
+	}
+
+	logged := false
+	for {
+		wait := reserveUpload(limit.Documents, limit.Bytes, size, time.Now())
+		if wait == 0 {
+			return nil
+		}
+		if !logged {
+			job.Info("Hourly upload limit reached, waiting", "wait", wait.Round(time.Second))
+			logged = true
+		}
+		if !sleep(job.ctx, wait) {
+			return job.ctx.Err()
+		}
+	}
+}
+
+// reserveUpload records an upload of size bytes at now if it fits into the
+// limits, otherwise it returns how long to wait until the oldest upload
+// leaves the window.
+func reserveUpload(documents int, bytes int64, size int64, now time.Time) time.Duration {
+	throttle.Lock()
+	defer throttle.Unlock()
+	i := 0
+	for i < len(throttle.uploads) && now.Sub(throttle.uploads[i].time) >= time.Hour {
+		i++
+	}
+	throttle.uploads = throttle.uploads[i:]
+
+	var sum int64
+	for _, u := range throttle.uploads {
+		sum += u.bytes
+	}
+	full := documents > 0 && len(throttle.uploads) >= documents
+	if bytes > 0 && sum > 0 && sum+size > bytes {
+		full = true
+	}
+	if !full {
+		throttle.uploads = append(throttle.uploads, throttled{now, size})
+		return 0
+	}
+	return throttle.uploads[0].time.Add(time.Hour).Sub(now)
+}
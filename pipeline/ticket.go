@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ticketExts = []string{".json", ".yaml", ".yml"}
+
+// Ticket holds per-document overrides read from a file next to the scan,
+// e.g. scan.pdf.json. JSON tickets are parsed as YAML.
+type Ticket struct {
+	Path     string // destination collection template
+	Name     string // filename template
+	Language string
+	OcrArgs  string `yaml:"ocr_args"`
+
+	file string
+}
+
+// isTicket tells if path is the ticket of a scan, scan.pdf.json or one of
+// the YAML variants, rather than a document of its own. Tickets may arrive
+// before their scan, so a scan that doesn't exist yet only needs a name of
+// a type the pipeline takes.
+func isTicket(path string) bool {
+	for _, ext := range ticketExts {
+		scan, ok := strings.CutSuffix(path, ext)
+		if !ok || filepath.Ext(scan) == "" {
+			continue
+		}
+		if exists(scan) {
+			return true
+		}
+		if t := fileType(scan); t == ".pdf" || t == ".heic" || imageExts[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTicket returns the ticket for inFile or nil if there is none.
+func loadTicket(inFile string) *Ticket {
+	for _, ext := range ticketExts {
+		data, err := os.ReadFile(inFile + ext)
+		if err != nil {
+			continue
+		}
+		t := &Ticket{file: inFile + ext}
+		if err := yaml.Unmarshal(data, t); err != nil {
+			slog.Warn("Ignoring invalid job ticket", "ticket", t.file, "error", err)
+			return nil
+		}
+		slog.Info("Using job ticket", "ticket", t.file)
+		return t
+	}
+	return nil
+}
+
+func (t *Ticket) apply(cfg *Config) {
+	if t.Path != "" {
+		cfg.Server.Path = t.Path
+	}
+	if t.Name != "" {
+		cfg.Server.Name = t.Name
+	}
+	if t.OcrArgs != "" {
+		cfg.Ocr.Args = t.OcrArgs
+	}
+	if t.Language != "" {
+		cfg.Ocr.Language = t.Language
+	}
+}
+
+// setLanguage replaces the language options in args with lang.
+func setLanguage(args []string, lang string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "-l" || a == "--language":
+			i++
+		case strings.HasPrefix(a, "--language=") || (strings.HasPrefix(a, "-l") && len(a) > 2):
+		default:
+			out = append(out, a)
+		}
+	}
+	return append(out, "-l", lang)
+}
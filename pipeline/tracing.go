@@ -0,0 +1,79 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the pipeline. It is a no-op unless
+// TRACE_ENDPOINT is set.
+var tracer = otel.Tracer("scan2webdav")
+
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing exports spans with OTLP over HTTP to TRACE_ENDPOINT, e.g.
+// http://localhost:4318. The OTEL_EXPORTER_OTLP_* variables apply as well.
+func setupTracing(cfg Config) error {
+	if cfg.Trace.Endpoint == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Trace.Endpoint))
+	if err != nil {
+		return err
+	}
+	res := resource.NewSchemaless(semconv.ServiceName(cfg.Trace.Service))
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.Trace.Ratio)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	// requests to the upload target show up as child spans of the step
+	client.Transport = otelhttp.NewTransport(client.Transport)
+	slog.Info("Tracing enabled", "endpoint", cfg.Trace.Endpoint)
+	return nil
+}
+
+// closeTracing exports the remaining spans.
+func closeTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		slog.Warn("Unable to export spans", "error", err)
+	}
+	tracerProvider = nil
+}
+
+// startSpan starts a child span of the current span of job and makes it
+// the current one. The returned function ends it and restores the parent.
+func (job *Job) startSpan(name string, attrs ...attribute.KeyValue) func(error) {
+	parent := job.ctx
+	ctx, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	job.ctx = ctx
+	return func(err error) {
+		endSpan(span, err)
+		job.ctx = parent
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
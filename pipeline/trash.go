@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// trash moves file into dir. The modification time is set to now, the
+// retention counts from the disposal and not from the scan.
+func trash(dir string, file string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	dst := freeName(filepath.Join(dir, filepath.Base(file)))
+	if err := moveFile(file, dst); err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(dst, now, now)
+}
+
+// startTrashPurge removes trashed originals after ORIGINALS_RETENTION,
+// once at the start and then every hour.
+func startTrashPurge(ctx context.Context, cfg Config) {
+	if cfg.Originals.Mode != "trash" {
+		return
+	}
+	go func() {
+		for {
+			purgeTrash(cfg.Originals.Trash, cfg.Originals.Retention)
+			if !sleep(ctx, time.Hour) {
+				return
+			}
+		}
+	}()
+}
+
+func purgeTrash(dir string, retention time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Unable to read trash", "path", dir, "error", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil || time.Since(fi.ModTime()) < retention {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		slog.Info("Purging trashed original", "path", path, "trashed", fi.ModTime())
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Unable to purge trashed original", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package pipeline
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// tuiJobs is the job list of GET /api/jobs.
+type tuiJobs struct {
+	Active  []jobStatus  `json:"active"`
+	Queued  []queuedItem `json:"queued"`
+	History []jobStatus  `json:"history"` // newest first
+}
+
+// tuiState is what the terminal UI shows.
+type tuiState struct {
+	addr     string
+	jobs     tuiJobs
+	pipeline pipelineStatus
+	err      error  // of the last refresh
+	selected string // ID of the selected failed job
+	message  string // outcome of the last action
+}
+
+// tuiCommand shows a live view of the running daemon in the terminal: the
+// queue, the jobs in flight with their elapsed time and the recent
+// completions and failures. Failed jobs are selected with the arrow keys
+// or j and k, r retries and d discards the selected one, p pauses or
+// resumes the pipeline and q quits.
+func tuiCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("tui", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	interval := flags.Duration("interval", time.Second, "refresh `interval`")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		fmt.Fprintln(os.Stderr, "tui needs a terminal, see status for scripts")
+		return 2
+	}
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer term.Restore(fd, old)
+	// alternate screen without cursor, the shell comes back untouched
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(keys)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	s := &tuiState{addr: *addr}
+	for {
+		s.refresh(cfg)
+		s.draw(fd)
+		select {
+		case <-ticker.C:
+		case k, ok := <-keys:
+			if !ok {
+				return 0
+			}
+			switch k {
+			case "q", "\x03":
+				return 0
+			case "j", "\x1b[B":
+				s.move(1)
+			case "k", "\x1b[A":
+				s.move(-1)
+			case "r", "d":
+				s.act(cfg, k)
+			case "p":
+				action := "pause"
+				if s.pipeline.Paused {
+					action = "resume"
+				}
+				if err := apiDo(cfg, http.MethodPost, s.addr+"/api/pipeline/"+action, &s.pipeline); err != nil {
+					s.message = err.Error()
+				} else {
+					s.message = "Pipeline " + action + "d"
+				}
+			}
+		}
+	}
+}
+
+// readKeys sends the key presses on stdin, escape sequences of the arrow
+// keys as one, and closes keys when stdin ends.
+func readKeys(keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		keys <- string(buf[:n])
+	}
+}
+
+func (s *tuiState) refresh(cfg Config) {
+	var jobs tuiJobs
+	var pipeline pipelineStatus
+	s.err = apiGet(cfg, s.addr+"/api/jobs", &jobs)
+	if s.err == nil {
+		s.err = apiGet(cfg, s.addr+"/api/pipeline", &pipeline)
+	}
+	if s.err != nil {
+		return
+	}
+	s.jobs, s.pipeline = jobs, pipeline
+	failed := s.failed()
+	for _, j := range failed {
+		if j.ID == s.selected {
+			return
+		}
+	}
+	s.selected = ""
+	if len(failed) > 0 {
+		s.selected = failed[0].ID
+	}
+}
+
+// failed returns the jobs of the history that can be retried or
+// discarded.
+func (s *tuiState) failed() []jobStatus {
+	var failed []jobStatus
+	for _, j := range s.jobs.History {
+		if j.Status == "failed" || j.Status == "cancelled" {
+			failed = append(failed, j)
+		}
+	}
+	return failed
+}
+
+// move selects the failed job delta places below the selected one.
+func (s *tuiState) move(delta int) {
+	failed := s.failed()
+	for i, j := range failed {
+		if j.ID == s.selected {
+			s.selected = failed[max(0, min(len(failed)-1, i+delta))].ID
+			return
+		}
+	}
+}
+
+// act retries, r, or discards, d, the selected job.
+func (s *tuiState) act(cfg Config, key string) {
+	if s.selected == "" {
+		s.message = "No failed job selected"
+		return
+	}
+	action := map[string]string{"r": "retry", "d": "discard"}[key]
+	var res map[string]string
+	if err := apiDo(cfg, http.MethodPost, s.addr+"/api/jobs/"+s.selected+"/"+action, &res); err != nil {
+		s.message = err.Error()
+		return
+	}
+	s.message = fmt.Sprintf("Job %s: %s done", s.selected, action)
+}
+
+func (s *tuiState) draw(fd int) {
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	now := time.Now()
+	var lines []string
+	add := func(style, format string, args ...any) {
+		line := truncate(width, fmt.Sprintf(format, args...))
+		if style != "" {
+			line = style + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+
+	state := "running"
+	if s.pipeline.Paused {
+		state = fmt.Sprintf("paused, %d files held", s.pipeline.Held)
+	}
+	add("\x1b[1m", "scan2webdav %s  %s  queued %d jobs, %d uploads", s.addr, state, s.pipeline.Queue["jobs"], s.pipeline.Queue["uploads"])
+	if s.err != nil {
+		add("\x1b[31m", "%s", s.err)
+	}
+
+	add("", "")
+	add("\x1b[1m", "QUEUED")
+	for _, q := range s.jobs.Queued {
+		add("", "  %-10s %s", now.Sub(q.Added).Round(time.Second), filepath.Base(q.Name))
+	}
+	add("", "")
+	add("\x1b[1m", "IN FLIGHT")
+	for _, j := range s.jobs.Active {
+		step := j.State
+		if j.Step != "" {
+			step = j.Step
+		}
+		add("", "  %-12s %-14s %-10s %s", j.ID, step, now.Sub(j.Started).Round(time.Second), filepath.Base(j.Input))
+	}
+	add("", "")
+	add("\x1b[1m", "RECENT")
+	for _, j := range s.jobs.History {
+		detail, style := j.Target, ""
+		if j.Status == "failed" || j.Status == "cancelled" {
+			detail, style = j.Error, "\x1b[31m"
+			if j.ID == s.selected {
+				style = "\x1b[7m"
+			}
+		}
+		add(style, "  %-12s %-9s %s  %-8s %s  %s", j.ID, j.Status, j.Finished.Format(time.TimeOnly),
+			j.Finished.Sub(j.Started).Round(time.Second), filepath.Base(j.Input), detail)
+	}
+
+	// the help and the message stay at the bottom
+	footer := []string{truncate(width, s.message), "\x1b[2m" + truncate(width, "↑/↓ select  r retry  d discard  p pause/resume  q quit") + "\x1b[0m"}
+	if room := height - len(footer); len(lines) > room {
+		lines = lines[:max(0, room)]
+	}
+	for len(lines) < height-len(footer) {
+		lines = append(lines, "")
+	}
+	lines = append(lines, footer...)
+	// raw mode needs the carriage returns
+	fmt.Print("\x1b[H\x1b[2J" + strings.Join(lines, "\r\n"))
+}
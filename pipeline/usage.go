@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// usage is the scanning volume of a day or month.
+type usage struct {
+	Period    string `json:"period"` // 2006-01-02 or 2006-01
+	Documents int    `json:"documents"`
+	Pages     int    `json:"pages"`
+	Bytes     int64  `json:"bytes"`
+}
+
+var usageFormats = map[string]string{"day": "%Y-%m-%d", "month": "%Y-%m"}
+
+// usage sums up the uploaded documents by day or month in local time,
+// newest first.
+func (h *jobHistory) usage(by string, since time.Time) ([]usage, error) {
+	format, ok := usageFormats[by]
+	if !ok {
+		return nil, fmt.Errorf("invalid period %q", by)
+	}
+	rows, err := h.db.Query(`SELECT strftime(?, finished / 1000, 'unixepoch', 'localtime') AS period,
+		COUNT(*), SUM(pages), SUM(bytes) FROM jobs
+		WHERE status = 'done' AND finished >= ? GROUP BY period ORDER BY period DESC`, format, since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []usage
+	for rows.Next() {
+		var u usage
+		if err := rows.Scan(&u.Period, &u.Documents, &u.Pages, &u.Bytes); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+// docSize is the size of an uploaded document.
+type docSize struct {
+	Input string `json:"input"`
+	Pages int    `json:"pages"`
+	Bytes int64  `json:"bytes"`
+}
+
+// largest returns the biggest documents uploaded in a period, the ones to
+// look at if the volume is unusual.
+func (h *jobHistory) largest(from, to time.Time, n int) ([]docSize, error) {
+	rows, err := h.db.Query(`SELECT input, pages, bytes FROM jobs
+		WHERE status = 'done' AND finished >= ? AND finished < ? ORDER BY bytes DESC LIMIT ?`,
+		from.UnixMilli(), to.UnixMilli(), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []docSize
+	for rows.Next() {
+		var e docSize
+		if err := rows.Scan(&e.Input, &e.Pages, &e.Bytes); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// currentUsage returns the volume of today and of this month for the
+// metrics.
+func currentUsage() map[string]usage {
+	if history == nil {
+		return nil
+	}
+	now := time.Now()
+	cur := map[string]usage{}
+	for by, start := range map[string]time.Time{
+		"day":   time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()),
+		"month": time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()),
+	} {
+		if list, err := history.usage(by, start); err == nil && len(list) > 0 {
+			cur[by] = list[0]
+		}
+	}
+	return cur
+}
+
+// usageHandler serves the by (day or month) and since (RFC 3339)
+// parameters.
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		apiError(w, http.StatusNotFound, errors.New("job history is disabled"))
+		return
+	}
+	by := r.FormValue("by")
+	if by == "" {
+		by = "day"
+	}
+	var since time.Time
+	if v := r.FormValue("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	list, err := history.usage(by, since)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, list)
+}
+
+// usageCommand prints the scanning volume from the history database.
+func usageCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	by := flags.String("by", "day", "sum up by `period` (day or month)")
+	since := flags.Duration("since", 0, "only jobs finished within `duration`")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	h, err := openHistory(cfg.State.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+	list, err := h.usage(*by, from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(list)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PERIOD\tDOCUMENTS\tPAGES\tSIZE")
+	for _, u := range list {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f MiB\n", u.Period, u.Documents, u.Pages, float64(u.Bytes)/(1<<20))
+	}
+	w.Flush()
+	return 0
+}
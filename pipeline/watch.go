@@ -0,0 +1,37 @@
+package pipeline
+
+import (
+	"github.com/chbmuc/scan2webdav/watcher"
+)
+
+// watchRecursive tells if subfolders of the watcher path are watched.
+// Profiles select by subfolder, so they need it.
+func watchRecursive(cfg Config) bool {
+	return len(cfg.Watcher.Profiles) > 0
+}
+
+// watchFilter applies WATCHER_INCLUDE and WATCHER_EXCLUDE, the staple
+// marker always passes.
+func watchFilter(cfg Config) watcher.Filter {
+	return watcher.Filter{Include: cfg.Watcher.Include, Exclude: cfg.Watcher.Exclude, Always: cfg.Staple.Marker}
+}
+
+// watchedFile tells if path is to be processed: it passes the filter and
+// isn't a moved original.
+func watchedFile(cfg Config, path string) bool {
+	return !ignoredPath(cfg, path) && watchFilter(cfg).Match(path)
+}
+
+// processDir calls fn for the files already in the watcher path, following
+// the rules of the live watcher: subfolders only if it is recursive, and
+// neither moved originals nor filtered names.
+func processDir(cfg Config, fn func(path string)) {
+	keep := func(path string) bool {
+		return path == cfg.Watcher.Path || !ignoredPath(cfg, path)
+	}
+	watcher.Walk(cfg.Watcher.Path, watchRecursive(cfg), keep, func(path string) {
+		if watchFilter(cfg).Match(path) {
+			fn(path)
+		}
+	})
+}
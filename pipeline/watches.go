@@ -0,0 +1,281 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/chbmuc/scan2webdav/watcher"
+)
+
+// addedWatches are the directories watched in addition to the watcher path,
+// added at runtime through the API. They have to be below WATCHER_ADD_ROOT
+// and need HTTP_TOKEN, as their files are uploaded and deleted. They are
+// kept in the state directory and watched again after a restart. Profiles
+// only apply below the watcher path.
+var addedWatches = struct {
+	sync.Mutex
+	cfg    Config
+	handle func(path string)
+	dirs   map[string]*addedWatch
+}{dirs: map[string]*addedWatch{}}
+
+type addedWatch struct {
+	w    *watcher.Watcher
+	done chan struct{}
+}
+
+// watchList is the answer of the watches API.
+type watchList struct {
+	Path  string   `json:"path"` // the watcher path
+	Added []string `json:"added"`
+}
+
+func watchesFile(stateDir string) string {
+	return filepath.Join(stateDir, "watches.json")
+}
+
+// startAddedWatches watches the directories added before, handle gets
+// their new and existing files.
+func startAddedWatches(cfg Config, handle func(path string)) {
+	addedWatches.Lock()
+	addedWatches.cfg = cfg
+	addedWatches.handle = handle
+	addedWatches.Unlock()
+
+	data, err := os.ReadFile(watchesFile(cfg.State.Path))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var dirs []string
+	if err == nil {
+		err = json.Unmarshal(data, &dirs)
+	}
+	if err != nil {
+		slog.Warn("Unable to read added watches", "error", err)
+		return
+	}
+	for _, dir := range dirs {
+		dir, err := allowedWatch(cfg, dir)
+		if err == nil {
+			err = watchDir(dir)
+		}
+		if err != nil {
+			slog.Warn("Unable to watch added directory", "path", dir, "error", err)
+		}
+	}
+}
+
+// stopAddedWatches ends the watches without forgetting them.
+func stopAddedWatches() {
+	addedWatches.Lock()
+	defer addedWatches.Unlock()
+	for dir, a := range addedWatches.dirs {
+		a.w.Stop()
+		close(a.done)
+		delete(addedWatches.dirs, dir)
+	}
+}
+
+// addWatch starts watching dir and remembers it.
+func addWatch(dir string) error {
+	addedWatches.Lock()
+	cfg := addedWatches.cfg
+	addedWatches.Unlock()
+	dir, err := allowedWatch(cfg, dir)
+	if err != nil {
+		return err
+	}
+	if err := watchDir(dir); err != nil {
+		return err
+	}
+	return saveWatches()
+}
+
+// allowedWatch checks that dir may be watched, below WATCHER_ADD_ROOT and
+// apart from the watcher path, and returns it with symlinks resolved.
+func allowedWatch(cfg Config, dir string) (string, error) {
+	if cfg.Watcher.AddRoot == "" {
+		return "", errors.New("adding watches needs WATCHER_ADD_ROOT")
+	}
+	if !filepath.IsAbs(dir) {
+		return "", errors.New("path must be absolute")
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(cfg.Watcher.AddRoot)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, dir) {
+		return "", fmt.Errorf("path is outside of %s", cfg.Watcher.AddRoot)
+	}
+	if within(cfg.Watcher.Path, dir) || within(dir, cfg.Watcher.Path) {
+		return "", errors.New("path overlaps the watcher path")
+	}
+	return dir, nil
+}
+
+// removeWatch stops watching dir and forgets it.
+func removeWatch(dir string) error {
+	dir = filepath.Clean(dir)
+	addedWatches.Lock()
+	a, ok := addedWatches.dirs[dir]
+	if ok {
+		a.w.Stop()
+		close(a.done)
+		delete(addedWatches.dirs, dir)
+	}
+	addedWatches.Unlock()
+	if !ok {
+		return fmt.Errorf("%s is not an added watch", dir)
+	}
+	slog.Info("Stopped watching", "path", dir)
+	return saveWatches()
+}
+
+func watchDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("path is not a directory")
+	}
+
+	addedWatches.Lock()
+	defer addedWatches.Unlock()
+	cfg, handle := addedWatches.cfg, addedWatches.handle
+	if handle == nil {
+		return errors.New("the watcher isn't running yet")
+	}
+	if _, ok := addedWatches.dirs[dir]; ok {
+		return fmt.Errorf("%s is watched already", dir)
+	}
+	w, err := watcher.Watch(dir, false, cfg.Watcher.Events)
+	if err != nil {
+		return err
+	}
+	a := &addedWatch{w: w, done: make(chan struct{})}
+	addedWatches.dirs[dir] = a
+	go func() {
+		for {
+			select {
+			case path := <-w.Events:
+				handle(path)
+			case <-a.done:
+				return
+			}
+		}
+	}()
+	slog.Info("Watching", "path", dir)
+
+	// the files that are there already
+	keep := func(path string) bool { return path == dir || !ignoredPath(cfg, path) }
+	go watcher.Walk(dir, false, keep, handle)
+	return nil
+}
+
+func saveWatches() error {
+	data, err := json.Marshal(addedDirs())
+	if err != nil {
+		return err
+	}
+	addedWatches.Lock()
+	file := watchesFile(addedWatches.cfg.State.Path)
+	addedWatches.Unlock()
+	return os.WriteFile(file, data, 0600)
+}
+
+// addedDirs returns the added directories in order.
+func addedDirs() []string {
+	addedWatches.Lock()
+	defer addedWatches.Unlock()
+	dirs := []string{}
+	for dir := range addedWatches.dirs {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+// inAddedWatch tells if path is a file in one of the added directories.
+func inAddedWatch(path string) bool {
+	return slices.Contains(addedDirs(), filepath.Dir(path))
+}
+
+// watchesHandler lists the watched directories, after adding or removing
+// the path parameter for POST and DELETE.
+func watchesHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir := r.FormValue("path")
+		if r.Method != http.MethodGet && cfg.Http.Token == "" {
+			apiError(w, http.StatusForbidden, errors.New("changing watches needs HTTP_TOKEN"))
+			return
+		}
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			err = addWatch(dir)
+		case http.MethodDelete:
+			err = removeWatch(dir)
+		}
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, watchList{Path: cfg.Watcher.Path, Added: addedDirs()})
+	}
+}
+
+// watchesCommand lists, adds or removes watched directories of the
+// running daemon.
+func watchesCommand(cfg Config, args []string) int {
+	flags := flag.NewFlagSet("watches", flag.ContinueOnError)
+	addr := flags.String("url", apiURL(cfg), "`URL` of the daemon")
+	add := flags.String("add", "", "watch the directory `path` as well")
+	remove := flags.String("remove", "", "stop watching the added directory `path`")
+	asJSON := flags.Bool("json", false, "print JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	method, dir := http.MethodGet, ""
+	switch {
+	case *add != "":
+		method, dir = http.MethodPost, *add
+	case *remove != "":
+		method, dir = http.MethodDelete, *remove
+	}
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		dir = abs
+	}
+	var list watchList
+	if err := apiDo(cfg, method, *addr+"/api/watches?"+url.Values{"path": {dir}}.Encode(), &list); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(list)
+	}
+	fmt.Println(list.Path)
+	for _, d := range list.Added {
+		fmt.Println(d)
+	}
+	return 0
+}
@@ -0,0 +1,5 @@
+// Package rpc holds the gRPC service of the daemon, generated from
+// scan2webdav.proto.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative scan2webdav.proto
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrNotSeekable = errors.New("checksums need a seekable upload")
+
+// checksums of a local file, hex encoded.
+type checksums struct {
+	sha1 string
+	md5  string
+}
+
+// readChecksums hashes r and rewinds it for the upload.
+func readChecksums(r io.ReadSeeker) (checksums, error) {
+	s, m := sha1.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(s, m), r); err != nil {
+		return checksums{}, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return checksums{}, err
+	}
+	return checksums{
+		sha1: hex.EncodeToString(s.Sum(nil)),
+		md5:  hex.EncodeToString(m.Sum(nil)),
+	}, nil
+}
+
+// mtimeSkew is the clock difference to the server tolerated when checking
+// the modification time of an upload.
+const mtimeSkew = 5 * time.Minute
+
+// verify confirms that the remote copy at url is intact. In etag mode the
+// server is expected to return the MD5 of the content as ETag. Otherwise
+// the file is requested again with HEAD and its OC-Checksum header, its size
+// and, with SERVER_VERIFY, its modification time are checked, which catches
+// proxies that acknowledge truncated bodies.
+func (s *WebDAV) verify(ctx context.Context, url string, res *http.Response, size int64, sums checksums, start time.Time) error {
+	if s.Checksum == "etag" {
+		etag := strings.Trim(strings.TrimPrefix(res.Header.Get("ETag"), "W/"), `"`)
+		if !strings.EqualFold(etag, sums.md5) {
+			return fmt.Errorf("checksum mismatch for %s: etag %q, expected md5 %s", url, etag, sums.md5)
+		}
+		if !s.Verify {
+			return nil
+		}
+	}
+
+	head, err := s.Do(ctx, http.MethodHead, url, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	head.Body.Close()
+	if head.StatusCode < 200 || head.StatusCode >= 300 {
+		return &StatusError{Op: "verifying", Path: url, Status: head.Status, Code: head.StatusCode}
+	}
+
+	// OC-Checksum may list several algorithms: "SHA1:... MD5:..."
+	if remote := head.Header.Get("OC-Checksum"); remote != "" && sums.sha1 != "" {
+		for _, sum := range strings.Fields(remote) {
+			algo, value, _ := strings.Cut(sum, ":")
+			var local string
+			switch strings.ToUpper(algo) {
+			case "SHA1":
+				local = sums.sha1
+			case "MD5":
+				local = sums.md5
+			default:
+				continue
+			}
+			if !strings.EqualFold(value, local) {
+				return fmt.Errorf("checksum mismatch for %s: %s", url, sum)
+			}
+			break
+		}
+	}
+
+	if head.ContentLength != size {
+		return fmt.Errorf("size mismatch for %s: %d bytes on the server, expected %d", url, head.ContentLength, size)
+	}
+
+	if s.Verify {
+		modified, err := http.ParseTime(head.Header.Get("Last-Modified"))
+		if err == nil && modified.Before(start.Add(-mtimeSkew)) {
+			return fmt.Errorf("%s was not replaced, last modified %s", url, modified)
+		}
+	}
+	return nil
+}
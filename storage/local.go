@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local moves documents into a local directory, e.g. the consume
+// folder of another tool or a synced directory. Files are written under a
+// temporary name and renamed once complete so watchers never see partial
+// documents, which also works across devices.
+type Local struct {
+	Root string
+}
+
+func (s *Local) path(remotePath string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(remotePath))
+}
+
+func (s *Local) Put(ctx context.Context, remotePath string, r io.Reader, size int64) error {
+	dst := s.path(remotePath)
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func (s *Local) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := os.Stat(s.path(remotePath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Local) Mkdir(ctx context.Context, remotePath string) error {
+	return os.MkdirAll(s.path(remotePath), 0755)
+}
+
+func (s *Local) Size(ctx context.Context, remotePath string) (int64, error) {
+	fi, err := os.Stat(s.path(remotePath))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// syncDir flushes the directory entry of a renamed file to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const lockInfo = `<?xml version="1.0" encoding="utf-8"?>
+<d:lockinfo xmlns:d="DAV:"><d:lockscope><d:exclusive/></d:lockscope><d:locktype><d:write/></d:locktype><d:owner>scan2webdav</d:owner></d:lockinfo>`
+
+// lock takes an exclusive write lock on url, so sync clients don't read
+// the file while it is uploaded. It returns the lock token, or an empty
+// token if the server doesn't support locking.
+func (s *WebDAV) lock(ctx context.Context, url string) (string, error) {
+	res, err := s.SendLock(ctx, url, 10*time.Minute, "")
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented:
+		slog.WarnContext(ctx, "Server doesn't support locking, uploading without lock", "url", url)
+		return "", nil
+	case res.StatusCode < 200 || res.StatusCode >= 300:
+		return "", &StatusError{Op: "locking", Path: url, Status: res.Status, Code: res.StatusCode}
+	}
+	return res.Header.Get("Lock-Token"), nil
+}
+
+// SendLock requests an exclusive write lock on url for timeout, or
+// refreshes the lock with token. The caller closes the response body.
+func (s *WebDAV) SendLock(ctx context.Context, url string, timeout time.Duration, token string) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Timeout", fmt.Sprintf("Second-%d", int(timeout.Seconds())))
+	if token != "" {
+		// a refresh has no body
+		header.Set("If", "(<"+strings.Trim(token, "<>")+">)")
+		return s.Do(ctx, "LOCK", url, nil, 0, header)
+	}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Depth", "0")
+	return s.Do(ctx, "LOCK", url, strings.NewReader(lockInfo), int64(len(lockInfo)), header)
+}
+
+// Unlock releases the lock even if the upload was cancelled. A lock that
+// can't be released expires with its timeout.
+func (s *WebDAV) Unlock(ctx context.Context, url string, token string) {
+	ctx = context.WithoutCancel(ctx)
+	header := http.Header{}
+	header.Set("Lock-Token", token)
+	res, err := s.Do(ctx, "UNLOCK", url, nil, 0, header)
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to unlock", "url", url, "error", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		slog.WarnContext(ctx, "Unable to unlock", "url", url, "status", res.StatusCode)
+	}
+}
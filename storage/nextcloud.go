@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chunkPath is the part of a Nextcloud WebDAV URL in front of the user.
+const chunkPath = "/remote.php/dav/files/"
+
+// chunkedUpload uploads r to target with the Nextcloud chunking API: the chunks
+// go to a transfer collection below uploads/<user>, which is then moved to
+// its destination in one piece. Every request carries the final
+// destination so the server can check quota and permissions early.
+func (s *WebDAV) chunkedUpload(ctx context.Context, r io.ReaderAt, target string, size int64, lock string) (*http.Response, error) {
+	base := s.Root
+	idx := strings.Index(base, chunkPath)
+	if idx < 0 {
+		return nil, fmt.Errorf("chunked uploads need a Nextcloud URL containing %s", chunkPath)
+	}
+	user, _, _ := strings.Cut(base[idx+len(chunkPath):], "/")
+	transfer := base[:idx] + "/remote.php/dav/uploads/" + user + "/scan2webdav-" + rand.Text()
+
+	header := http.Header{}
+	header.Set("Destination", target)
+	header.Set("OC-Total-Length", strconv.FormatInt(size, 10))
+
+	if err := s.chunkRequest(ctx, "MKCOL", transfer, nil, 0, header); err != nil {
+		return nil, err
+	}
+
+	chunkSize := s.ChunkSize
+	for n, offset := 1, int64(0); offset < size; n, offset = n+1, offset+chunkSize {
+		chunk := io.NewSectionReader(r, offset, chunkSize)
+		if err := s.chunkRequest(ctx, http.MethodPut, fmt.Sprintf("%s/%05d", transfer, n), chunk, chunk.Size(), header); err != nil {
+			s.cancelChunks(ctx, transfer)
+			return nil, err
+		}
+	}
+
+	if lock != "" {
+		// the lock is on the destination, not on the request URL
+		header.Set("If", "<"+target+"> ("+lock+")")
+	}
+	res, err := s.Do(ctx, "MOVE", transfer+"/.file", nil, 0, header)
+	if err != nil {
+		s.cancelChunks(ctx, transfer)
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		s.cancelChunks(ctx, transfer)
+		return nil, &StatusError{Op: "assembling chunks for", Path: target, Status: res.Status, Code: res.StatusCode}
+	}
+	return res, nil
+}
+
+// chunkRequest sends one request of a chunked upload.
+func (s *WebDAV) chunkRequest(ctx context.Context, method string, target string, body io.Reader, size int64, header http.Header) error {
+	res, err := s.Do(ctx, method, target, body, size, header)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &StatusError{Op: "chunked upload", Path: target, Status: res.Status, Code: res.StatusCode}
+	}
+	return nil
+}
+
+// cancelChunks removes the transfer collection of a failed chunked upload.
+func (s *WebDAV) cancelChunks(ctx context.Context, transfer string) {
+	ctx = context.WithoutCancel(ctx)
+	res, err := s.Do(ctx, http.MethodDelete, transfer, nil, 0, nil)
+	if err != nil {
+		slog.WarnContext(ctx, "Unable to remove chunks", "url", transfer, "error", err)
+		return
+	}
+	res.Body.Close()
+}
+
+const (
+	fileIdProps = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:prop><oc:fileid/></d:prop></d:propfind>`
+	systemTagProps = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:prop><oc:id/><oc:display-name/></d:prop></d:propfind>`
+	favoriteProps = `<?xml version="1.0"?>
+<d:propertyupdate xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:set><d:prop><oc:favorite>1</oc:favorite></d:prop></d:set></d:propertyupdate>`
+)
+
+// annotate applies the document tags as Nextcloud system tags and marks the
+// file at target as favorite, as configured. Failures are only logged since
+// the upload itself succeeded.
+func (s *WebDAV) annotate(ctx context.Context, target string) {
+	if s.Meta == nil {
+		return
+	}
+	if s.Favorite || s.Meta.Favorite {
+		if err := s.favorite(ctx, target); err != nil {
+			slog.WarnContext(ctx, "Unable to mark as favorite", "url", target, "error", err)
+		}
+	}
+	if !s.Tags || len(s.Meta.Tags) == 0 {
+		return
+	}
+	if err := s.tag(ctx, target, s.Meta.Tags); err != nil {
+		slog.WarnContext(ctx, "Unable to tag", "url", target, "error", err)
+	}
+}
+
+func (s *WebDAV) favorite(ctx context.Context, target string) error {
+	return s.Props(ctx, "PROPPATCH", target, "1", favoriteProps, nil)
+}
+
+// tag assigns the system tags to the file at target, creating missing tags.
+func (s *WebDAV) tag(ctx context.Context, target string, tags []string) error {
+	idx := strings.Index(s.Root, chunkPath)
+	if idx < 0 {
+		return fmt.Errorf("tags need a Nextcloud URL containing %s", chunkPath)
+	}
+	dav := s.Root[:idx] + "/remote.php/dav"
+
+	var files []map[string]string
+	if err := s.Props(ctx, "PROPFIND", target, "1", fileIdProps, &files); err != nil {
+		return err
+	}
+	if len(files) == 0 || files[0]["fileid"] == "" {
+		return fmt.Errorf("no file id for %s", target)
+	}
+	fileId := files[0]["fileid"]
+
+	var known []map[string]string
+	if err := s.Props(ctx, "PROPFIND", dav+"/systemtags/", "1", systemTagProps, &known); err != nil {
+		return err
+	}
+	for _, name := range tags {
+		id := ""
+		for _, t := range known {
+			if strings.EqualFold(t["display-name"], name) {
+				id = t["id"]
+			}
+		}
+		if id == "" {
+			var err error
+			if id, err = s.createTag(ctx, dav, name); err != nil {
+				return err
+			}
+		}
+
+		res, err := s.Do(ctx, http.MethodPut, dav+"/systemtags-relations/files/"+fileId+"/"+id, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		// 409 means the tag is assigned already
+		if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusConflict {
+			return &StatusError{Op: "tagging", Path: target, Status: res.Status, Code: res.StatusCode}
+		}
+	}
+	return nil
+}
+
+// Share creates a public link share of remotePath with the OCS sharing API
+// and returns its URL. It is protected by password and expires on the day
+// of expire unless they are empty or zero.
+func (s *WebDAV) Share(ctx context.Context, remotePath string, password string, expire time.Time) (string, error) {
+	base := s.Root
+	idx := strings.Index(base, chunkPath)
+	if idx < 0 {
+		return "", fmt.Errorf("share links need a Nextcloud URL containing %s", chunkPath)
+	}
+	// the path is relative to the files of the user
+	_, root, _ := strings.Cut(base[idx+len(chunkPath):], "/")
+	root, err := url.PathUnescape(root)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("path", path.Join("/", root, remotePath))
+	form.Set("shareType", "3") // public link
+	if password != "" {
+		form.Set("password", password)
+	}
+	if !expire.IsZero() {
+		form.Set("expireDate", expire.Format("2006-01-02"))
+	}
+	body := form.Encode()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	header.Set("Accept", "application/json")
+	header.Set("OCS-APIRequest", "true")
+	endpoint := base[:idx] + "/ocs/v2.php/apps/files_sharing/api/v1/shares"
+	res, err := s.Do(ctx, http.MethodPost, endpoint, strings.NewReader(body), int64(len(body)), header)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var reply struct {
+		Ocs struct {
+			Meta struct {
+				Message string `json:"message"`
+			} `json:"meta"`
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		} `json:"ocs"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&reply)
+	if res.StatusCode != http.StatusOK {
+		status := res.Status
+		if msg := reply.Ocs.Meta.Message; msg != "" {
+			status += ": " + msg
+		}
+		return "", &StatusError{Op: "sharing", Path: remotePath, Status: status, Code: res.StatusCode}
+	}
+	if err != nil {
+		return "", err
+	}
+	if reply.Ocs.Data.URL == "" {
+		return "", errors.New("no share URL in the reply")
+	}
+	return reply.Ocs.Data.URL, nil
+}
+
+// createTag creates a visible and assignable system tag and returns its id.
+func (s *WebDAV) createTag(ctx context.Context, dav string, name string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"name":           name,
+		"userVisible":    true,
+		"userAssignable": true,
+		"canAssign":      true,
+	})
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	res, err := s.Do(ctx, http.MethodPost, dav+"/systemtags/", bytes.NewReader(body), int64(len(body)), header)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		return "", &StatusError{Op: "creating tag", Path: name, Status: res.Status, Code: res.StatusCode}
+	}
+	slog.InfoContext(ctx, "Created Nextcloud tag", "tag", name)
+	return path.Base(res.Header.Get("Content-Location")), nil
+}
+
+// Props sends a PROPFIND or PROPPATCH request. If props isn't nil the
+// multistatus reply is parsed into it, one map of property values per
+// response, keyed by the local property name.
+func (s *WebDAV) Props(ctx context.Context, method string, target string, depth string, body string, props *[]map[string]string) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Depth", depth)
+	res, err := s.Do(ctx, method, target, strings.NewReader(body), int64(len(body)), header)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusMultiStatus && (res.StatusCode < 200 || res.StatusCode >= 300) {
+		return &StatusError{Op: strings.ToLower(method), Path: target, Status: res.Status, Code: res.StatusCode}
+	}
+	if props == nil {
+		return nil
+	}
+
+	decoder := xml.NewDecoder(res.Body)
+	var current map[string]string
+	var name string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			if t.Name.Space == "DAV:" && name == "response" {
+				current = map[string]string{}
+				*props = append(*props, current)
+			}
+		case xml.CharData:
+			if current != nil && name != "" {
+				current[name] += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+}
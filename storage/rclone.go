@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Rclone hands documents to rclone, which gives access to all of its
+// providers.
+type Rclone struct {
+	Exec   string // rclone binary
+	Config string // rclone.conf, rclone's default if empty
+	Root   string // remote:path
+	// Tokens supplies the OAuth token of the remote, rclone's own if nil
+	Tokens oauth2.TokenSource
+}
+
+func (s *Rclone) remote(remotePath string) string {
+	if remotePath = strings.Trim(remotePath, "/"); remotePath == "" {
+		return s.Root
+	}
+	if strings.HasSuffix(s.Root, ":") {
+		return s.Root + remotePath
+	}
+	return strings.TrimSuffix(s.Root, "/") + "/" + remotePath
+}
+
+// run executes rclone with stdin as input.
+func (s *Rclone) run(ctx context.Context, stdin io.Reader, args ...string) error {
+	if s.Config != "" {
+		args = append([]string{"--config", s.Config}, args...)
+	}
+	cmd := exec.CommandContext(ctx, s.Exec, args...)
+	env, err := s.env()
+	if err != nil {
+		return err
+	}
+	cmd.Env = env
+	cmd.Stdin = stdin
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rclone %s: %w: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// env hands the OAuth token to rclone as the token of the remote, so
+// cloud providers can use the one of the login command. Without Tokens
+// rclone inherits the environment as usual.
+func (s *Rclone) env() ([]string, error) {
+	if s.Tokens == nil {
+		return nil, nil
+	}
+	t, err := s.Tokens.Token()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	name, _, _ := strings.Cut(s.Root, ":")
+	key := "RCLONE_CONFIG_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_TOKEN"
+	return append(os.Environ(), key+"="+string(data)), nil
+}
+
+// Put streams r to rclone rcat; rclone creates missing directories itself.
+func (s *Rclone) Put(ctx context.Context, remotePath string, r io.Reader, size int64) error {
+	return s.run(ctx, r, "rcat", "--size", strconv.FormatInt(size, 10), s.remote(remotePath))
+}
+
+// Exists uses rclone's exit codes: 3 for a missing directory and 4 for a
+// missing file.
+func (s *Rclone) Exists(ctx context.Context, remotePath string) (bool, error) {
+	err := s.run(ctx, nil, "lsjson", "--stat", s.remote(remotePath))
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && (exit.ExitCode() == 3 || exit.ExitCode() == 4) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Rclone) Mkdir(ctx context.Context, remotePath string) error {
+	return s.run(ctx, nil, "mkdir", s.remote(path.Clean(remotePath)))
+}
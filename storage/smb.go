@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hirochachacha/go-smb2"
+)
+
+// Share is a Windows file share reached with the SMB client of go-smb2.
+type Share struct {
+	addr   string // host:port
+	share  string // \\host\share
+	root   string // directory below the share
+	user   string
+	pass   string
+	domain string
+}
+
+// NewShare returns the share of rawURL, which has the form
+// smb://host[:port]/share[/path]. The user may be DOMAIN\user.
+func NewShare(rawURL string, user string, pass string) (*Share, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	share, root, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if share == "" {
+		return nil, fmt.Errorf("no share in %s", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "445"
+	}
+	domain := ""
+	if d, name, ok := strings.Cut(user, `\`); ok {
+		domain, user = d, name
+	}
+	return &Share{
+		addr:   net.JoinHostPort(u.Hostname(), port),
+		share:  `\\` + u.Hostname() + `\` + share,
+		root:   strings.Trim(root, "/"),
+		user:   user,
+		pass:   pass,
+		domain: domain,
+	}, nil
+}
+
+// Mount runs fn on the share, connecting for every call, as polls are
+// minutes apart and uploads few.
+func (s *Share) Mount(ctx context.Context, fn func(fs *smb2.Share) error) error {
+	d := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dialer := &smb2.Dialer{Initiator: &smb2.NTLMInitiator{User: s.user, Password: s.pass, Domain: s.domain}}
+	session, err := dialer.DialContext(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer session.Logoff()
+	fs, err := session.WithContext(ctx).Mount(s.share)
+	if err != nil {
+		return err
+	}
+	defer fs.Umount()
+	return fn(fs.WithContext(ctx))
+}
+
+// Path returns remotePath below the root.
+func (s *Share) Path(remotePath string) string {
+	return strings.Trim(path.Join(s.root, remotePath), "/")
+}
+
+// SMB writes documents to a Windows file share.
+type SMB struct {
+	*Share
+}
+
+func (s *SMB) Put(ctx context.Context, remotePath string, r io.Reader, size int64) error {
+	return s.Mount(ctx, func(fs *smb2.Share) error {
+		name := s.Path(remotePath)
+		f, err := fs.Create(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return &StatusError{Op: "upload", Path: remotePath, Status: "missing directory", Code: http.StatusConflict}
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			fs.Remove(name)
+			return err
+		}
+		return f.Close()
+	})
+}
+
+func (s *SMB) Exists(ctx context.Context, remotePath string) (bool, error) {
+	var found bool
+	err := s.Mount(ctx, func(fs *smb2.Share) error {
+		_, err := fs.Stat(s.Path(remotePath))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		found = err == nil
+		return err
+	})
+	return found, err
+}
+
+func (s *SMB) Mkdir(ctx context.Context, remotePath string) error {
+	return s.Mount(ctx, func(fs *smb2.Share) error {
+		return fs.MkdirAll(s.Path(remotePath), 0755)
+	})
+}
@@ -0,0 +1,58 @@
+// Package storage uploads documents to WebDAV servers, Nextcloud among
+// them, local directories, SMB shares, rclone remotes and paperless-ngx.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Storage is an upload target. Remote paths are relative to the root of
+// the target and use forward slashes.
+type Storage interface {
+	// Put stores size bytes read from r at remotePath.
+	Put(ctx context.Context, remotePath string, r io.Reader, size int64) error
+	// Exists reports whether remotePath is present.
+	Exists(ctx context.Context, remotePath string) (bool, error)
+	// Mkdir creates the directory remotePath and any missing parents.
+	Mkdir(ctx context.Context, remotePath string) error
+}
+
+// Sizer is implemented by storages that can report the size of a remote
+// file. A negative size means the server didn't tell.
+type Sizer interface {
+	Size(ctx context.Context, remotePath string) (int64, error)
+}
+
+// StatusError is returned when the server answers with an unexpected
+// status code.
+type StatusError struct {
+	Op     string
+	Path   string
+	Status string
+	Code   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s failed: %s", e.Op, e.Path, e.Status)
+}
+
+// Metadata describes a document for the backends that store it along with
+// the file.
+type Metadata struct {
+	Title         string
+	Date          string // YYYY-MM-DD
+	Correspondent string
+	Category      string
+	Tags          []string
+	Favorite      bool
+}
+
+// Entry is a member of a directory, with its path relative to the root.
+type Entry struct {
+	Path       string
+	Collection bool
+	ETag       string
+	Size       int64
+}
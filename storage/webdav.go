@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// WebDAV uploads to a WebDAV server with plain PUT requests. The zero
+// values of the options turn the extras off.
+type WebDAV struct {
+	Root string // URL of the root collection
+	// Client sends the requests, http.DefaultClient if nil
+	Client *http.Client
+	// Authorize adds the credentials to a request, none if nil
+	Authorize func(req *http.Request) error
+
+	Form     bool   // multipart form instead of a plain PUT
+	Checksum string // sha1 or etag, verify the upload with checksums
+	Verify   bool   // check size and mtime with HEAD
+	Lock     bool   // LOCK the target during the upload
+	// uploads larger than ChunkThreshold use the Nextcloud chunking API
+	// with chunks of ChunkSize
+	ChunkThreshold int64
+	ChunkSize      int64
+
+	Favorite bool      // mark every upload as Nextcloud favorite
+	Tags     bool      // apply the tags of Meta as Nextcloud system tags
+	Meta     *Metadata // of the document being uploaded, may be nil
+}
+
+// URL returns the absolute URL of remotePath with every segment escaped.
+func (s *WebDAV) URL(remotePath string) string {
+	u := s.Root
+	for _, segment := range strings.Split(remotePath, "/") {
+		if segment != "" {
+			u = u + "/" + url.PathEscape(segment)
+		}
+	}
+	return u
+}
+
+// Do sends an authorized request. The caller closes the response body.
+func (s *WebDAV) Do(ctx context.Context, method string, target string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	if body != nil && size == 0 {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.Authorize != nil {
+		if err := s.Authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	c := s.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	return c.Do(req)
+}
+
+// Put uploads r to remotePath. Large files go through the Nextcloud
+// chunking API if a threshold is set, and the upload is verified if a
+// checksum mode is set; both need r to be a file.
+func (s *WebDAV) Put(ctx context.Context, remotePath string, r io.Reader, size int64) error {
+	target := s.URL(remotePath)
+
+	var sums checksums
+	if s.Checksum != "" {
+		rs, ok := r.(io.ReadSeeker)
+		if !ok {
+			return ErrNotSeekable
+		}
+		var err error
+		if sums, err = readChecksums(rs); err != nil {
+			return err
+		}
+	}
+
+	var lock string
+	if s.Lock {
+		var err error
+		if lock, err = s.lock(ctx, target); err != nil {
+			return err
+		}
+		if lock != "" {
+			defer s.Unlock(ctx, target, lock)
+		}
+	}
+
+	start := time.Now()
+	ra, seekable := r.(io.ReaderAt)
+	var res *http.Response
+	var err error
+	if seekable && s.ChunkThreshold > 0 && size > s.ChunkThreshold {
+		res, err = s.chunkedUpload(ctx, ra, target, size, lock)
+	} else {
+		res, err = s.put(ctx, r, size, target, sums, lock)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.Checksum != "" || s.Verify {
+		if err := s.verify(ctx, target, res, size, sums, start); err != nil {
+			return err
+		}
+	}
+	s.annotate(ctx, target)
+	return nil
+}
+
+// put sends r to target in a single PUT request.
+func (s *WebDAV) put(ctx context.Context, r io.Reader, size int64, target string, sums checksums, lock string) (*http.Response, error) {
+	body, length := r, size
+	contentType := mime.TypeByExtension(path.Ext(target))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if s.Form {
+		body, contentType, length = formBody("file", path.Base(target), r, size, nil)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	if sums.sha1 != "" {
+		header.Set("OC-Checksum", "SHA1:"+sums.sha1)
+	}
+	if lock != "" {
+		header.Set("If", "("+lock+")")
+	}
+
+	res, err := s.Do(ctx, http.MethodPut, target, body, length, header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err == nil {
+			slog.DebugContext(ctx, "Upload rejected", "url", target, "status", res.StatusCode, "body", string(bodyBytes))
+		}
+		return nil, &StatusError{Op: "upload", Path: target, Status: res.Status, Code: res.StatusCode}
+	}
+
+	return res, nil
+}
+
+// formBody wraps file in a multipart form for servers that expect form
+// uploads instead of a plain WebDAV PUT. The values are sent as fields in
+// front of the file. Only the fields and the part headers are buffered, the
+// file itself is streamed, so the length of the whole body is known up
+// front.
+func formBody(field string, name string, file io.Reader, size int64, values url.Values) (io.Reader, string, int64) {
+	head := bytes.NewBuffer(nil)
+	bodyWriter := multipart.NewWriter(head)
+
+	for key, vals := range values {
+		for _, v := range vals {
+			bodyWriter.WriteField(key, v)
+		}
+	}
+	// CreatePart only writes the part header, which can't fail on a
+	// buffer. FormatMediaType switches to the RFC 2231 filename* form for
+	// names that aren't ASCII.
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": field, "filename": name}))
+	header.Set("Content-Type", "application/octet-stream")
+	bodyWriter.CreatePart(header)
+	headLen := head.Len()
+
+	// This is mandatory as it writes the closing boundary.
+	bodyWriter.Close()
+	tail := bytes.NewReader(head.Bytes()[headLen:])
+	head.Truncate(headLen)
+
+	length := int64(head.Len()) + size + int64(tail.Len())
+	return io.MultiReader(head, file, tail), bodyWriter.FormDataContentType(), length
+}
+
+// Exists checks whether remotePath already exists on the server.
+func (s *WebDAV) Exists(ctx context.Context, remotePath string) (bool, error) {
+	target := s.URL(remotePath)
+	res, err := s.Do(ctx, http.MethodHead, target, nil, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return true, nil
+	}
+	return false, &StatusError{Op: "check", Path: target, Status: res.Status, Code: res.StatusCode}
+}
+
+// Mkdir creates the collections up to remotePath that don't exist yet.
+func (s *WebDAV) Mkdir(ctx context.Context, remotePath string) error {
+	current := ""
+	for _, segment := range strings.Split(remotePath, "/") {
+		if segment == "" {
+			continue
+		}
+		current = path.Join(current, segment)
+
+		target := s.URL(current)
+		res, err := s.Do(ctx, "MKCOL", target, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		// 405 means the collection exists already
+		switch res.StatusCode {
+		case http.StatusCreated:
+			slog.InfoContext(ctx, "Created collection", "url", target)
+		case http.StatusMethodNotAllowed:
+		default:
+			return &StatusError{Op: "creating collection", Path: target, Status: res.Status, Code: res.StatusCode}
+		}
+	}
+	return nil
+}
+
+func (s *WebDAV) Size(ctx context.Context, remotePath string) (int64, error) {
+	target := s.URL(remotePath)
+	res, err := s.Do(ctx, http.MethodHead, target, nil, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return 0, &StatusError{Op: "checking", Path: target, Status: res.Status, Code: res.StatusCode}
+	}
+	return res.ContentLength, nil
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getetag/><d:getcontentlength/></d:prop></d:propfind>`
+
+// List returns the members of the collection remotePath.
+func (s *WebDAV) List(ctx context.Context, remotePath string) ([]Entry, error) {
+	u := s.URL(remotePath) + "/"
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}
+	res, err := s.Do(ctx, "PROPFIND", u, strings.NewReader(propfindBody), int64(len(propfindBody)), header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, &StatusError{Op: "listing", Path: u, Status: res.Status, Code: res.StatusCode}
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href       string    `xml:"href"`
+			Collection *struct{} `xml:"propstat>prop>resourcetype>collection"`
+			ETag       string    `xml:"propstat>prop>getetag"`
+			Size       int64     `xml:"propstat>prop>getcontentlength"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSuffix(base.Path, "/")
+	self := strings.Trim(remotePath, "/")
+	var entries []Entry
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		p := strings.Trim(strings.TrimPrefix(href.Path, root), "/")
+		if p == self {
+			continue
+		}
+		entries = append(entries, Entry{Path: p, Collection: r.Collection != nil, ETag: r.ETag, Size: r.Size})
+	}
+	return entries, nil
+}
+
+// Get downloads remotePath to filename.
+func (s *WebDAV) Get(ctx context.Context, remotePath string, filename string) error {
+	u := s.URL(remotePath)
+	res, err := s.Do(ctx, http.MethodGet, u, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return &StatusError{Op: "download", Path: u, Status: res.Status, Code: res.StatusCode}
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Remove deletes remotePath.
+func (s *WebDAV) Remove(ctx context.Context, remotePath string) error {
+	return s.send(ctx, http.MethodDelete, remotePath, http.Header{})
+}
+
+// Rename moves remotePath to newPath without overwriting it.
+func (s *WebDAV) Rename(ctx context.Context, remotePath string, newPath string) error {
+	header := http.Header{}
+	header.Set("Destination", s.URL(newPath))
+	header.Set("Overwrite", "F")
+	return s.send(ctx, "MOVE", remotePath, header)
+}
+
+// send sends a request without body to remotePath and expects a success.
+func (s *WebDAV) send(ctx context.Context, method string, remotePath string, header http.Header) error {
+	u := s.URL(remotePath)
+	res, err := s.Do(ctx, method, u, nil, 0, header)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &StatusError{Op: strings.ToLower(method), Path: u, Status: res.Status, Code: res.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebDAVURL(t *testing.T) {
+	s := &WebDAV{Root: "https://cloud.example/remote.php/dav/files/user"}
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"plain", "Scans/Rechnung.pdf", "/Scans/Rechnung.pdf"},
+		{"extra slashes", "/Scans//Rechnung.pdf/", "/Scans/Rechnung.pdf"},
+		{"space", "Rechnung 1.pdf", "/Rechnung%201.pdf"},
+		{"NFD umlaut", "Müller.pdf", "/Mu%CC%88ller.pdf"},
+		{"NFC umlaut", "Müller.pdf", "/M%C3%BCller.pdf"},
+		{"emoji", "📄.pdf", "/%F0%9F%93%84.pdf"},
+		{"CJK", "請求書/書.pdf", "/%E8%AB%8B%E6%B1%82%E6%9B%B8/%E6%9B%B8.pdf"},
+		{"hash", "Rechnung #12.pdf", "/Rechnung%20%2312.pdf"},
+		{"question mark", "Was?.pdf", "/Was%3F.pdf"},
+		{"invalid UTF-8", "Scan\xff.pdf", "/Scan%FF.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.URL(tt.path)
+			if got != s.Root+tt.want {
+				t.Fatalf("URL(%q) = %q, want %q", tt.path, got, s.Root+tt.want)
+			}
+			u, err := url.Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "/remote.php/dav/files/user/" + strings.Trim(strings.ReplaceAll(tt.path, "//", "/"), "/")
+			if u.Path != want {
+				t.Errorf("URL(%q) parses to path %q, want %q", tt.path, u.Path, want)
+			}
+		})
+	}
+}
+
+func TestFormBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+	}{
+		{"plain", "Rechnung.pdf"},
+		{"NFD umlaut", "Müller.pdf"},
+		{"emoji", "Scan 📄.pdf"},
+		{"CJK", "請求書.pdf"},
+		{"hash", "Rechnung #12.pdf"},
+		{"question mark", "Was?.pdf"},
+		{"quotes", `Der "Brief".pdf`},
+		{"invalid UTF-8", "Scan\xff.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "%PDF-1.7 test"
+			values := url.Values{"title": {tt.filename}}
+			body, contentType, size := formBody("file", tt.filename, strings.NewReader(content), int64(len(content)), values)
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(data)) != size {
+				t.Errorf("length %d, body has %d bytes", size, len(data))
+			}
+
+			_, params, err := mime.ParseMediaType(contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := multipart.NewReader(strings.NewReader(string(data)), params["boundary"])
+			form, err := r.ReadForm(1 << 20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer form.RemoveAll()
+			if got := form.Value["title"]; len(got) != 1 || got[0] != tt.filename {
+				t.Errorf("title = %q, want %q", got, tt.filename)
+			}
+			files := form.File["file"]
+			if len(files) != 1 {
+				t.Fatalf("got %d files, want 1", len(files))
+			}
+			if files[0].Filename != tt.filename {
+				t.Errorf("filename = %q, want %q", files[0].Filename, tt.filename)
+			}
+			f, err := files[0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if got, _ := io.ReadAll(f); string(got) != content {
+				t.Errorf("content = %q, want %q", got, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package watcher
+
+import "github.com/rjeczalik/notify"
+
+// defaultEvents are the inotify events of finished files.
+var defaultEvents = []string{"close-write", "moved-to"}
+
+var eventNames = map[string]notify.Event{
+	"close-write": notify.InCloseWrite,
+	"moved-to":    notify.InMovedTo,
+	"create":      notify.Create,
+	"write":       notify.Write,
+	"rename":      notify.Rename,
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package watcher
+
+import "github.com/rjeczalik/notify"
+
+// defaultEvents are the portable events of new and changed files.
+var defaultEvents = []string{"create", "write", "rename"}
+
+// close-write and moved-to are inotify events
+var eventNames = map[string]notify.Event{
+	"create": notify.Create,
+	"write":  notify.Write,
+	"rename": notify.Rename,
+}
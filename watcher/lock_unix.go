@@ -0,0 +1,30 @@
+//go:build !windows
+
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Lock takes an exclusive flock on dir itself, so a second process watching
+// the same directory can refuse to start. The lock is held until the
+// returned file is closed or the process exits, so there is no stale lock
+// file after a crash.
+func Lock(dir string) (io.Closer, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s is watched by another instance already", dir)
+		}
+		return nil, err
+	}
+	return f, nil
+}
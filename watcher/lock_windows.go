@@ -0,0 +1,44 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// mutex is a named mutex handle.
+type mutex windows.Handle
+
+func (m mutex) Close() error {
+	return windows.CloseHandle(windows.Handle(m))
+}
+
+// Lock creates a named mutex for dir, so a second process watching the
+// same directory can refuse to start. Windows can't lock directories. The
+// mutex is held until the returned handle is closed or the process exits.
+func Lock(dir string) (io.Closer, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(abs)))
+	name, err := windows.UTF16PtrFromString(`Global\scan2webdav-` + hex.EncodeToString(sum[:8]))
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateMutex(nil, false, name)
+	if errors.Is(err, windows.ERROR_ALREADY_EXISTS) {
+		windows.CloseHandle(h)
+		return nil, fmt.Errorf("%s is watched by another instance already", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mutex(h), nil
+}
@@ -0,0 +1,143 @@
+// Package watcher finds the files dropped into a directory: the ones that
+// are there already and the ones that are written or moved there later.
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/rjeczalik/notify"
+)
+
+// Filter selects files by their base name.
+type Filter struct {
+	Include []string // name patterns, all files if empty
+	Exclude []string
+	Always  string // name that passes regardless of the patterns
+}
+
+// Match applies the filter to the base name of path.
+func (f Filter) Match(path string) bool {
+	name := filepath.Base(path)
+	if f.Always != "" && name == f.Always {
+		return true
+	}
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls fn for the regular files below root that pass keep, which
+// sees directories as well. Subfolders are only entered if recursive.
+func Walk(root string, recursive bool, keep func(path string) bool, fn func(path string)) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Unable to scan watcher path", "path", path, "error", err)
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (!recursive || !keep(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && keep(path) {
+			fn(path)
+		}
+		return nil
+	})
+}
+
+// Events returns the notify events for names, the default events of the
+// system if names is empty.
+func Events(names []string) ([]notify.Event, error) {
+	if len(names) == 0 {
+		names = defaultEvents
+	}
+	var events []notify.Event
+	for _, name := range names {
+		e, ok := eventNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unsupported watch event %q", name)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Watcher reports files that are closed after writing or moved into the
+// watched directory. Other systems than Linux don't report the close, the
+// files are reported on every write there.
+type Watcher struct {
+	Events <-chan string
+	c      chan notify.EventInfo
+	done   chan struct{}
+}
+
+// Watch starts watching dir and, if recursive, its subfolders for the
+// events named in events, see Events.
+func Watch(dir string, recursive bool, names []string) (*Watcher, error) {
+	events, err := Events(names)
+	if err != nil {
+		return nil, err
+	}
+	// FSEvents reports resolved paths, /private/tmp for /tmp on macOS, but
+	// the events should match the configured path
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	rewrite := func(path string) string {
+		if rel, err := filepath.Rel(real, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(dir, rel)
+		}
+		return path
+	}
+
+	pattern := real
+	if recursive {
+		pattern = filepath.Join(pattern, "...")
+	}
+	// Make the channel buffered to ensure no event is dropped. Notify will
+	// drop an event if the receiver is not able to keep up the sending pace.
+	c := make(chan notify.EventInfo, 1)
+	if err := notify.Watch(pattern, c, events...); err != nil {
+		return nil, err
+	}
+	out := make(chan string)
+	w := &Watcher{Events: out, c: c, done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case ei := <-c:
+				select {
+				case out <- rewrite(ei.Path()):
+				case <-w.done:
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Stop ends the watch, no events are sent afterwards.
+func (w *Watcher) Stop() {
+	notify.Stop(w.c)
+	close(w.done)
+}